@@ -1,27 +1,170 @@
 package api
 
 import (
-	"log"
+	"context"
 	"net/http"
 
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/config"
 	"github.com/FishDontExist/TONindexer/controllers"
+	"github.com/FishDontExist/TONindexer/deposits"
+	"github.com/FishDontExist/TONindexer/dumps"
+	"github.com/FishDontExist/TONindexer/events"
+	"github.com/FishDontExist/TONindexer/idempotency"
+	"github.com/FishDontExist/TONindexer/index"
+	"github.com/FishDontExist/TONindexer/keystore"
+	"github.com/FishDontExist/TONindexer/payouts"
+	"github.com/FishDontExist/TONindexer/repair"
+	"github.com/FishDontExist/TONindexer/retry"
+	"github.com/FishDontExist/TONindexer/sweep"
+	"github.com/FishDontExist/TONindexer/transfers"
+	"github.com/FishDontExist/TONindexer/webhooks"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
 )
 
-func SetApi() {
+func SetApi(cfg *config.Config, lg zerolog.Logger) error {
 	r := mux.NewRouter()
-	lt := controllers.New()
+	r.Use(withMetrics, withRequestID(lg), withRecover)
+	r.NotFoundHandler = withMetrics(withRequestID(lg)(http.HandlerFunc(controllers.NotFound)))
+	r.MethodNotAllowedHandler = withMetrics(withRequestID(lg)(http.HandlerFunc(controllers.MethodNotAllowed)))
+	ln, err := chain.New(cfg, lg)
+	if err != nil {
+		return err
+	}
+	hooks, err := webhooks.New(cfg, ln, lg)
+	if err != nil {
+		return err
+	}
+	go hooks.Run(context.Background())
+	// a send request is over well before twice its timeout
+	idem, err := idempotency.Open(cfg.Storage.Dir, 2*cfg.HTTP.SendTimeout.Duration, lg)
+	if err != nil {
+		return err
+	}
+	tracker, err := transfers.New(cfg, ln, lg)
+	if err != nil {
+		return err
+	}
+	go tracker.Run(context.Background())
+
+	var keys *keystore.Store
+	var registry *deposits.Registry
+	var sweeper *sweep.Sweeper
+	var payout *payouts.Service
+	if cfg.Keystore.Passphrase != "" {
+		if keys, err = keystore.Open(cfg.Storage.Dir, cfg.Keystore.Passphrase); err != nil {
+			return err
+		}
+		if registry, err = deposits.Open(cfg, keys, ln, hooks, lg); err != nil {
+			return err
+		}
+		if cfg.Sweep.Enabled {
+			if sweeper, err = sweep.New(cfg, ln, registry, lg); err != nil {
+				return err
+			}
+			go sweeper.Run(context.Background())
+		}
+		if cfg.Payouts.Wallet != "" {
+			if payout, err = payouts.New(cfg, keys, ln, lg); err != nil {
+				return err
+			}
+			if !cfg.Scanner.Enabled {
+				lg.Warn().Msg("scanner is disabled, payout items won't be tracked past submission")
+			}
+			go payout.Run(context.Background())
+		}
+		if cfg.API.Token == "" {
+			lg.Warn().Msg("api token is not set, wallet, transfer and deposit endpoints are disabled")
+		}
+	} else {
+		lg.Warn().Msg("keystore passphrase is not set, wallet, transfer and deposit endpoints are disabled")
+	}
+
+	var hub *events.Hub
+	var scanner *dumps.Scanner
+	var repairer *repair.Repairer
+	history := events.NewHistory(cfg.Stream.History)
+	if cfg.Scanner.Enabled {
+		idx, err := index.Open(cfg.Storage.Dir, cfg.Storage.IndexBlocks)
+		if err != nil {
+			return err
+		}
+		defer idx.Close()
+
+		queue, err := retry.Open(cfg.Storage.Dir, "repair.json", retry.Backoff{
+			MaxAttempts:    cfg.Repair.MaxAttempts,
+			InitialBackoff: cfg.Repair.InitialBackoff.Duration,
+			MaxBackoff:     cfg.Repair.MaxBackoff.Duration,
+		}, lg.With().Str("component", "retry").Logger())
+		if err != nil {
+			return err
+		}
+
+		hub = events.NewHub(cfg.Stream.Buffer)
+		scanner, repairer = startScanner(context.Background(), cfg, ln, hub, history, idx, hooks, payout, tracker, queue, lg)
+	}
+	lt := controllers.New(cfg, controllers.Services{
+		Chain:       ln,
+		Hub:         hub,
+		History:     history,
+		Webhooks:    hooks,
+		Repair:      repairer,
+		Scanner:     scanner,
+		Keys:        keys,
+		Deposits:    registry,
+		Sweeper:     sweeper,
+		Payouts:     payout,
+		Idempotency: idem,
+		Transfers:   tracker,
+	})
 	r.HandleFunc("/ping/", controllers.Ping).Methods("GET")
+	r.HandleFunc("/healthz", controllers.Healthz).Methods("GET")
+	r.HandleFunc("/readyz", lt.Readyz).Methods("GET")
 	r.HandleFunc("/height/", lt.GetHeight).Methods("GET")
 	r.HandleFunc("/wallet/", lt.GenerateNewWallet).Methods("GET")
+	r.HandleFunc("/wallets/", lt.ListWallets).Methods("GET")
+	r.HandleFunc("/wallets/", lt.CreateWallet).Methods("POST")
+	r.HandleFunc("/wallets/derive", lt.DeriveAddress).Methods("POST")
+	r.HandleFunc("/deposits/", lt.ListDeposits).Methods("GET")
+	r.HandleFunc("/deposits/{user_id}", lt.ListDeposits).Methods("GET")
+	r.HandleFunc("/deposits/{user_id}/{index}", lt.GetDeposit).Methods("GET")
+	r.HandleFunc("/sweeps/", lt.ListSweeps).Methods("GET")
+	r.HandleFunc("/payouts/batch", lt.SubmitPayouts).Methods("POST")
+	r.HandleFunc("/payouts/batch/{id}", lt.GetPayouts).Methods("GET")
+	r.HandleFunc("/payouts/wallet", lt.PayoutWallet).Methods("GET")
+	r.HandleFunc("/transfers/", lt.ListTransfers).Methods("GET")
+	r.HandleFunc("/transfers/{id}", lt.GetTransfer).Methods("GET")
+	r.HandleFunc("/wallets/{id}", lt.GetWallet).Methods("GET")
+	r.HandleFunc("/wallets/{id}/transactions", lt.WalletTransactions).Methods("GET")
 	r.HandleFunc("/sendtx/", lt.SendTransactionV2).Methods("POST")
 	r.HandleFunc("/transactions/", lt.GetBlockTransactions).Methods("POST")
 	r.HandleFunc("/sendjetton/", lt.SendJetton).Methods("POST")
-	r.HandleFunc("/gettxbyhash/", controllers.GetTransactionByHash).Methods("POST")
+	r.HandleFunc("/gettxbyhash/", lt.GetTransactionByHash).Methods("POST")
 	r.HandleFunc("/getbalance/", lt.GetBalance).Methods("POST")
 	r.HandleFunc("/gettxforaddr/", lt.GetTransactionForAddr).Methods("POST")
-	
-	http.Handle("/", r)
-	log.Println("Listening on port 8000")
-	log.Fatal(http.ListenAndServe(":8000", r))
+	r.HandleFunc("/ws", lt.Subscribe).Methods("GET")
+	r.HandleFunc("/blocks/stream", lt.StreamBlocks).Methods("GET")
+	r.HandleFunc("/status/gaps", lt.Gaps).Methods("GET")
+	r.HandleFunc("/status/retries", lt.Retries).Methods("GET")
+	r.HandleFunc("/watchlist/", lt.AddWatch).Methods("POST")
+	r.HandleFunc("/watchlist/", lt.ListWatches).Methods("GET")
+	r.HandleFunc("/watchlist/{address}", lt.RemoveWatch).Methods("DELETE")
+	r.HandleFunc("/webhooks/dead/", lt.DeadLetters).Methods("GET")
+	r.HandleFunc("/webhooks/replay/", lt.ReplayWebhooks).Methods("POST")
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	r.HandleFunc("/admin/log-level", lt.GetLogLevel).Methods("GET")
+	r.HandleFunc("/admin/log-level", lt.SetLogLevel).Methods("PUT")
+	r.HandleFunc("/admin/wallets/{id}/export", lt.ExportWallet).Methods("POST")
+	r.HandleFunc("/admin/sweeps/run", lt.RunSweep).Methods("POST")
+
+	srv := &http.Server{
+		Addr:         cfg.HTTP.Addr,
+		Handler:      r,
+		ReadTimeout:  cfg.HTTP.ReadTimeout.Duration,
+		WriteTimeout: cfg.HTTP.WriteTimeout.Duration,
+	}
+	lg.Info().Str("addr", cfg.HTTP.Addr).Msg("listening")
+	return srv.ListenAndServe()
 }
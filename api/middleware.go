@@ -0,0 +1,109 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/controllers"
+	"github.com/FishDontExist/TONindexer/metrics"
+	"github.com/FishDontExist/TONindexer/requestid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+// withRequestID keeps the caller's X-Request-ID or assigns a new one, and
+// makes it available to handlers through the request context, along with a
+// logger that adds it to every entry.
+func withRequestID(lg zerolog.Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(requestid.Header)
+			if id == "" {
+				id = requestid.New()
+			}
+			w.Header().Set(requestid.Header, id)
+			ctx := requestid.With(r.Context(), id)
+			ctx = lg.With().Str("request_id", id).Logger().WithContext(ctx)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// withRecover turns a panicking handler into a 500 response instead of
+// taking the whole server down.
+func withRecover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				zerolog.Ctx(r.Context()).Error().Str("method", r.Method).Str("path", r.URL.Path).
+					Interface("panic", v).Bytes("stack", debug.Stack()).Msg("handler panicked")
+				controllers.InternalError(w, r, fmt.Errorf("panic: %v", v))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withMetrics counts requests and their latency by route template, so ids
+// in the path don't create a series per request.
+func withMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		if cur := mux.CurrentRoute(r); cur != nil {
+			if tpl, err := cur.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		defer func() {
+			metrics.HTTPDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+			metrics.HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).Inc()
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// statusWriter remembers the response status. It unwraps for
+// http.ResponseController and can be hijacked for WebSocket upgrades.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer can't be hijacked")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.status, w.wroteHeader = http.StatusSwitchingProtocols, true
+	}
+	return conn, rw, err
+}
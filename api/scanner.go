@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/dumps"
+	"github.com/FishDontExist/TONindexer/events"
+	"github.com/FishDontExist/TONindexer/index"
+	"github.com/FishDontExist/TONindexer/payouts"
+	"github.com/FishDontExist/TONindexer/repair"
+	"github.com/FishDontExist/TONindexer/retry"
+	"github.com/FishDontExist/TONindexer/transfers"
+	"github.com/FishDontExist/TONindexer/webhooks"
+	"github.com/rs/zerolog"
+	"github.com/xssnick/tonutils-go/address"
+)
+
+// startScanner runs the block scanner in the background and publishes what
+// it finds to hub, the block history, the index, the deposit webhooks, the
+// transfer tracker and the payout tracker, which is nil when payouts are
+// disabled.
+// It resumes after the last indexed block and rescans failed shard blocks
+// with the returned repairer.
+func startScanner(ctx context.Context, cfg *config.Config, ln *chain.LiteClient, hub *events.Hub, history *events.History, idx *index.Index, hooks *webhooks.Service, payout *payouts.Service, tracker *transfers.Tracker, queue *retry.Queue, lg zerolog.Logger) (*dumps.Scanner, *repair.Repairer) {
+	var resume uint32
+	if span, ok := idx.Span(); ok {
+		resume = span.To + 1
+	}
+	scanner := dumps.NewScanner(ln.API(), nil, resume, cfg, lg.With().Str("component", "scanner").Logger())
+	repairer := repair.New(cfg, scanner, idx, queue, lg.With().Str("component", "repair").Logger())
+	lg = lg.With().Str("component", "events").Logger()
+
+	ch := make(chan any, cfg.Scanner.TaskPoolSize)
+	go func() {
+		// the liteserver pool may still be connecting
+		for {
+			err := scanner.Start(ctx, ch)
+			if err == nil {
+				return
+			}
+			lg.Warn().Err(err).Msg("start scanner")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(cfg.Chain.ReconnectDelay.Duration):
+			}
+		}
+	}()
+	go repairer.Run(ctx, ch)
+	pub := make(chan any, cfg.Scanner.TaskPoolSize)
+	go publishEvents(ctx, ln, hub, pub, cfg.HTTP.RequestTimeout.Duration, lg)
+	go forwardEvents(ctx, hub, history, idx, hooks, payout, tracker, repairer, ch, pub, lg)
+	return scanner, repairer
+}
+
+// forwardEvents hands the events of ch to everything that follows the
+// chain, and to publishEvents through pub for the streams.
+func forwardEvents(ctx context.Context, hub *events.Hub, history *events.History, idx *index.Index, hooks *webhooks.Service, payout *payouts.Service, tracker *transfers.Tracker, repairer *repair.Repairer, ch <-chan any, pub chan<- any, lg zerolog.Logger) {
+	for {
+		var ev any
+		select {
+		case <-ctx.Done():
+			return
+		case ev = <-ch:
+		}
+
+		switch e := ev.(type) {
+		case dumps.TransactionEvent:
+			tracker.HandleTransaction(e.Addr, e.Tx)
+			if payout != nil {
+				payout.HandleTransaction(e.Addr, e.Tx)
+			}
+			// deposits are decoded and verified by the webhooks worker
+			hooks.HandleTransaction(e.Addr, e.Tx, e.Master.SeqNo)
+			// decoding may hit the liteserver, skip it when nobody listens
+			if hub.WantsTransactions() {
+				publish(pub, e, lg)
+			}
+		case dumps.BlockEvent:
+			// add before publishing, so a stream that subscribed meanwhile
+			// finds the block in either of them
+			b := blockEvent(e)
+			if err := idx.Add(b); err != nil {
+				lg.Error().Err(err).Uint32("seqno", b.Seqno).Msg("index block")
+			}
+			history.Add(b)
+			publish(pub, b, lg)
+			hooks.HandleBlock(e.Master.SeqNo)
+		case dumps.ShardFailedEvent:
+			repairer.Failed(e)
+		case dumps.AccountFailedEvent:
+			repairer.AccountFailed(e)
+		}
+	}
+}
+
+// publish hands ev to publishEvents without waiting, streams miss it while
+// publishing is behind.
+func publish(pub chan<- any, ev any, lg zerolog.Logger) {
+	select {
+	case pub <- ev:
+	default:
+		lg.Warn().Msg("event streams behind, event dropped")
+	}
+}
+
+// publishEvents publishes the events of pub to hub in order until ctx is
+// done, decoding transactions on the way, which may hit the liteserver.
+func publishEvents(ctx context.Context, ln *chain.LiteClient, hub *events.Hub, pub <-chan any, timeout time.Duration, lg zerolog.Logger) {
+	for {
+		var ev any
+		select {
+		case <-ctx.Done():
+			return
+		case ev = <-pub:
+		}
+		if e, ok := ev.(dumps.TransactionEvent); ok {
+			t, err := decodeEvent(ctx, ln, e, timeout)
+			if err != nil {
+				lg.Warn().Err(err).Hex("tx", e.Tx.Hash).Msg("decode transaction")
+				continue
+			}
+			ev = t
+		}
+		hub.Publish(ev)
+	}
+}
+
+func decodeEvent(ctx context.Context, ln *chain.LiteClient, e dumps.TransactionEvent, timeout time.Duration) (events.Transaction, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	info, err := ln.DecodeTransaction(ctx, e.Addr, e.Tx)
+	if err != nil {
+		return events.Transaction{}, err
+	}
+	t := events.Transaction{Account: chain.RawAddr(e.Addr), Tx: info}
+	if info.Jetton != nil && info.Jetton.Master != "" {
+		if master, err := address.ParseAddr(info.Jetton.Master); err == nil {
+			t.JettonMaster = chain.RawAddr(master)
+		}
+	}
+	return t, nil
+}
+
+func blockEvent(e dumps.BlockEvent) events.Block {
+	shards := make([]events.Shard, 0, len(e.Shards))
+	for _, s := range e.Shards {
+		sh := events.NewShard(s.ID, s.GenUtime, s.Transactions)
+		sh.Failed = s.Failed
+		shards = append(shards, sh)
+	}
+	return events.NewBlock(e.Master, e.GenUtime, shards)
+}
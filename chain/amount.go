@@ -0,0 +1,115 @@
+package chain
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/xssnick/tonutils-go/tlb"
+)
+
+// Units of TON amounts in requests. Amounts are decimal strings, TON ones
+// have up to 9 decimals and nanoton ones none.
+const (
+	UnitTON     = "ton"
+	UnitNanoton = "nanoton"
+)
+
+// Units of jetton amounts in requests. UnitJetton amounts have up to the
+// decimals of the jetton, base unit ones none.
+const (
+	UnitJetton = "jetton"
+	UnitBase   = "base"
+)
+
+// maxAmountBits bounds amounts, coins are serialized as var uint 16.
+const maxAmountBits = 120
+
+// ParseDecimal parses the non-negative decimal s with at most decimals
+// digits after the point into base units, exactly.
+func ParseDecimal(s string, decimals int) (*big.Int, error) {
+	if strings.HasPrefix(s, "-") {
+		return nil, fmt.Errorf("%w: amount %q is negative", ErrInvalidInput, s)
+	}
+	whole, frac, dot := strings.Cut(s, ".")
+	if !isDigits(whole) || (dot && !isDigits(frac)) {
+		return nil, fmt.Errorf("%w: amount %q is not a decimal number", ErrInvalidInput, s)
+	}
+	if len(frac) > decimals {
+		return nil, fmt.Errorf("%w: amount %q has more than %d decimals", ErrInvalidInput, s, decimals)
+	}
+	v, _ := new(big.Int).SetString(whole+frac+strings.Repeat("0", decimals-len(frac)), 10)
+	if v.BitLen() > maxAmountBits {
+		return nil, fmt.Errorf("%w: amount %q is too large", ErrInvalidInput, s)
+	}
+	return v, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTON parses a positive TON amount in unit, UnitTON when empty.
+func ParseTON(s, unit string) (tlb.Coins, error) {
+	var decimals int
+	switch unit {
+	case "", UnitTON:
+		decimals = 9
+	case UnitNanoton:
+	default:
+		return tlb.Coins{}, fmt.Errorf("%w: unit must be %s or %s, got %q", ErrInvalidInput, UnitTON, UnitNanoton, unit)
+	}
+	v, err := ParseDecimal(s, decimals)
+	if err != nil {
+		return tlb.Coins{}, err
+	}
+	if v.Sign() == 0 {
+		return tlb.Coins{}, fmt.Errorf("%w: amount must be positive", ErrInvalidInput)
+	}
+	return tlb.FromNano(v, 9)
+}
+
+// ParseJetton parses a positive amount of a jetton with decimals in unit,
+// UnitJetton when empty, into base units.
+func ParseJetton(s, unit string, decimals int) (*big.Int, error) {
+	switch unit {
+	case "", UnitJetton:
+	case UnitBase:
+		decimals = 0
+	default:
+		return nil, fmt.Errorf("%w: unit must be %s or %s, got %q", ErrInvalidInput, UnitJetton, UnitBase, unit)
+	}
+	v, err := ParseDecimal(s, decimals)
+	if err != nil {
+		return nil, err
+	}
+	if v.Sign() == 0 {
+		return nil, fmt.Errorf("%w: amount must be positive", ErrInvalidInput)
+	}
+	return v, nil
+}
+
+// FormatDecimal formats v base units as a decimal with decimals digits after
+// the point, without trailing zeros.
+func FormatDecimal(v *big.Int, decimals int) string {
+	s := new(big.Int).Abs(v).String()
+	if len(s) <= decimals {
+		s = strings.Repeat("0", decimals-len(s)+1) + s
+	}
+	whole, frac := s[:len(s)-decimals], strings.TrimRight(s[len(s)-decimals:], "0")
+	if v.Sign() < 0 {
+		whole = "-" + whole
+	}
+	if frac == "" {
+		return whole
+	}
+	return whole + "." + frac
+}
@@ -0,0 +1,161 @@
+package chain
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+	// the largest amount that fits maxAmountBits
+	maxAmount := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), maxAmountBits), big.NewInt(1))
+
+	tests := []struct {
+		in       string
+		decimals int
+		want     string
+		wantErr  bool
+	}{
+		{in: "0", decimals: 9, want: "0"},
+		{in: "1", decimals: 9, want: "1000000000"},
+		{in: "1.5", decimals: 9, want: "1500000000"},
+		{in: "0.000000001", decimals: 9, want: "1"},
+		{in: "12.345", decimals: 6, want: "12345000"},
+		{in: "007", decimals: 0, want: "7"},
+		{in: "1.", decimals: 9, wantErr: true},
+		{in: ".5", decimals: 9, wantErr: true},
+		{in: "", decimals: 9, wantErr: true},
+		{in: "1e9", decimals: 9, wantErr: true},
+		{in: "+1", decimals: 9, wantErr: true},
+		{in: " 1", decimals: 9, wantErr: true},
+		{in: "-1", decimals: 9, wantErr: true},
+		{in: "-0", decimals: 9, wantErr: true},
+		{in: "0.0000000001", decimals: 9, wantErr: true},
+		{in: "1.1", decimals: 0, wantErr: true},
+		{in: "1.1234567", decimals: 6, wantErr: true},
+		{in: maxAmount.String(), decimals: 0, want: maxAmount.String()},
+		{in: new(big.Int).Add(maxAmount, big.NewInt(1)).String(), decimals: 0, wantErr: true},
+		{in: "1" + strings.Repeat("0", 40), decimals: 9, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDecimal(tt.in, tt.decimals)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("ParseDecimal(%q, %d) error = %v, want ErrInvalidInput", tt.in, tt.decimals, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDecimal(%q, %d) error = %v", tt.in, tt.decimals, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("ParseDecimal(%q, %d) = %s, want %s", tt.in, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestParseTON(t *testing.T) {
+	tests := []struct {
+		amount, unit string
+		want         string
+		wantErr      bool
+	}{
+		{amount: "1.25", unit: "", want: "1250000000"},
+		{amount: "1.25", unit: UnitTON, want: "1250000000"},
+		{amount: "1250", unit: UnitNanoton, want: "1250"},
+		{amount: "1.5", unit: UnitNanoton, wantErr: true},
+		{amount: "0", unit: UnitTON, wantErr: true},
+		{amount: "0.0", unit: UnitTON, wantErr: true},
+		{amount: "-1", unit: UnitTON, wantErr: true},
+		{amount: "0.0000000001", unit: UnitTON, wantErr: true},
+		{amount: "1", unit: "grams", wantErr: true},
+		{amount: "1" + strings.Repeat("0", 28), unit: UnitTON, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTON(tt.amount, tt.unit)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("ParseTON(%q, %q) error = %v, want ErrInvalidInput", tt.amount, tt.unit, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTON(%q, %q) error = %v", tt.amount, tt.unit, err)
+			continue
+		}
+		if got.Nano().String() != tt.want {
+			t.Errorf("ParseTON(%q, %q) = %s nanoton, want %s", tt.amount, tt.unit, got.Nano(), tt.want)
+		}
+	}
+}
+
+func TestParseJetton(t *testing.T) {
+	tests := []struct {
+		amount, unit string
+		decimals     int
+		want         string
+		wantErr      bool
+	}{
+		{amount: "1.5", unit: "", decimals: 9, want: "1500000000"},
+		{amount: "1.5", unit: UnitJetton, decimals: 6, want: "1500000"},
+		{amount: "1.5", unit: UnitJetton, decimals: 0, wantErr: true},
+		{amount: "1500", unit: UnitBase, decimals: 6, want: "1500"},
+		{amount: "1.5", unit: UnitBase, decimals: 6, wantErr: true},
+		{amount: "0.0000001", unit: UnitJetton, decimals: 6, wantErr: true},
+		{amount: "0", unit: UnitJetton, decimals: 6, wantErr: true},
+		{amount: "-1", unit: UnitJetton, decimals: 6, wantErr: true},
+		{amount: "1", unit: UnitTON, decimals: 6, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseJetton(tt.amount, tt.unit, tt.decimals)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("ParseJetton(%q, %q, %d) error = %v, want ErrInvalidInput", tt.amount, tt.unit, tt.decimals, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseJetton(%q, %q, %d) error = %v", tt.amount, tt.unit, tt.decimals, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("ParseJetton(%q, %q, %d) = %s, want %s", tt.amount, tt.unit, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestFormatDecimal(t *testing.T) {
+	tests := []struct {
+		v        int64
+		decimals int
+		want     string
+	}{
+		{v: 0, decimals: 9, want: "0"},
+		{v: 1, decimals: 9, want: "0.000000001"},
+		{v: 1500000000, decimals: 9, want: "1.5"},
+		{v: 2000000000, decimals: 9, want: "2"},
+		{v: 1234567, decimals: 6, want: "1.234567"},
+		{v: 42, decimals: 0, want: "42"},
+		{v: -1500000000, decimals: 9, want: "-1.5"},
+		{v: -1, decimals: 9, want: "-0.000000001"},
+	}
+	for _, tt := range tests {
+		if got := FormatDecimal(big.NewInt(tt.v), tt.decimals); got != tt.want {
+			t.Errorf("FormatDecimal(%d, %d) = %q, want %q", tt.v, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestFormatDecimalRoundTrip(t *testing.T) {
+	for _, s := range []string{"0", "1", "0.5", "123.000001", "1000000"} {
+		v, err := ParseDecimal(s, 6)
+		if err != nil {
+			t.Fatalf("ParseDecimal(%q, 6) error = %v", s, err)
+		}
+		if got := FormatDecimal(v, 6); got != s {
+			t.Errorf("FormatDecimal(ParseDecimal(%q)) = %q", s, got)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package chain
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/ton/jetton"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+// Jetton message opcodes from TEP-74.
+const (
+	OpJettonTransfer             = 0x0f8a7ea5
+	OpJettonInternalTransfer     = 0x178d4519
+	OpJettonTransferNotification = 0x7362d09c
+	OpJettonBurn                 = 0x595f07bc
+)
+
+type TxInfo struct {
+	Account string    `json:"account"`
+	Hash    string    `json:"hash"`
+	LT      uint64    `json:"lt"`
+	Now     uint32    `json:"now"`
+	Aborted bool      `json:"aborted"`
+	In      *MsgInfo  `json:"in_msg,omitempty"`
+	Out     []MsgInfo `json:"out_msgs,omitempty"`
+	// Jetton is set when the incoming message is a jetton transfer.
+	Jetton *JettonTransfer `json:"jetton,omitempty"`
+}
+
+type MsgInfo struct {
+	Type    string `json:"type"`
+	Src     string `json:"src,omitempty"`
+	Dst     string `json:"dst,omitempty"`
+	Amount  string `json:"amount,omitempty"`
+	Bounce  bool   `json:"bounce,omitempty"`
+	Bounced bool   `json:"bounced,omitempty"`
+	Op      uint32 `json:"op,omitempty"`
+	Comment string `json:"comment,omitempty"`
+	// Encrypted is set for encrypted comments, Comment then holds the text
+	// only where it was decrypted for a managed wallet.
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+type JettonTransfer struct {
+	Kind string `json:"kind"`
+	// Master is the jetton master, empty when it could not be resolved.
+	Master string `json:"master,omitempty"`
+	// Wallet is the jetton wallet that sent or received the message.
+	Wallet string `json:"wallet"`
+	// Amount is in jetton base units.
+	Amount string `json:"amount"`
+	// Sender is the owner that initiated the transfer, when known.
+	Sender string `json:"sender,omitempty"`
+}
+
+// DecodeTransaction converts tx of account addr into its json form. Jetton
+// masters are resolved through the liteserver and cached.
+func (l *LiteClient) DecodeTransaction(ctx context.Context, addr *address.Address, tx *tlb.Transaction) (*TxInfo, error) {
+	info := &TxInfo{
+		Account: l.formatAddr(addr),
+		Hash:    hex.EncodeToString(tx.Hash),
+		LT:      tx.LT,
+		Now:     tx.Now,
+	}
+	if d, ok := tx.Description.Description.(tlb.TransactionDescriptionOrdinary); ok {
+		info.Aborted = d.Aborted
+	}
+
+	if tx.IO.In != nil {
+		in := l.decodeMessage(tx.IO.In)
+		info.In = &in
+	}
+	if tx.IO.Out != nil {
+		out, err := tx.IO.Out.ToSlice()
+		if err != nil {
+			return nil, fmt.Errorf("load out messages: %w", err)
+		}
+		for i := range out {
+			info.Out = append(info.Out, l.decodeMessage(&out[i]))
+		}
+	}
+
+	if tx.IO.In != nil && tx.IO.In.MsgType == tlb.MsgTypeInternal {
+		jt, err := l.decodeJetton(ctx, addr, tx.IO.In.AsInternal())
+		if err != nil {
+			return nil, err
+		}
+		info.Jetton = jt
+	}
+	return info, nil
+}
+
+func (l *LiteClient) decodeMessage(msg *tlb.Message) MsgInfo {
+	m := MsgInfo{Type: string(msg.MsgType)}
+	if src := msg.Msg.SenderAddr(); src != nil && !src.IsAddrNone() {
+		m.Src = l.formatAddr(src)
+	}
+	if dst := msg.Msg.DestAddr(); dst != nil && !dst.IsAddrNone() {
+		m.Dst = l.formatAddr(dst)
+	}
+	if msg.MsgType == tlb.MsgTypeInternal {
+		in := msg.AsInternal()
+		m.Amount = in.Amount.Nano().String()
+		m.Bounce = in.Bounce
+		m.Bounced = in.Bounced
+		m.Comment = in.Comment()
+		m.Encrypted = isEncryptedComment(in.Body)
+	}
+	m.Op = opcode(msg.Msg.Payload())
+	return m
+}
+
+func opcode(body *cell.Cell) uint32 {
+	if body == nil {
+		return 0
+	}
+	op, err := body.BeginParse().LoadUInt(32)
+	if err != nil {
+		return 0
+	}
+	return uint32(op)
+}
+
+func (l *LiteClient) decodeJetton(ctx context.Context, account *address.Address, in *tlb.InternalMessage) (*JettonTransfer, error) {
+	if in.Body == nil || in.Bounced {
+		return nil, nil
+	}
+
+	var jt *JettonTransfer
+	var jettonWallet *address.Address
+	switch opcode(in.Body) {
+	case OpJettonTransferNotification:
+		var n jetton.TransferNotification
+		if err := tlb.LoadFromCell(&n, in.Body.BeginParse()); err != nil {
+			return nil, nil
+		}
+		jettonWallet = in.SrcAddr
+		jt = &JettonTransfer{Kind: "transfer_notification", Amount: n.Amount.Nano().String()}
+		if n.Sender != nil && !n.Sender.IsAddrNone() {
+			jt.Sender = l.formatAddr(n.Sender)
+		}
+	case OpJettonTransfer:
+		var p jetton.TransferPayload
+		if err := tlb.LoadFromCell(&p, in.Body.BeginParse()); err != nil {
+			return nil, nil
+		}
+		jettonWallet = account
+		jt = &JettonTransfer{Kind: "transfer", Amount: p.Amount.Nano().String(), Sender: l.formatAddr(in.SrcAddr)}
+	case OpJettonInternalTransfer:
+		s := in.Body.BeginParse()
+		if _, err := s.LoadUInt(32 + 64); err != nil {
+			return nil, nil
+		}
+		amount, err := s.LoadBigCoins()
+		if err != nil {
+			return nil, nil
+		}
+		jettonWallet = account
+		jt = &JettonTransfer{Kind: "internal_transfer", Amount: amount.String()}
+		if from, err := s.LoadAddr(); err == nil && !from.IsAddrNone() {
+			jt.Sender = l.formatAddr(from)
+		}
+	default:
+		return nil, nil
+	}
+
+	jt.Wallet = l.formatAddr(jettonWallet)
+	master, err := l.jettonMasterOf(ctx, jettonWallet)
+	if err != nil {
+		return nil, err
+	}
+	if master != nil {
+		jt.Master = l.formatAddr(master)
+	}
+	return jt, nil
+}
+
+// jettonMasters caches jetton wallet -> master lookups. A nil value means the
+// account is not a jetton wallet.
+var jettonMasters sync.Map
+
+// jettonMasterOf returns the master of a jetton wallet from its
+// get_wallet_data, or nil if the account doesn't look like a jetton wallet.
+func (l *LiteClient) jettonMasterOf(ctx context.Context, wallet *address.Address) (*address.Address, error) {
+	key := RawAddr(wallet)
+	if v, ok := jettonMasters.Load(key); ok {
+		return v.(*address.Address), nil
+	}
+
+	block, err := l.api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return nil, liteError("get masterchain info", err)
+	}
+	res, err := l.api.WaitForBlock(block.SeqNo).RunGetMethod(ctx, block, wallet, "get_wallet_data")
+	if err != nil {
+		// contract errors mean it's not a jetton wallet, anything else is
+		// worth retrying later
+		var execErr ton.ContractExecError
+		if errors.As(err, &execErr) {
+			jettonMasters.Store(key, (*address.Address)(nil))
+			return nil, nil
+		}
+		return nil, liteError("run get_wallet_data", err)
+	}
+
+	var master *address.Address
+	if s, err := res.Slice(2); err == nil {
+		if a, err := s.LoadAddr(); err == nil {
+			master = a
+		}
+	}
+	jettonMasters.Store(key, master)
+	return master, nil
+}
+
+// RawAddr renders addr as "workchain:hex", which is stable across the
+// bounce and testnet flags of user-friendly forms.
+func RawAddr(addr *address.Address) string {
+	return fmt.Sprintf("%d:%x", addr.Workchain(), addr.Data())
+}
+
+// FormatAddr returns the user-friendly form of addr for the network.
+func (l *LiteClient) FormatAddr(addr *address.Address) string {
+	return l.formatAddr(addr)
+}
+
+// IsJettonWallet reports whether wallet is the genuine jetton wallet of owner
+// for master. Anyone can send a transfer notification claiming any master,
+// so incoming jetton transfers must be checked with it before crediting.
+func (l *LiteClient) IsJettonWallet(ctx context.Context, master, owner, wallet *address.Address) (bool, error) {
+	w, err := jetton.NewJettonMasterClient(l.api, master).GetJettonWallet(ctx, owner)
+	if err != nil {
+		return false, liteError("get jetton wallet", err)
+	}
+	return w.Address().Equals(wallet), nil
+}
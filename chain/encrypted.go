@@ -0,0 +1,137 @@
+package chain
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+// PublicKey returns the key of the wallet at addr from its get_public_key
+// method. Accounts without one fail with ErrInvalidInput.
+func (l *LiteClient) PublicKey(ctx context.Context, addr *address.Address) (ed25519.PublicKey, error) {
+	key, err := wallet.GetPublicKey(ctx, l.api, addr)
+	if errors.As(err, &ton.ContractExecError{}) {
+		return nil, fmt.Errorf("%w: %s has no public key, it isn't an active wallet", ErrInvalidInput, l.formatAddr(addr))
+	}
+	if err != nil {
+		return nil, liteError("get public key", err)
+	}
+	return key, nil
+}
+
+func isEncryptedComment(body *cell.Cell) bool {
+	return opcode(body) == wallet.EncryptedCommentOpcode
+}
+
+// decryptComment decrypts body sent by sender, with key of one side and the
+// public key of the other. Senders encrypt over one of the text forms of
+// their address, so each is tried.
+func decryptComment(body *cell.Cell, sender *address.Address, key ed25519.PrivateKey, theirs ed25519.PublicKey) (string, error) {
+	var err error
+	for _, bounce := range []bool{true, false} {
+		for _, testnet := range []bool{false, true} {
+			var text []byte
+			text, err = wallet.DecryptCommentCell(body, sender.Copy().Bounce(bounce).Testnet(testnet), key, theirs)
+			if err == nil {
+				return string(text), nil
+			}
+		}
+	}
+	return "", err
+}
+
+// DecryptComments fills in the comments of the encrypted messages of info,
+// decoded from tx of the wallet of key. Messages that can't be decrypted
+// are left as they are.
+func (l *LiteClient) DecryptComments(ctx context.Context, key ed25519.PrivateKey, info *TxInfo, tx *tlb.Transaction) {
+	keys := map[string]ed25519.PublicKey{}
+	decrypt := func(m *MsgInfo, msg *tlb.Message, incoming bool) {
+		if !m.Encrypted || msg.MsgType != tlb.MsgTypeInternal {
+			return
+		}
+		in := msg.AsInternal()
+		other := in.DstAddr
+		if incoming {
+			other = in.SrcAddr
+		}
+		theirs, ok := keys[RawAddr(other)]
+		if !ok {
+			var err error
+			if theirs, err = l.PublicKey(ctx, other); err != nil {
+				l.logger(ctx).Debug().Err(err).Str("tx", info.Hash).Msg("no key to decrypt comment")
+			}
+			keys[RawAddr(other)] = theirs
+		}
+		if theirs == nil {
+			return
+		}
+		text, err := decryptComment(in.Body, in.SrcAddr, key, theirs)
+		if err != nil {
+			l.logger(ctx).Debug().Err(err).Str("tx", info.Hash).Msg("decrypt comment")
+			return
+		}
+		m.Comment = text
+	}
+
+	if info.In != nil && tx.IO.In != nil {
+		decrypt(info.In, tx.IO.In, true)
+	}
+	if tx.IO.Out == nil {
+		return
+	}
+	out, err := tx.IO.Out.ToSlice()
+	if err != nil || len(out) != len(info.Out) {
+		return
+	}
+	for i := range out {
+		decrypt(&info.Out[i], &out[i], false)
+	}
+}
+
+// WalletTransactions returns the last limit transactions of the wallet of
+// key at addr, newest first, decoded with their encrypted comments
+// decrypted.
+func (l *LiteClient) WalletTransactions(ctx context.Context, addr string, key ed25519.PrivateKey, limit int) ([]*TxInfo, error) {
+	account, err := parseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	b, err := l.api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return nil, liteError("get masterchain info", err)
+	}
+	acc, err := l.api.WaitForBlock(b.SeqNo).GetAccount(ctx, b, account)
+	if err != nil {
+		return nil, liteError("get account", err)
+	}
+
+	res := []*TxInfo{}
+	lastHash, lastLt := acc.LastTxHash, acc.LastTxLT
+	for lastLt != 0 && len(res) < limit {
+		list, err := l.api.ListTransactions(ctx, account, l.cfg.TransactionsBatchSize, lastLt, lastHash)
+		if errors.Is(err, ton.ErrNoTransactionsWereFound) {
+			break
+		}
+		if err != nil {
+			return nil, liteError("list transactions", err)
+		}
+		// list is oldest first
+		for i := len(list) - 1; i >= 0 && len(res) < limit; i-- {
+			info, err := l.DecodeTransaction(ctx, account, list[i])
+			if err != nil {
+				return nil, err
+			}
+			l.DecryptComments(ctx, key, info, list[i])
+			res = append(res, info)
+		}
+		lastHash, lastLt = list[0].PrevTxHash, list[0].PrevTxLT
+	}
+	return res, nil
+}
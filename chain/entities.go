@@ -1,8 +1,10 @@
 package chain
 
+// Wallet is a newly generated wallet. Mnemonic is never encoded, it goes
+// to the keystore only.
 type Wallet struct {
-	Address    string   `json:"address"`
-	PrivateKey []string `json:"private_key"`
+	Address  string   `json:"address"`
+	Mnemonic []string `json:"-"`
 }
 
 type BlockTransactions struct {
@@ -10,3 +12,15 @@ type BlockTransactions struct {
 	Hash    string `json:"hash"`
 	LT      uint64 `json:"lt"`
 }
+
+type JettonInfo struct {
+	Master      string `json:"master"`
+	Name        string `json:"name"`
+	Symbol      string `json:"symbol"`
+	Description string `json:"description"`
+	Decimals    int    `json:"decimals"`
+	TotalSupply string `json:"total_supply"`
+	Mintable    bool   `json:"mintable"`
+	Admin       string `json:"admin,omitempty"`
+	Balance     string `json:"balance"`
+}
@@ -0,0 +1,51 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/liteclient"
+	"github.com/xssnick/tonutils-go/ton"
+)
+
+// Errors returned by LiteClient are wrapped with one of these, so callers can
+// tell bad input from liteserver trouble with errors.Is.
+var (
+	ErrBadAddress   = errors.New("bad address")
+	ErrInvalidInput = errors.New("invalid input")
+	ErrUnknownBlock = errors.New("unknown block")
+	ErrNotFound     = errors.New("not found")
+	ErrLiteserver   = errors.New("liteserver failure")
+	ErrTimeout      = errors.New("timeout")
+	ErrCanceled     = errors.New("canceled")
+)
+
+// lsCodeBlockNotApplied is returned by liteservers for blocks they don't know.
+const lsCodeBlockNotApplied = 651
+
+// liteError classifies an error returned by the ton api.
+func liteError(op string, err error) error {
+	var lsErr ton.LSError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("%s: %w: %w", op, ErrCanceled, err)
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, liteclient.ErrADNLReqTimeout),
+		errors.Is(err, ton.ErrTxWasNotConfirmed):
+		return fmt.Errorf("%s: %w: %w", op, ErrTimeout, err)
+	case errors.Is(err, ton.ErrBlockNotFound),
+		errors.As(err, &lsErr) && lsErr.Code == lsCodeBlockNotApplied:
+		return fmt.Errorf("%s: %w: %w", op, ErrUnknownBlock, err)
+	}
+	return fmt.Errorf("%s: %w: %w", op, ErrLiteserver, err)
+}
+
+func parseAddr(addr string) (*address.Address, error) {
+	a, err := address.ParseAddr(addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrBadAddress, addr, err)
+	}
+	return a, nil
+}
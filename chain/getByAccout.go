@@ -2,62 +2,73 @@ package chain
 
 import (
 	"context"
-	"log"
+	"fmt"
 
-	"github.com/xssnick/tonutils-go/address"
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/rs/zerolog"
 	"github.com/xssnick/tonutils-go/liteclient"
 	"github.com/xssnick/tonutils-go/tlb"
 	"github.com/xssnick/tonutils-go/ton"
 	"github.com/xssnick/tonutils-go/ton/jetton"
 )
 
-func GetByAccout() {
+// GetByAccout logs the TON and USDT transfers received by the treasury of
+// the network, both read from conf.TON.
+func GetByAccout(ctx context.Context, conf *config.Config) error {
+	if conf.TON.Treasury == "" || conf.TON.USDTMaster == "" {
+		return fmt.Errorf("%w: no treasury or USDT master configured for %s", ErrInvalidInput, conf.TON.Name)
+	}
+	treasuryAddress, err := parseAddr(conf.TON.Treasury)
+	if err != nil {
+		return fmt.Errorf("network.treasury: %w", err)
+	}
+	usdtMaster, err := parseAddr(conf.TON.USDTMaster)
+	if err != nil {
+		return fmt.Errorf("network.usdt_master: %w", err)
+	}
+
 	client := liteclient.NewConnectionPool()
 
-	cfg, err := liteclient.GetConfigFromUrl(context.Background(), "https://ton.org/global.config.json")
+	cfg, err := config.GetConfig(ctx, conf.TON)
 	if err != nil {
-		log.Fatalln("get config err: ", err.Error())
-		return
+		return fmt.Errorf("get config: %w", err)
 	}
 
-	err = client.AddConnectionsFromConfig(context.Background(), cfg)
+	err = client.AddConnectionsFromConfig(ctx, cfg)
 	if err != nil {
-		log.Fatalln("connection err: ", err.Error())
-		return
+		return liteError("connect", err)
 	}
 
 	api := ton.NewAPIClient(client, ton.ProofCheckPolicyFast).WithRetry()
 	api.SetTrustedBlockFromConfig(cfg)
 
-	master, err := api.CurrentMasterchainInfo(context.Background())
+	master, err := api.CurrentMasterchainInfo(ctx)
 	if err != nil {
-		log.Fatalln("get masterchain info err: ", err.Error())
-		return
+		return liteError("get masterchain info", err)
 	}
 
-	treasuryAddress := address.MustParseAddr("EQAYqo4u7VF0fa4DPAebk4g9lBytj2VFny7pzXR0trjtXQaO")
+	acc, err := api.GetAccount(ctx, master, treasuryAddress)
+	if err != nil {
+		return liteError("get account", err)
+	}
 
-	acc, err := api.GetAccount(context.Background(), master, treasuryAddress)
+	usdt := jetton.NewJettonMasterClient(api, usdtMaster)
+	treasuryJettonWallet, err := usdt.GetJettonWalletAtBlock(ctx, treasuryAddress, master)
 	if err != nil {
-		log.Fatalln("get masterchain info err: ", err.Error())
-		return
+		return liteError("get jetton wallet address", err)
 	}
 
 	lastProcessedLT := acc.LastTxLT
 
 	transactions := make(chan *tlb.Transaction)
 
-	go api.SubscribeOnTransactions(context.Background(), treasuryAddress, lastProcessedLT, transactions)
-
-	log.Println("waiting for transfers...")
-
-	usdt := jetton.NewJettonMasterClient(api, address.MustParseAddr("EQCxE6mUtQJKFnGfaROTKOt1lZbDiiX1kCixRv7Nw2Id_sDs"))
+	// stops the subscription when GetByAccout returns
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go api.SubscribeOnTransactions(subCtx, treasuryAddress, lastProcessedLT, transactions)
 
-	treasuryJettonWallet, err := usdt.GetJettonWalletAtBlock(context.Background(), treasuryAddress, master)
-	if err != nil {
-		log.Fatalln("get jetton wallet address err: ", err.Error())
-		return
-	}
+	lg := zerolog.Ctx(ctx)
+	lg.Info().Str("addr", treasuryAddress.String()).Msg("waiting for transfers")
 
 	for tx := range transactions {
 
@@ -69,18 +80,21 @@ func GetByAccout() {
 				var transfer jetton.TransferNotification
 				if err = tlb.LoadFromCell(&transfer, ti.Body.BeginParse()); err == nil {
 
-					amt := tlb.MustFromNano(transfer.Amount.Nano(), 6)
+					amt := tlb.MustFromNano(transfer.Amount.Nano(), usdtDecimals)
 
 					src = transfer.Sender
-					log.Println("received", amt.String(), "USDT from", src.String())
+					lg.Info().Str("amount", amt.String()).Str("from", src.String()).Msg("received USDT")
 				}
 			}
 
-			log.Println("received", ti.Amount.String(), "TON from", src.String())
+			lg.Info().Str("amount", ti.Amount.String()).Str("from", src.String()).Msg("received TON")
 		}
 
 		lastProcessedLT = tx.LT
 	}
 
-	log.Println("something went wrong, transaction listening unexpectedly finished")
+	if err = ctx.Err(); err != nil {
+		return liteError("subscribe on transactions", err)
+	}
+	return fmt.Errorf("%w: transaction listening unexpectedly finished", ErrLiteserver)
 }
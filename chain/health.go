@@ -0,0 +1,31 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xssnick/tonutils-go/tl"
+	"github.com/xssnick/tonutils-go/ton"
+)
+
+// MasterchainStatus is the last masterchain block a liteserver knows and
+// the liteserver's clock, to tell how stale its view of the chain is.
+type MasterchainStatus struct {
+	Seqno     uint32
+	LastUtime uint32
+	Now       uint32
+}
+
+func (l *LiteClient) MasterchainStatus(ctx context.Context) (*MasterchainStatus, error) {
+	var resp tl.Serializable
+	if err := l.api.Client().QueryLiteserver(ctx, ton.GetMasterchainInfoExt{}, &resp); err != nil {
+		return nil, liteError("get masterchain info", err)
+	}
+	switch t := resp.(type) {
+	case ton.MasterchainInfoExt:
+		return &MasterchainStatus{Seqno: t.Last.SeqNo, LastUtime: t.LastUTime, Now: t.Now}, nil
+	case ton.LSError:
+		return nil, liteError("get masterchain info", t)
+	}
+	return nil, liteError("get masterchain info", fmt.Errorf("unexpected response %T", resp))
+}
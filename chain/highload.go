@@ -0,0 +1,253 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+// HighloadMessage is one transfer sent by a highload wallet.
+type HighloadMessage struct {
+	To      string
+	Amount  tlb.Coins
+	Comment string
+}
+
+// HighloadQuery identifies one external message of a highload v3 wallet.
+// The wallet rejects it once CreatedAt+TTL has passed, and a query id is
+// executed at most once.
+type HighloadQuery struct {
+	ID        uint32
+	CreatedAt int64
+	TTL       uint32
+}
+
+func (l *LiteClient) highloadWallet(key ed25519.PrivateKey, q HighloadQuery) (*wallet.Wallet, error) {
+	w, err := wallet.FromPrivateKey(l.api, key, wallet.ConfigHighloadV3{
+		MessageTTL: q.TTL,
+		MessageBuilder: func(context.Context, uint32) (uint32, int64, error) {
+			return q.ID, q.CreatedAt, nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	return w, nil
+}
+
+// HighloadAddress returns the address of the highload v3 wallet of key. The
+// message ttl is part of its initial state, so of the address.
+func (l *LiteClient) HighloadAddress(key ed25519.PrivateKey, ttl uint32) (string, error) {
+	w, err := l.highloadWallet(key, HighloadQuery{TTL: ttl})
+	if err != nil {
+		return "", err
+	}
+	return l.formatAddr(w.WalletAddress()), nil
+}
+
+// HighloadBody returns the body HighloadSend attaches to a transfer with
+// comment, nil for none. Trackers match outgoing messages by its hash.
+func HighloadBody(comment string) (*cell.Cell, error) {
+	if comment == "" {
+		return nil, nil
+	}
+	body, err := wallet.CreateCommentCell(comment)
+	if err != nil {
+		return nil, fmt.Errorf("%w: comment: %w", ErrInvalidInput, err)
+	}
+	return body, nil
+}
+
+// HighloadSend sends msgs from the highload v3 wallet of key in the external
+// message q, deploying the wallet if needed. It returns the hash of the body
+// of the external message once a liteserver accepted it, without waiting for
+// it to be executed.
+func (l *LiteClient) HighloadSend(ctx context.Context, key ed25519.PrivateKey, q HighloadQuery, msgs []HighloadMessage) ([]byte, error) {
+	w, err := l.highloadWallet(key, q)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*wallet.Message, 0, len(msgs))
+	for _, m := range msgs {
+		dst, err := parseAddr(m.To)
+		if err != nil {
+			return nil, err
+		}
+		body, err := HighloadBody(m.Comment)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, &wallet.Message{
+			Mode: wallet.PayGasSeparately + wallet.IgnoreErrors,
+			InternalMessage: &tlb.InternalMessage{
+				IHRDisabled: true,
+				Bounce:      dst.IsBounceable(),
+				DstAddr:     dst,
+				Amount:      m.Amount,
+				Body:        body,
+			},
+		})
+	}
+
+	hash, err := w.SendManyGetInMsgHash(ctx, out, false)
+	if err != nil {
+		return nil, liteError("send highload message", err)
+	}
+	l.logger(ctx).Info().Str("wallet", l.formatAddr(w.WalletAddress())).Uint32("query_id", q.ID).
+		Int("messages", len(msgs)).Hex("msg_hash", hash).Msg("highload message sent")
+	return hash, nil
+}
+
+// HighloadProcessed reports whether the highload v3 wallet of key executed
+// query id. Once the ttl of the query has passed, false means it never will.
+func (l *LiteClient) HighloadProcessed(ctx context.Context, key ed25519.PrivateKey, ttl uint32, id uint32) (bool, error) {
+	w, err := l.highloadWallet(key, HighloadQuery{TTL: ttl})
+	if err != nil {
+		return false, err
+	}
+	addr := w.WalletAddress()
+	block, err := l.api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return false, liteError("get masterchain info", err)
+	}
+	api := l.api.WaitForBlock(block.SeqNo)
+	acc, err := api.GetAccount(ctx, block, addr)
+	if err != nil {
+		return false, liteError("get account", err)
+	}
+	if !acc.IsActive || acc.State.Status != tlb.AccountStatusActive {
+		// not deployed, so nothing was executed
+		return false, nil
+	}
+	// need_clean 0, with -1 the method forgets queries older than two ttls
+	// and a query executed long ago reads as never executed
+	res, err := api.RunGetMethod(ctx, block, addr, "processed?", uint64(id), 0)
+	if err != nil {
+		return false, liteError("run processed?", err)
+	}
+	v, err := res.Int(0)
+	if err != nil {
+		return false, fmt.Errorf("%w: processed? result: %w", ErrLiteserver, err)
+	}
+	return v.Sign() != 0, nil
+}
+
+// HighloadQueryOf returns the query of the external message tx executed,
+// false when tx wasn't started by a highload v3 external message.
+func HighloadQueryOf(tx *tlb.Transaction) (HighloadQuery, bool) {
+	if tx.IO.In == nil || tx.IO.In.MsgType != tlb.MsgTypeExternalIn {
+		return HighloadQuery{}, false
+	}
+	body := tx.IO.In.AsExternalIn().Body
+	if body == nil {
+		return HighloadQuery{}, false
+	}
+	// signature, then a ref to subwallet_id, message, mode, query_id,
+	// created_at and timeout
+	payload, err := body.BeginParse().LoadRef()
+	if err != nil {
+		return HighloadQuery{}, false
+	}
+	if _, err = payload.LoadUInt(32 + 8); err != nil {
+		return HighloadQuery{}, false
+	}
+	id, err := payload.LoadUInt(23)
+	if err != nil {
+		return HighloadQuery{}, false
+	}
+	createdAt, err := payload.LoadUInt(64)
+	if err != nil {
+		return HighloadQuery{}, false
+	}
+	ttl, err := payload.LoadUInt(22)
+	if err != nil {
+		return HighloadQuery{}, false
+	}
+	return HighloadQuery{ID: uint32(id), CreatedAt: int64(createdAt), TTL: uint32(ttl)}, true
+}
+
+// HighloadExecuted reports whether tx executed q, or the external message
+// of msgHash when it isn't empty.
+func HighloadExecuted(tx *tlb.Transaction, q HighloadQuery, msgHash []byte) bool {
+	if tx.IO.In == nil || tx.IO.In.MsgType != tlb.MsgTypeExternalIn {
+		return false
+	}
+	// HighloadSend returns the hash of the body, it doesn't change with the
+	// state init sent along
+	if body := tx.IO.In.AsExternalIn().Body; len(msgHash) > 0 && body != nil && bytes.Equal(body.Hash(), msgHash) {
+		return true
+	}
+	got, ok := HighloadQueryOf(tx)
+	return ok && got.ID == q.ID && got.CreatedAt == q.CreatedAt
+}
+
+// highloadActionsOp is the op of the internal message a highload v3 wallet
+// sends itself with actions to run.
+const highloadActionsOp = 0xae42e5a4
+
+// HighloadActions reports whether m is an internal message a highload v3
+// wallet sent itself with actions to run. HighloadSend packs the transfers
+// of more than one message that way, they leave the wallet in the
+// transaction that receives m, or in a later one when there are more than
+// fit one message.
+func HighloadActions(m *tlb.InternalMessage) bool {
+	if m.SrcAddr == nil || m.DstAddr == nil || !m.SrcAddr.Equals(m.DstAddr) || m.Body == nil {
+		return false
+	}
+	op, err := m.Body.BeginParse().LoadUInt(32)
+	return err == nil && op == highloadActionsOp
+}
+
+// HighloadSeen looks through the transactions of the highload v3 wallet of
+// key, newest first, for one that executed q, or the external message of
+// msgHash when it isn't empty. Transactions from before q was created can't
+// have, so the search stops there. complete is false when limit
+// transactions were looked at before getting that far, then not seen proves
+// nothing.
+func (l *LiteClient) HighloadSeen(ctx context.Context, key ed25519.PrivateKey, q HighloadQuery, msgHash []byte, limit int) (seen, complete bool, err error) {
+	w, err := l.highloadWallet(key, q)
+	if err != nil {
+		return false, false, err
+	}
+	addr := w.WalletAddress()
+	block, err := l.api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return false, false, liteError("get masterchain info", err)
+	}
+	acc, err := l.api.WaitForBlock(block.SeqNo).GetAccount(ctx, block, addr)
+	if err != nil {
+		return false, false, liteError("get account", err)
+	}
+
+	lastLt, lastHash := acc.LastTxLT, acc.LastTxHash
+	for n := 0; lastLt != 0; {
+		if n >= limit {
+			return false, false, nil
+		}
+		list, err := l.api.ListTransactions(ctx, addr, uint32(min(limit-n, int(l.cfg.TransactionsBatchSize))), lastLt, lastHash)
+		if errors.Is(err, ton.ErrNoTransactionsWereFound) || err == nil && len(list) == 0 {
+			break
+		}
+		if err != nil {
+			return false, false, liteError("list transactions", err)
+		}
+		n += len(list)
+		lastLt, lastHash = list[0].PrevTxLT, list[0].PrevTxHash
+		for _, tx := range list {
+			if HighloadExecuted(tx, q, msgHash) {
+				return true, true, nil
+			}
+		}
+		if int64(list[0].Now) < q.CreatedAt {
+			break
+		}
+	}
+	return false, true, nil
+}
@@ -0,0 +1,82 @@
+package chain
+
+import (
+	"context"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+)
+
+// highloadTx returns a transaction executing the external message of q,
+// and the hash HighloadSend returns for it.
+func highloadTx(t *testing.T, q HighloadQuery, withStateInit bool) (*tlb.Transaction, []byte) {
+	t.Helper()
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	l := &LiteClient{}
+	w, err := l.highloadWallet(key, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := address.NewAddress(0, 0, make([]byte, 32))
+	ext, err := w.PrepareExternalMessageForMany(context.Background(), withStateInit, []*wallet.Message{
+		wallet.SimpleMessage(dst, tlb.MustFromTON("1"), nil),
+		wallet.SimpleMessage(dst, tlb.MustFromTON("2"), nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &tlb.Transaction{}
+	tx.IO.In = &tlb.Message{MsgType: tlb.MsgTypeExternalIn, Msg: ext}
+	return tx, ext.Body.Hash()
+}
+
+func TestHighloadQueryOf(t *testing.T) {
+	q := HighloadQuery{ID: 123456, CreatedAt: 1700000000, TTL: 300}
+	tx, _ := highloadTx(t, q, false)
+	got, ok := HighloadQueryOf(tx)
+	if !ok || got != q {
+		t.Errorf("HighloadQueryOf() = %+v, %v, want %+v", got, ok, q)
+	}
+
+	internal := &tlb.Transaction{}
+	internal.IO.In = &tlb.Message{MsgType: tlb.MsgTypeInternal, Msg: &tlb.InternalMessage{}}
+	if _, ok = HighloadQueryOf(internal); ok {
+		t.Error("HighloadQueryOf() of an internal message succeeded")
+	}
+	if _, ok = HighloadQueryOf(&tlb.Transaction{}); ok {
+		t.Error("HighloadQueryOf() without an inbound message succeeded")
+	}
+}
+
+func TestHighloadExecuted(t *testing.T) {
+	q := HighloadQuery{ID: 42, CreatedAt: 1700000000, TTL: 300}
+	tx, hash := highloadTx(t, q, false)
+	deploy, _ := highloadTx(t, q, true)
+	other, otherHash := highloadTx(t, HighloadQuery{ID: 42, CreatedAt: 1700000600, TTL: 300}, false)
+
+	tests := []struct {
+		name    string
+		tx      *tlb.Transaction
+		q       HighloadQuery
+		msgHash []byte
+		want    bool
+	}{
+		{name: "by query", tx: tx, q: q, want: true},
+		{name: "by hash", tx: tx, q: HighloadQuery{ID: 1}, msgHash: hash, want: true},
+		{name: "by hash with state init", tx: deploy, q: HighloadQuery{ID: 1}, msgHash: hash, want: true},
+		// query ids are reused once they expire, created at tells them apart
+		{name: "same id, later attempt", tx: other, q: q, want: false},
+		{name: "hash of another message", tx: tx, q: HighloadQuery{ID: 1}, msgHash: otherHash, want: false},
+		{name: "no inbound message", tx: &tlb.Transaction{}, q: q, msgHash: hash, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HighloadExecuted(tt.tx, tt.q, tt.msgHash); got != tt.want {
+				t.Errorf("HighloadExecuted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,10 +6,9 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"math/big"
 	"net/http"
 	"net/url"
 	"sort"
@@ -17,8 +16,12 @@ import (
 	"sync"
 	"time"
 
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/logging"
+	"github.com/FishDontExist/TONindexer/mnemonic"
+	"github.com/FishDontExist/TONindexer/traverse"
+	"github.com/rs/zerolog"
 	"github.com/xssnick/tonutils-go/address"
-	"github.com/xssnick/tonutils-go/liteclient"
 	"github.com/xssnick/tonutils-go/tlb"
 	"github.com/xssnick/tonutils-go/ton"
 	"github.com/xssnick/tonutils-go/ton/jetton"
@@ -26,59 +29,71 @@ import (
 	"github.com/xssnick/tonutils-go/ton/wallet"
 )
 
-const (
-	Limit          = 200
-	NumberOfShards = 4
-)
-
 type LiteClient struct {
 	api ton.APIClientWrapped
-	ctx context.Context
-}
+	net *config.NetworkConfig
+	cfg config.ChainConfig
+	log zerolog.Logger
 
-func New() *LiteClient {
-	client := liteclient.NewConnectionPool()
+	// decimals of the configured jetton, read once as they can't change
+	decimalsMx sync.Mutex
+	decimals   *int
+}
 
-	// cfg, err := liteclient.GetConfigFromUrl(context.Background(), "https://ton.org/global.config.json")
-	filepath := "../chain/globalconfig.json"
-	cfg, err := liteclient.GetConfigFromFile(filepath)
+func New(conf *config.Config, lg zerolog.Logger) (*LiteClient, error) {
+	cfg, err := config.GetConfig(context.Background(), conf.TON)
 	if err != nil {
-		log.Fatalln("get config err: ", err.Error())
-		return nil
+		return nil, fmt.Errorf("get config: %w", err)
 	}
 
-	err = client.AddConnectionsFromConfig(context.Background(), cfg)
-	if err != nil {
-		log.Fatalln("connection err: ", err.Error())
-		return nil
-	}
+	lg = lg.With().Str("component", "chain").Logger()
+	client := connectPool(cfg, conf.Chain.ReconnectDelay.Duration, lg)
 
-	api := ton.NewAPIClient(client, ton.ProofCheckPolicyFast).WithRetry()
+	api := ton.NewAPIClient(meteredPool{client}, ton.ProofCheckPolicyFast).WithRetry()
 	api.SetTrustedBlockFromConfig(cfg)
 	return &LiteClient{
 		api: api,
-		ctx: context.Background(),
+		net: conf.TON,
+		cfg: conf.Chain,
+		log: lg,
+	}, nil
+}
+
+// logger returns the logger of the request ctx belongs to, so chain calls
+// are logged with its request id.
+func (l *LiteClient) logger(ctx context.Context) *zerolog.Logger {
+	return logging.From(ctx, &l.log)
+}
+
+// walletConfig is the wallet version used for sending, bound to the
+// configured network.
+func (l *LiteClient) walletConfig() wallet.ConfigV5R1Final {
+	return wallet.ConfigV5R1Final{
+		NetworkGlobalID: l.net.GlobalID,
+		Workchain:       0,
 	}
 }
 
+// formatAddr renders addr with the testnet flag of the configured network.
+func (l *LiteClient) formatAddr(addr *address.Address) string {
+	return addr.Copy().Testnet(l.net.Testnet).String()
+}
+
 // TODO:
 // GetParentBlocks()
-func (l *LiteClient) GetHeight() (*ton.BlockIDExt, error) {
+func (l *LiteClient) GetHeight(ctx context.Context) (*ton.BlockIDExt, error) {
 
-	masterchainInfo, err := l.api.GetMasterchainInfo(l.ctx)
+	masterchainInfo, err := l.api.GetMasterchainInfo(ctx)
 	if err != nil {
-		log.Println(err)
-		return nil, err
+		return nil, liteError("get masterchain info", err)
 	}
 
-	shardInfoList, err := l.api.GetBlockShardsInfo(l.ctx, masterchainInfo)
+	shardInfoList, err := l.api.GetBlockShardsInfo(ctx, masterchainInfo)
 	if err != nil {
-		log.Println(err)
-		return nil, err
+		return nil, liteError("get shards info", err)
 	}
 	var wc0Shard *ton.BlockIDExt
 	for _, shard := range shardInfoList {
-		log.Println("shard+1")
 		if shard.Workchain == 0 {
 			wc0Shard = shard
 			break
@@ -86,18 +101,18 @@ func (l *LiteClient) GetHeight() (*ton.BlockIDExt, error) {
 	}
 
 	if wc0Shard == nil {
-		log.Println("No shard found for workchain 0")
-		return nil, err
+		l.logger(ctx).Warn().Uint32("master", masterchainInfo.SeqNo).Msg("no shard found for workchain 0")
+		return nil, fmt.Errorf("%w: no shard found for workchain 0", ErrUnknownBlock)
 	}
 
 	return wc0Shard, nil
 }
 
-func (l *LiteClient) GetBlockInfoByHeight(info *ton.BlockIDExt) (*[]BlockTransactions, error) {
+func (l *LiteClient) GetBlockInfoByHeight(ctx context.Context, info *ton.BlockIDExt) (*[]BlockTransactions, error) {
 
-	extract, _, err := l.api.GetBlockTransactionsV2(l.ctx, info, 300)
+	extract, _, err := l.api.GetBlockTransactionsV2(ctx, info, l.cfg.BlockTransactionsLimit)
 	if err != nil {
-		return nil, err
+		return nil, liteError("get block transactions", err)
 	}
 	// if !ok {
 	// 	fmt.Println("No transactions found")
@@ -107,108 +122,114 @@ func (l *LiteClient) GetBlockInfoByHeight(info *ton.BlockIDExt) (*[]BlockTransac
 	return transactoinList, nil
 }
 
-func (l *LiteClient) GenerateWallet() (Wallet, error) {
-	words := wallet.NewSeed()
-	w, err := wallet.FromSeed(l.api, words, wallet.V3R2)
+// GenerateWallet creates a wallet of the version Transfer sends from. The
+// mnemonic is only valid together with password, which may be empty.
+func (l *LiteClient) GenerateWallet(ctx context.Context, password string) (Wallet, error) {
+	words := mnemonic.New(password)
+	key, err := mnemonic.PrivateKey(words, password)
+	if err != nil {
+		return Wallet{}, fmt.Errorf("create wallet: %w", err)
+	}
+	addr, err := l.WalletAddress(key)
 	if err != nil {
-		log.Println(err)
+		return Wallet{}, err
 	}
-	return Wallet{Address: w.WalletAddress().String(), PrivateKey: words}, nil
+	return Wallet{Address: addr, Mnemonic: words}, nil
 }
 
-func (l *LiteClient) Transfer(account string, pk []string, amount float64) (*tlb.Transaction, bool) {
-
-	// privateKeyBytes, err := base64.StdEncoding.DecodeString(pk)
-	// if err != nil {
-	// 	log.Println("Failed to decode private key: ", err)
-	// }
-	// privateKey := ed25519.PrivateKey(privateKeyBytes)
-	w, err := wallet.FromSeed(l.api, pk, wallet.ConfigV5R1Final{
-		NetworkGlobalID: -239,
-		Workchain:       0,
-	})
+// WalletAddress returns the address of the wallet Transfer sends from with
+// key. It needs no liteserver.
+func (l *LiteClient) WalletAddress(key ed25519.PrivateKey) (string, error) {
+	w, err := wallet.FromPrivateKey(l.api, key, l.walletConfig())
 	if err != nil {
-		panic(err)
+		return "", fmt.Errorf("create wallet: %w", err)
 	}
-	if w == nil {
-		log.Println("wallet is nil")
-		return nil, false
+	return l.formatAddr(w.WalletAddress()), nil
+}
+
+// DeriveAddress returns the address of the wallet of any version for a
+// mnemonic, without a liteserver. The network id used by v5r1 defaults to
+// the configured one.
+func (l *LiteClient) DeriveAddress(words []string, password string, p mnemonic.Params) (string, error) {
+	if p.GlobalID == 0 {
+		p.GlobalID = l.net.GlobalID
+	}
+	addr, err := mnemonic.Address(words, password, p)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidInput, err)
 	}
+	return l.formatAddr(addr.Bounce(false)), nil
+}
+
+// Transfer sends amount from the wallet of key to account with opts and
+// waits for the wallet transaction.
+func (l *LiteClient) Transfer(ctx context.Context, account string, key ed25519.PrivateKey, amount tlb.Coins, opts TransferOptions) (*tlb.Transaction, error) {
 
-	log.Println("wallet address:", w.WalletAddress())
-	log.Println("fetching and checking proofs since config init block, it may take near a minute...")
-	block, err := l.api.CurrentMasterchainInfo(l.ctx)
+	w, err := wallet.FromPrivateKey(l.api, key, l.walletConfig())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	addr, err := parseAddr(account)
 	if err != nil {
-		log.Fatalln("get masterchain info err: ", err.Error())
-		return nil, false
+		return nil, err
+	}
+	if err = opts.check(w.GetSpec(), addr); err != nil {
+		return nil, err
 	}
-	log.Println("master proof checks are completed successfully, now communication is 100% safe!")
 
-	balance, err := w.GetBalance(l.ctx, block)
+	lg := l.logger(ctx).With().Str("wallet", l.formatAddr(w.WalletAddress())).Logger()
+	block, err := l.api.CurrentMasterchainInfo(ctx)
 	if err != nil {
-		log.Fatalln("GetBalance err:", err.Error())
-		return nil, false
+		return nil, liteError("get masterchain info", err)
 	}
-	log.Println("balance:", balance.String())
-	addr := address.MustParseAddr(account)
 
-	log.Println("sending transaction and waiting for confirmation...")
+	balance, err := w.GetBalance(ctx, block)
+	if err != nil {
+		return nil, liteError("get balance", err)
+	}
 
-	// if destination wallet is not initialized (or you don't care)
-	// you should set bounce to false to not get money back.
-	// If bounce is true, money will be returned in case of not initialized destination wallet or smart-contract error
-	bounce := true
+	lg.Debug().Str("balance", balance.String()).Str("to", account).Msg("sending transaction and waiting for confirmation")
 
-	tonAmountsStr := fmt.Sprintf("%f", amount)
-	transfer, err := w.BuildTransfer(addr, tlb.MustFromTON(tonAmountsStr), bounce, "Hello from tonutils-go!")
+	var theirs ed25519.PublicKey
+	if opts.EncryptComment {
+		if theirs, err = l.PublicKey(ctx, addr); err != nil {
+			return nil, err
+		}
+	}
+	transfer, err := opts.message(w, key, addr, amount, theirs)
 	if err != nil {
-		log.Fatalln("Transfer err:", err.Error())
-		return nil, false
+		return nil, err
 	}
 
-	tx, block, err := w.SendWaitTransaction(l.ctx, transfer)
+	tx, block, err := w.SendWaitTransaction(ctx, transfer)
 	if err != nil {
-		log.Fatalln("SendWaitTransaction err:", err.Error())
-		return nil, false
+		return nil, liteError("send transaction", err)
 	}
 
-	balance, err = w.GetBalance(l.ctx, block)
+	balance, err = w.GetBalance(ctx, block)
 	if err != nil {
-		log.Fatalln("GetBalance err:", err.Error())
-		return nil, false
+		return nil, liteError("get balance", err)
 	}
 
-	log.Printf("transaction confirmed at block %d, hash: %s balance left: %s", block.SeqNo,
-		base64.StdEncoding.EncodeToString(tx.Hash), balance.String())
-
-	return tx, true
-
-	// strAmount := fmt.Sprintf("%f", amount)
-	// addr := address.MustParseAddr(account)
-	// transfer, err := w.BuildTransfer(addr, tlb.MustFromTON(strAmount), true, "")
-	// if err != nil {
-	// 	log.Println(err)
-	// }
-	// tx, _, err := w.SendWaitTransaction(l.ctx, transfer)
-	// if err != nil {
-	// 	log.Println(err)
-	// 	return nil, false
-	// }
+	lg.Info().Uint32("block", block.SeqNo).Str("hash", base64.StdEncoding.EncodeToString(tx.Hash)).
+		Str("balance", balance.String()).Msg("transaction confirmed")
 
-	// return tx, true
+	return tx, nil
 }
-func (l *LiteClient) GetBalance(accountAddr string) (tlb.Coins, error) {
 
-	b, err := l.api.CurrentMasterchainInfo(l.ctx)
+func (l *LiteClient) GetBalance(ctx context.Context, accountAddr string) (tlb.Coins, error) {
+
+	addr, err := parseAddr(accountAddr)
 	if err != nil {
-		log.Println("get masterchain info err: ", err.Error())
 		return tlb.Coins{}, err
 	}
-	addr := address.MustParseAddr(accountAddr)
-	res, err := l.api.WaitForBlock(b.SeqNo).GetAccount(l.ctx, b, addr)
+	b, err := l.api.CurrentMasterchainInfo(ctx)
 	if err != nil {
-		log.Println("get account err: ", err.Error())
-		return tlb.Coins{}, err
+		return tlb.Coins{}, liteError("get masterchain info", err)
+	}
+	res, err := l.api.WaitForBlock(b.SeqNo).GetAccount(ctx, b, addr)
+	if err != nil {
+		return tlb.Coins{}, liteError("get account", err)
 	}
 	if !res.IsActive {
 		return tlb.Coins{}, nil
@@ -217,45 +238,39 @@ func (l *LiteClient) GetBalance(accountAddr string) (tlb.Coins, error) {
 	return res.State.Balance, nil
 }
 
-func (l *LiteClient) GetTransactions(accountAddress string) ([]*tlb.Transaction, error) {
-	b, err := l.api.CurrentMasterchainInfo(l.ctx)
+func (l *LiteClient) GetTransactions(ctx context.Context, accountAddress string) ([]*tlb.Transaction, error) {
+	addr, err := parseAddr(accountAddress)
 	if err != nil {
-		log.Println("get masterchain info err: ", err.Error())
 		return nil, err
 	}
-
-	addr := address.MustParseAddr(accountAddress)
-	res, err := l.api.WaitForBlock(b.SeqNo).GetAccount(l.ctx, b, addr)
+	b, err := l.api.CurrentMasterchainInfo(ctx)
 	if err != nil {
-		log.Println("get account err: ", err.Error())
-		return nil, err
+		return nil, liteError("get masterchain info", err)
 	}
 
-	fmt.Printf("Is active: %v\n", res.IsActive)
-	if res.IsActive {
-		fmt.Printf("Status: %s\n", res.State.Status)
-		fmt.Printf("Balance: %s TON\n", res.State.Balance.String())
-		if res.Data != nil {
-			fmt.Printf("Data: %s\n", res.Data.Dump())
-		}
+	res, err := l.api.WaitForBlock(b.SeqNo).GetAccount(ctx, b, addr)
+	if err != nil {
+		return nil, liteError("get account", err)
 	}
+	l.logger(ctx).Debug().Str("addr", accountAddress).Bool("active", res.IsActive).Msg("listing transactions")
 
 	lastHash := res.LastTxHash
 	lastLt := res.LastTxLT
 
-	fmt.Printf("\nTransactions:\n")
 	var transactions []*tlb.Transaction
 	for {
 		// last transaction has 0 prev lt
 		if lastLt == 0 {
 			break
 		}
+		if err := ctx.Err(); err != nil {
+			return nil, liteError("list transactions", err)
+		}
 
-		// load transactions in batches with size 15
-		list, err := l.api.ListTransactions(l.ctx, addr, 15, lastLt, lastHash)
+		// load transactions in batches
+		list, err := l.api.ListTransactions(ctx, addr, l.cfg.TransactionsBatchSize, lastLt, lastHash)
 		if err != nil {
-			log.Printf("send err: %s", err.Error())
-			return nil, err
+			return nil, liteError("list transactions", err)
 		}
 		// set previous info from the oldest transaction in list
 		lastHash = list[0].PrevTxHash
@@ -266,35 +281,12 @@ func (l *LiteClient) GetTransactions(accountAddress string) ([]*tlb.Transaction,
 			return list[i].LT > list[j].LT
 		})
 
-		for _, t := range list {
-			fmt.Println(t.String())
-			transactions = append(transactions, t)
-		}
+		transactions = append(transactions, list...)
 
 	}
 	return transactions, nil
 }
 
-/*
-func (l *LiteClient) GetTransactionByHash(hash string) (ton.TransactionShortInfo, error) {
-
-	b, err := l.api.CurrentMasterchainInfo(l.ctx)
-	if err != nil {
-		log.Println("get masterchain info err: ", err.Error())
-		return ton.TransactionShortInfo{}, err
-	}
-
-	addr := address.MustParseAddr("EQAYqo4u7VF0fa4DPAebk4g9lBytj2VFny7pzXR0trjtXQaO")
-	res, err := l.api.WaitForBlock(b.SeqNo).GetTransactionByHash(l.ctx, b, addr, hash)
-	if err != nil {
-		log.Println("get account err: ", err.Error())
-		return ton.TransactionShortInfo{}, err
-	}
-
-	return res, nil
-}
-*/
-
 // type SimpleBlock struct {
 // 	block *ton.BlockIDExt
 // 	time  time.Time
@@ -308,7 +300,7 @@ func (l *LiteClient) GetTransactionByHash(hash string) (ton.TransactionShortInfo
 // 	return &SimpleBlock{block: block, time: time.Now()}
 // }
 
-func (l *LiteClient) GetFee(pk ed25519.PrivateKey, accountAddr string) (float64, error) {
+func (l *LiteClient) GetFee(ctx context.Context, pk ed25519.PrivateKey, accountAddr string) (float64, error) {
 	// w, err := wallet.FromPrivateKey(l.api, pk, wallet.V3)
 	// if err != nil {
 	// 	log.Println(err)
@@ -316,105 +308,198 @@ func (l *LiteClient) GetFee(pk ed25519.PrivateKey, accountAddr string) (float64,
 	// addr := address.MustParseAddr(accountAddr)
 	// recieverAddr := address.MustParseAddr(accountAddr)
 	// amount, comment := "0.1", "test"
-	// block, _ := l.api.CurrentMasterchainInfo(l.ctx)
+	// block, _ := l.api.CurrentMasterchainInfo(ctx)
 	fee := 0.07
 	return fee, nil
 }
 
-// TODO
-func (l *LiteClient) GetJettonInfo() bool {
-	tokenContract := address.MustParseAddr("EQCxE6mUtQJKFnGfaROTKOt1lZbDiiX1kCixRv7Nw2Id_sDs")
-	master := jetton.NewJettonMasterClient(l.api, tokenContract)
-	data, err := master.GetJettonData(l.ctx)
-	if err != nil {
-		log.Fatal(err)
-	}
-	decimals := 9
-	content := data.Content.(*nft.ContentOnchain)
-	log.Println("total supply:", data.TotalSupply.Uint64())
-	log.Println("mintable:", data.Mintable)
-	log.Println("admin addr:", data.AdminAddr)
-	log.Println("onchain content:")
-	log.Println("	name:", content.GetAttribute("name"))
-	log.Println("	symbol:", content.GetAttribute("symbol"))
-	if content.GetAttribute("decimals") != "" {
-		decimals, err = strconv.Atoi(content.GetAttribute("decimals"))
-		if err != nil {
-			log.Println("invalid decimals")
-			return false
+// usdtDecimals are the decimals of USDT, its metadata is off-chain.
+const usdtDecimals = 6
+
+// jettonContent returns the on-chain metadata of a jetton, nil when all of
+// it is off-chain.
+func jettonContent(data *jetton.Data) *nft.ContentOnchain {
+	switch content := data.Content.(type) {
+	case *nft.ContentOnchain:
+		return content
+	case *nft.ContentSemichain:
+		return &content.ContentOnchain
+	}
+	return nil
+}
+
+// jettonDecimals reads the decimals of a jetton from the on-chain metadata
+// of its master, jettons with on-chain metadata that don't set them have 9.
+// Decimals that may be set off-chain aren't fetched, they are an error.
+func jettonDecimals(data *jetton.Data) (int, error) {
+	content := jettonContent(data)
+	if content == nil {
+		return 0, fmt.Errorf("%w: jetton metadata is off-chain, decimals unknown", ErrInvalidInput)
+	}
+	if content.GetAttribute("decimals") == "" {
+		if _, semi := data.Content.(*nft.ContentSemichain); semi {
+			return 0, fmt.Errorf("%w: jetton decimals may be in its off-chain metadata", ErrInvalidInput)
 		}
+		return 9, nil
+	}
+	decimals, err := strconv.Atoi(content.GetAttribute("decimals"))
+	if err != nil || decimals < 0 || decimals > 255 {
+		return 0, fmt.Errorf("%w: invalid jetton decimals %q", ErrLiteserver, content.GetAttribute("decimals"))
+	}
+	return decimals, nil
+}
+
+// JettonDecimals returns the decimals of the configured jetton, from the
+// metadata of its master.
+func (l *LiteClient) JettonDecimals(ctx context.Context) (int, error) {
+	l.decimalsMx.Lock()
+	defer l.decimalsMx.Unlock()
+	if l.decimals != nil {
+		return *l.decimals, nil
+	}
+	if l.net.JettonMaster == "" {
+		return 0, fmt.Errorf("%w: no jetton master configured for %s", ErrInvalidInput, l.net.Name)
+	}
+	master, err := parseAddr(l.net.JettonMaster)
+	if err != nil {
+		return 0, err
+	}
+	data, err := jetton.NewJettonMasterClient(l.api, master).GetJettonData(ctx)
+	if err != nil {
+		return 0, liteError("get jetton data", err)
+	}
+	decimals, err := jettonDecimals(data)
+	if err != nil {
+		return 0, err
 	}
-	log.Println("	decimals:", decimals)
-	log.Println("	description:", content.GetAttribute("description"))
-	log.Println()
+	l.decimals = &decimals
+	return decimals, nil
+}
 
-	tokenWallet, err := master.GetJettonWallet(l.ctx, address.MustParseAddr("EQCWdteEWa4D3xoqLNV0zk4GROoptpM1-p66hmyBpxjvbbnn"))
+// GetJettonInfo reads the USDT master of the network and the USDT balance
+// of owner.
+func (l *LiteClient) GetJettonInfo(ctx context.Context, owner string) (*JettonInfo, error) {
+	if l.net.USDTMaster == "" {
+		return nil, fmt.Errorf("%w: no USDT master configured for %s", ErrInvalidInput, l.net.Name)
+	}
+	tokenContract, err := parseAddr(l.net.USDTMaster)
+	if err != nil {
+		return nil, err
+	}
+	ownerAddr, err := parseAddr(owner)
+	if err != nil {
+		return nil, err
+	}
+	master := jetton.NewJettonMasterClient(l.api, tokenContract)
+	data, err := master.GetJettonData(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return nil, liteError("get jetton data", err)
+	}
+	info := &JettonInfo{
+		Master:      l.formatAddr(tokenContract),
+		TotalSupply: data.TotalSupply.String(),
+		Mintable:    data.Mintable,
+		Decimals:    usdtDecimals,
+	}
+	if data.AdminAddr != nil {
+		info.Admin = l.formatAddr(data.AdminAddr)
+	}
+	if content := jettonContent(data); content != nil {
+		info.Name = content.GetAttribute("name")
+		info.Symbol = content.GetAttribute("symbol")
+		info.Description = content.GetAttribute("description")
 	}
 
-	tokenBalance, err := tokenWallet.GetBalance(l.ctx)
+	tokenWallet, err := master.GetJettonWallet(ctx, ownerAddr)
 	if err != nil {
-		log.Fatal(err)
+		return nil, liteError("get jetton wallet", err)
 	}
 
-	log.Println("jetton balance:", tlb.MustFromNano(tokenBalance, decimals))
-	return true
+	tokenBalance, err := tokenWallet.GetBalance(ctx)
+	if err != nil {
+		return nil, liteError("get jetton balance", err)
+	}
+	balance, err := tlb.FromNano(tokenBalance, info.Decimals)
+	if err != nil {
+		return nil, fmt.Errorf("%w: jetton balance: %w", ErrLiteserver, err)
+	}
+	info.Balance = balance.String()
+	return info, nil
 }
 
-func (l *LiteClient) SendJetton(pk []string, amount string, reciever string) (string, bool) {
+// SendJetton transfers amount base units of the configured jetton to
+// reciever with opts, attaching fee for the jetton wallets.
+func (l *LiteClient) SendJetton(ctx context.Context, key ed25519.PrivateKey, amount *big.Int, reciever string, fee tlb.Coins, opts TransferOptions) (*tlb.Transaction, error) {
 
-	w, err := wallet.FromSeed(l.api, pk, wallet.ConfigV5R1Final{
-		NetworkGlobalID: -239,
-		Workchain:       0,
-	})
+	w, err := wallet.FromPrivateKey(l.api, key, l.walletConfig())
 
 	if err != nil {
-		log.Println(err)
-		return "", false
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	if l.net.JettonMaster == "" {
+		return nil, fmt.Errorf("%w: no jetton master configured for %s", ErrInvalidInput, l.net.Name)
+	}
+	master, err := parseAddr(l.net.JettonMaster)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseAddr(reciever)
+	if err != nil {
+		return nil, err
 	}
-	token := jetton.NewJettonMasterClient(l.api, address.MustParseAddr("EQC7Vk6yHv-3Sc7sShVUo_kpO-LoCABRepLCjklU5DtQlHvx"))
+	decimals, err := l.JettonDecimals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	amountTokens, err := tlb.FromNano(amount, decimals)
+	if err != nil {
+		return nil, fmt.Errorf("%w: amount: %w", ErrInvalidInput, err)
+	}
+	token := jetton.NewJettonMasterClient(l.api, master)
 
-	tokenWallet, err := token.GetJettonWallet(l.ctx, w.WalletAddress())
+	tokenWallet, err := token.GetJettonWallet(ctx, w.WalletAddress())
 
 	if err != nil {
-		log.Println(err)
-		return "", false
+		return nil, liteError("get jetton wallet", err)
+	}
+	if err = opts.checkJetton(w.GetSpec(), tokenWallet.Address(), fee); err != nil {
+		return nil, err
 	}
-	tokenBalance, err := tokenWallet.GetBalance(l.ctx)
+	tokenBalance, err := tokenWallet.GetBalance(ctx)
 
 	if err != nil {
-		log.Fatal(err)
-		return "", false
+		return nil, liteError("get jetton balance", err)
 	}
-	fmt.Println("jetton balance:", tokenBalance.String())
-	amountTokens := tlb.MustFromDecimal(amount, 9)
+	lg := l.logger(ctx).With().Str("wallet", l.formatAddr(w.WalletAddress())).Logger()
+	lg.Debug().Str("jetton_balance", tokenBalance.String()).Msg("jetton wallet loaded")
 
-	// IF needed
-	comment, err := wallet.CreateCommentCell("Hello from Zion!")
+	forward, err := opts.forwardPayload()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-
-	to := address.MustParseAddr(reciever)
-	transferPayload, err := tokenWallet.BuildTransferPayloadV2(to, to, amountTokens, tlb.ZeroCoins, comment, nil)
+	transferPayload, err := tokenWallet.BuildTransferPayloadV2(to, to, amountTokens, opts.ForwardAmount, forward, nil)
 	if err != nil {
-		log.Println(err)
-		return "", false
+		return nil, fmt.Errorf("build transfer payload: %w", err)
 	}
 
-	fee := "0.05"
-	msg := wallet.SimpleMessage(tokenWallet.Address(), tlb.MustFromTON(fee), transferPayload)
-	log.Println("sending transaction...")
+	msg := &wallet.Message{
+		Mode: opts.Mode,
+		InternalMessage: &tlb.InternalMessage{
+			IHRDisabled: true,
+			Bounce:      true,
+			DstAddr:     tokenWallet.Address(),
+			Amount:      fee,
+			Body:        transferPayload,
+		},
+	}
+	lg.Debug().Str("to", reciever).Msg("sending jetton transfer")
 
-	tx, _, err := w.SendWaitTransaction(l.ctx, msg)
+	tx, _, err := w.SendWaitTransaction(ctx, msg)
 	if err != nil {
-		log.Println(err)
-		return "", false
+		return nil, liteError("send transaction", err)
 	}
-	log.Println("transaction confirmed, hash:", base64.StdEncoding.EncodeToString(tx.Hash))
-	hash := base64.StdEncoding.EncodeToString(tx.Hash)
-	return hash, true
+	lg.Info().Str("hash", base64.StdEncoding.EncodeToString(tx.Hash)).Msg("jetton transfer confirmed")
+	return tx, nil
 }
 
 // //////////////////////////////////////////////////////////
@@ -435,8 +520,8 @@ type APIResponse struct {
 	Transactions []Transaction `json:"transactions"`
 }
 
-func GetTransactionWithHash(txHash string) ([]TimedTransaction, error) {
-	baseURL := "https://toncenter.com/api/v3/transactions"
+func (l *LiteClient) GetTransactionWithHash(ctx context.Context, txHash string) ([]TimedTransaction, error) {
+	baseURL := l.net.ToncenterURL + "/transactions"
 
 	// Prepare query parameters
 	params := url.Values{}
@@ -453,12 +538,28 @@ func GetTransactionWithHash(txHash string) ([]TimedTransaction, error) {
 	var apiResp APIResponse
 	var transactions []TimedTransaction
 
-	maxRetries := 4
+	maxRetries := l.cfg.HashLookupRetries
+	delay := l.cfg.HashLookupRetryDelay.Duration
+	sleep := func() error {
+		select {
+		case <-ctx.Done():
+			return liteError("get transaction by hash", ctx.Err())
+		case <-time.After(delay):
+			return nil
+		}
+	}
+	lg := l.logger(ctx).With().Str("hash", txHash).Logger()
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-		resp, err := http.Get(fullURL)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
 		if err != nil {
-			log.Printf("Attempt %d: Error making GET request: %v", attempt, err)
-			time.Sleep(5 * time.Second)
+			return nil, fmt.Errorf("build request: %w", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			lg.Debug().Err(err).Int("attempt", attempt).Msg("toncenter request failed")
+			if err = sleep(); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
@@ -466,23 +567,28 @@ func GetTransactionWithHash(txHash string) ([]TimedTransaction, error) {
 		body, err := ioutil.ReadAll(resp.Body)
 		resp.Body.Close()
 		if err != nil {
-			log.Printf("Attempt %d: Error reading response body: %v", attempt, err)
-			time.Sleep(5 * time.Second)
+			lg.Debug().Err(err).Int("attempt", attempt).Msg("read toncenter response")
+			if err = sleep(); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
 		if resp.StatusCode != http.StatusOK {
-			log.Printf("Attempt %d: Non-OK HTTP status: %s", attempt, resp.Status)
-			log.Printf("Response Body: %s", string(body))
-			time.Sleep(5 * time.Second)
+			lg.Debug().Int("attempt", attempt).Str("status", resp.Status).Bytes("body", body).Msg("toncenter responded with an error")
+			if err = sleep(); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
 		// Parse JSON response
 		err = json.Unmarshal(body, &apiResp)
 		if err != nil {
-			log.Printf("Attempt %d: Error parsing JSON response: %v", attempt, err)
-			time.Sleep(5 * time.Second)
+			lg.Debug().Err(err).Int("attempt", attempt).Msg("parse toncenter response")
+			if err = sleep(); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
@@ -492,17 +598,19 @@ func GetTransactionWithHash(txHash string) ([]TimedTransaction, error) {
 		}
 
 		// If no transactions found, wait and retry
-		log.Printf("Attempt %d: No transactions found for hash %s. Retrying...", attempt, txHash)
-		time.Sleep(5 * time.Second)
+		lg.Debug().Int("attempt", attempt).Msg("transaction not found yet, retrying")
+		if err = sleep(); err != nil {
+			return nil, err
+		}
 	}
 
 	if tx == nil {
-		return nil, errors.New("no transaction found after retries")
+		return nil, fmt.Errorf("%w: no transaction found after retries", ErrNotFound)
 	}
 
 	// Check for the presence of "in_msg" and "out_msgs"
 	if len(tx.InMsg) == 0 || len(tx.OutMsgs) == 0 {
-		return nil, errors.New("transaction missing 'in_msg' or 'out_msgs'")
+		return nil, fmt.Errorf("%w: transaction missing 'in_msg' or 'out_msgs'", ErrLiteserver)
 	}
 
 	// Parse the transaction
@@ -526,14 +634,12 @@ func parseTx(tx Transaction) ([]TimedTransaction, error) {
 
 	err := json.Unmarshal(tx.InMsg, &inMsg)
 	if err != nil {
-		log.Printf("Error unmarshaling in_msg: %v", err)
-		return timedTxs, err
+		return timedTxs, fmt.Errorf("in_msg: %w", err)
 	}
 
 	err = json.Unmarshal(tx.OutMsgs, &outMsgs)
 	if err != nil {
-		log.Printf("Error unmarshaling out_msgs: %v", err)
-		return timedTxs, err
+		return timedTxs, fmt.Errorf("out_msgs: %w", err)
 	}
 
 	timedTx := TimedTransaction{
@@ -553,11 +659,6 @@ func logTransactionShortInfo(t []ton.TransactionShortInfo) *[]BlockTransactions
 		accountHex = hex.EncodeToString(transaction.Account)
 		hashHex = hex.EncodeToString(transaction.Hash)
 
-		fmt.Printf("Transaction Info:\n")
-		fmt.Printf("Account: %s\n", accountHex)
-		fmt.Printf("LT: %d\n", transaction.LT)
-		fmt.Printf("Hash: %s\n", hashHex)
-
 		blockTransactions = append(blockTransactions, BlockTransactions{Account: accountHex, Hash: hashHex, LT: transaction.LT})
 	}
 
@@ -568,111 +669,34 @@ func logTransactionShortInfo(t []ton.TransactionShortInfo) *[]BlockTransactions
 ////      get shards ///////////
 //////////////////////////////////
 
-func (l *LiteClient) GetPrevBlocks() {
-	ctx := l.ctx
-	api := l.api
-
-	masterchainInfo, err := api.GetMasterchainInfo(ctx)
+// GetPrevBlocks returns the latest PrevBlocksLimit basechain shard blocks,
+// newest first, following all parents across shard splits and merges.
+func (l *LiteClient) GetPrevBlocks(ctx context.Context) ([]*ton.BlockIDExt, error) {
+	masterchainInfo, err := l.api.GetMasterchainInfo(ctx)
 	if err != nil {
-		log.Fatalf("Failed to get masterchain info: %v", err)
+		return nil, liteError("get masterchain info", err)
 	}
 
-	blocksMap := make(map[string]*ton.BlockIDExt)
-	var blocks []*ton.BlockIDExt
-
-	masterBlock := masterchainInfo
-
-	var mu sync.Mutex
-
-	for len(blocks) < Limit {
-		shardBlocks, err := api.GetBlockShardsInfo(ctx, masterBlock)
-		if err != nil {
-			log.Fatalf("Failed to get shard blocks: %v", err)
-		}
-		for _, shard := range shardBlocks {
-			log.Println(shard.Workchain, shard.Shard)
-		}
-
-		var workchain0Shards []*ton.BlockIDExt
-		for _, shard := range shardBlocks {
-			if shard.Workchain == 0 {
-				workchain0Shards = append(workchain0Shards, shard)
-			}
-		}
-
-		if len(workchain0Shards) == 0 {
-			log.Fatalf("No workchain 0 shard blocks found at masterchain seqno %d", masterBlock.SeqNo)
-		}
-
-		type blockResult struct {
-			blocks []*ton.BlockIDExt
-			err    error
-		}
-		resultCh := make(chan blockResult, len(workchain0Shards))
-
-		var wg sync.WaitGroup
-
-		for _, shardBlock := range workchain0Shards {
-			wg.Add(1)
-			go func(shardBlock *ton.BlockIDExt) {
-
-				defer wg.Done()
-
-				shardBlocksCollected, err := collectShardBlocks(ctx, api, shardBlock, Limit/NumberOfShards)
-				if err != nil {
-					resultCh <- blockResult{nil, err}
-					return
-				}
-				log.Println(len(shardBlocksCollected))
-				resultCh <- blockResult{shardBlocksCollected, nil}
-			}(shardBlock)
-		}
-
-		wg.Wait()
-		close(resultCh)
-
-		for res := range resultCh {
-			if res.err != nil {
-				log.Fatalf("Error collecting shard blocks: %v", res.err)
-			}
-			mu.Lock()
-			for _, blk := range res.blocks {
-				blockKey := fmt.Sprintf("%d:%d:%d", blk.Workchain, blk.Shard, blk.SeqNo)
-				if _, exists := blocksMap[blockKey]; !exists {
-					blocksMap[blockKey] = blk
-					blocks = append(blocks, blk)
-					if len(blocks) >= Limit {
-						break
-					}
-				}
-			}
-			mu.Unlock()
-			if len(blocks) >= Limit {
-				break
-			}
-		}
-
-		if len(blocks) >= Limit {
-			break
-		}
-
-		prevBlockData, err := api.GetBlockData(ctx, masterBlock)
-		if err != nil {
-			log.Fatalf("Failed to get previous masterchain block data: %v", err)
-		}
-
-		prevBlocks, _ := getPrevBlocks(&prevBlockData.BlockInfo)
+	shardBlocks, err := l.api.GetBlockShardsInfo(ctx, masterchainInfo)
+	if err != nil {
+		return nil, liteError("get shard blocks", err)
+	}
 
-		if len(prevBlocks) == 0 {
-			break
+	var workchain0Shards []*ton.BlockIDExt
+	for _, shard := range shardBlocks {
+		if shard.Workchain == 0 {
+			workchain0Shards = append(workchain0Shards, shard)
 		}
+	}
 
-		masterBlock = prevBlocks[0]
+	if len(workchain0Shards) == 0 {
+		return nil, fmt.Errorf("%w: no workchain 0 shard blocks found at masterchain seqno %d", ErrUnknownBlock, masterchainInfo.SeqNo)
 	}
 
-	// if len(blocks) > Limit {
-	// 	blocks = blocks[:Limit]
-	// }
+	blocks, err := traverse.New(l.api).Walk(ctx, workchain0Shards, nil, l.cfg.PrevBlocksLimit)
+	if err != nil {
+		return nil, liteError("collect shard blocks", err)
+	}
 
 	sort.Slice(blocks, func(i, j int) bool {
 		if blocks[i].SeqNo == blocks[j].SeqNo {
@@ -680,49 +704,5 @@ func (l *LiteClient) GetPrevBlocks() {
 		}
 		return blocks[i].SeqNo > blocks[j].SeqNo
 	})
-
-	for _, blk := range blocks {
-		// fmt.Printf("Block: Workchain %d, Shard %d, SeqNo %d\n", blk.Workchain, blk.Shard, blk.SeqNo)
-		fmt.Printf(" Shard %d, SeqNo %d\n", blk.Shard, blk.SeqNo)
-
-	}
-}
-
-// collectShardBlocks traverses backward through a shardchain collecting blocks
-func collectShardBlocks(ctx context.Context, api ton.APIClientWrapped, startBlock *ton.BlockIDExt, limit int) ([]*ton.BlockIDExt, error) {
-	var blocks []*ton.BlockIDExt
-	currentBlock := startBlock
-
-	for len(blocks) < limit {
-		blocks = append(blocks, currentBlock)
-
-		blockData, err := api.GetBlockData(ctx, currentBlock)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get block data for block %d: %w", currentBlock.SeqNo, err)
-		}
-
-		prevBlocks, err := blockData.BlockInfo.GetParentBlocks()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get previous blocks for block %d: %w", currentBlock.SeqNo, err)
-		}
-		if len(prevBlocks) > 1 {
-			log.Println(len(prevBlocks))
-		}
-
-		if len(prevBlocks) == 0 {
-			break
-		}
-
-		currentBlock = prevBlocks[0]
-	}
-
 	return blocks, nil
 }
-
-func getPrevBlocks(blockInfo *tlb.BlockHeader) ([]*ton.BlockIDExt, error) {
-	prevBlocks, err := blockInfo.GetParentBlocks()
-	if err != nil {
-		return nil, err
-	}
-	return prevBlocks, nil
-}
@@ -0,0 +1,55 @@
+package chain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/xssnick/tonutils-go/ton/jetton"
+	"github.com/xssnick/tonutils-go/ton/nft"
+)
+
+func TestJettonDecimals(t *testing.T) {
+	onchain := func(decimals string) *nft.ContentOnchain {
+		c := &nft.ContentOnchain{}
+		if err := c.SetAttribute("symbol", "TST"); err != nil {
+			t.Fatal(err)
+		}
+		if decimals != "" {
+			if err := c.SetAttribute("decimals", decimals); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return c
+	}
+	semichain := func(decimals string) *nft.ContentSemichain {
+		return &nft.ContentSemichain{ContentOffchain: nft.ContentOffchain{URI: "https://example.com/jetton.json"}, ContentOnchain: *onchain(decimals)}
+	}
+
+	tests := []struct {
+		name    string
+		content nft.ContentAny
+		want    int
+		wantErr bool
+	}{
+		{name: "on-chain", content: onchain("6"), want: 6},
+		{name: "on-chain default", content: onchain(""), want: 9},
+		{name: "on-chain invalid", content: onchain("many"), wantErr: true},
+		{name: "semi-chain", content: semichain("6"), want: 6},
+		{name: "semi-chain without decimals", content: semichain(""), wantErr: true},
+		{name: "off-chain", content: &nft.ContentOffchain{URI: "https://example.com/jetton.json"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := jettonDecimals(&jetton.Data{Content: tt.content})
+			if tt.wantErr {
+				if err == nil || !errors.Is(err, ErrInvalidInput) && !errors.Is(err, ErrLiteserver) {
+					t.Errorf("jettonDecimals() = %d, %v, want an error", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("jettonDecimals() = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package chain
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/metrics"
+	"github.com/rs/zerolog"
+	"github.com/xssnick/tonutils-go/liteclient"
+	"github.com/xssnick/tonutils-go/tl"
+	"github.com/xssnick/tonutils-go/ton"
+)
+
+// connectPool dials the liteservers from cfg without ever failing: if no
+// liteserver is reachable yet, connecting continues in the background, and
+// dropped connections are re-established by the pool's disconnect callback.
+// Requests made while the pool is empty fail with a liteserver error.
+func connectPool(cfg *liteclient.GlobalConfig, retryDelay time.Duration, lg zerolog.Logger) *liteclient.ConnectionPool {
+	client := liteclient.NewConnectionPool()
+	client.SetOnDisconnect(client.DefaultReconnect(retryDelay, -1))
+
+	err := client.AddConnectionsFromConfig(context.Background(), cfg)
+	if err == nil {
+		return client
+	}
+	lg.Warn().Err(err).Msg("liteserver connection failed, retrying in background")
+
+	go func() {
+		delay := retryDelay
+		for {
+			time.Sleep(delay)
+			err := client.AddConnectionsFromConfig(context.Background(), cfg)
+			if err == nil {
+				lg.Info().Msg("liteserver connection established")
+				return
+			}
+			lg.Warn().Err(err).Dur("retry_in", delay).Msg("liteserver connection failed")
+			if delay < time.Minute {
+				delay *= 2
+			}
+		}
+	}()
+	return client
+}
+
+// meteredPool records latency and results of liteserver requests by node.
+// Requests not bound to a node yet are bound by the pool's balancer first,
+// so every request can be attributed to the node that served it.
+type meteredPool struct {
+	*liteclient.ConnectionPool
+}
+
+func (p meteredPool) QueryLiteserver(ctx context.Context, payload tl.Serializable, result tl.Serializable) error {
+	if p.StickyNodeID(ctx) == 0 {
+		if sticky, err := p.StickyContextNextNodeBalanced(ctx); err == nil {
+			ctx = sticky
+		}
+	}
+	node := strconv.FormatUint(uint64(p.StickyNodeID(ctx)), 10)
+
+	start := time.Now()
+	err := p.ConnectionPool.QueryLiteserver(ctx, payload, result)
+	metrics.LiteserverDuration.WithLabelValues(node).Observe(time.Since(start).Seconds())
+
+	res := "ok"
+	if err != nil {
+		res = "error"
+	} else if r, ok := result.(*tl.Serializable); ok {
+		if _, ok = (*r).(ton.LSError); ok {
+			res = "ls_error"
+		}
+	}
+	metrics.LiteserverRequests.WithLabelValues(node, res).Inc()
+	return err
+}
@@ -0,0 +1,116 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+)
+
+// API exposes the underlying liteserver client, for the scanner.
+func (l *LiteClient) API() ton.APIClientWrapped {
+	return l.api
+}
+
+// TransactionsSince returns transactions of addr with LT greater than
+// sinceLT, oldest first. It fails with ErrInvalidInput when there are more
+// than limit of them.
+func (l *LiteClient) TransactionsSince(ctx context.Context, addr *address.Address, sinceLT uint64, limit int) ([]*tlb.Transaction, error) {
+	b, err := l.api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return nil, liteError("get masterchain info", err)
+	}
+	acc, err := l.api.WaitForBlock(b.SeqNo).GetAccount(ctx, b, addr)
+	if err != nil {
+		return nil, liteError("get account", err)
+	}
+
+	lastHash, lastLt := acc.LastTxHash, acc.LastTxLT
+	var newest []*tlb.Transaction
+	for lastLt > sinceLT {
+		list, err := l.api.ListTransactions(ctx, addr, l.cfg.TransactionsBatchSize, lastLt, lastHash)
+		if errors.Is(err, ton.ErrNoTransactionsWereFound) {
+			break
+		}
+		if err != nil {
+			return nil, liteError("list transactions", err)
+		}
+		// list is oldest first, collect newest first to stop at sinceLT
+		for i := len(list) - 1; i >= 0; i-- {
+			if list[i].LT <= sinceLT {
+				break
+			}
+			newest = append(newest, list[i])
+		}
+		if len(newest) > limit {
+			return nil, fmt.Errorf("%w: more than %d transactions since lt %d", ErrInvalidInput, limit, sinceLT)
+		}
+		lastHash, lastLt = list[0].PrevTxHash, list[0].PrevTxLT
+	}
+
+	for i, j := 0, len(newest)-1; i < j; i, j = i+1, j-1 {
+		newest[i], newest[j] = newest[j], newest[i]
+	}
+	return newest, nil
+}
+
+// Transaction returns the transaction of addr with lt and hash.
+func (l *LiteClient) Transaction(ctx context.Context, addr *address.Address, lt uint64, hash []byte) (*tlb.Transaction, error) {
+	list, err := l.api.ListTransactions(ctx, addr, 1, lt, hash)
+	if errors.Is(err, ton.ErrNoTransactionsWereFound) || err == nil && len(list) == 0 {
+		return nil, fmt.Errorf("%w: transaction %x of %s", ErrNotFound, hash, l.formatAddr(addr))
+	}
+	if err != nil {
+		return nil, liteError("list transactions", err)
+	}
+	return list[0], nil
+}
+
+// TxRef points at a transaction of an account, the zero TxRef at none.
+type TxRef struct {
+	LT   uint64
+	Hash []byte
+}
+
+// TransactionsPage returns up to limit transactions of addr with LT greater
+// than sinceLT, newest first, starting at from, or at the last transaction
+// of addr when from is zero. next is where the following page starts, zero
+// once sinceLT is reached.
+func (l *LiteClient) TransactionsPage(ctx context.Context, addr *address.Address, from TxRef, sinceLT uint64, limit int) (list []*tlb.Transaction, next TxRef, err error) {
+	if from.LT == 0 {
+		b, err := l.api.CurrentMasterchainInfo(ctx)
+		if err != nil {
+			return nil, TxRef{}, liteError("get masterchain info", err)
+		}
+		acc, err := l.api.WaitForBlock(b.SeqNo).GetAccount(ctx, b, addr)
+		if err != nil {
+			return nil, TxRef{}, liteError("get account", err)
+		}
+		from = TxRef{LT: acc.LastTxLT, Hash: acc.LastTxHash}
+	}
+
+	for from.LT > sinceLT && len(list) < limit {
+		page, err := l.api.ListTransactions(ctx, addr, uint32(min(limit-len(list), int(l.cfg.TransactionsBatchSize))), from.LT, from.Hash)
+		if errors.Is(err, ton.ErrNoTransactionsWereFound) {
+			return list, TxRef{}, nil
+		}
+		if err != nil {
+			return nil, TxRef{}, liteError("list transactions", err)
+		}
+		// page is oldest first
+		for i := len(page) - 1; i >= 0; i-- {
+			if page[i].LT <= sinceLT {
+				return list, TxRef{}, nil
+			}
+			list = append(list, page[i])
+		}
+		from = TxRef{LT: page[0].PrevTxLT, Hash: page[0].PrevTxHash}
+	}
+	if from.LT <= sinceLT {
+		return list, TxRef{}, nil
+	}
+	return list, from, nil
+}
@@ -0,0 +1,98 @@
+package chain
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+)
+
+// fakeAccount serves an account with transactions at lt 10, 20, ..., any
+// other method of the interface panics.
+type fakeAccount struct {
+	ton.APIClientWrapped
+	txs []*tlb.Transaction
+}
+
+func newFakeAccount(n int) *fakeAccount {
+	f := &fakeAccount{}
+	var prev *tlb.Transaction
+	for i := 1; i <= n; i++ {
+		tx := &tlb.Transaction{LT: uint64(i * 10), Hash: binary.BigEndian.AppendUint64(nil, uint64(i*10))}
+		if prev != nil {
+			tx.PrevTxLT, tx.PrevTxHash = prev.LT, prev.Hash
+		}
+		f.txs = append(f.txs, tx)
+		prev = tx
+	}
+	return f
+}
+
+func (f *fakeAccount) CurrentMasterchainInfo(context.Context) (*ton.BlockIDExt, error) {
+	return &ton.BlockIDExt{SeqNo: 1}, nil
+}
+
+func (f *fakeAccount) WaitForBlock(uint32) ton.APIClientWrapped {
+	return f
+}
+
+func (f *fakeAccount) GetAccount(context.Context, *ton.BlockIDExt, *address.Address) (*tlb.Account, error) {
+	last := f.txs[len(f.txs)-1]
+	return &tlb.Account{LastTxLT: last.LT, LastTxHash: last.Hash}, nil
+}
+
+func (f *fakeAccount) ListTransactions(_ context.Context, _ *address.Address, num uint32, lt uint64, _ []byte) ([]*tlb.Transaction, error) {
+	end := int(lt / 10)
+	if lt == 0 || lt%10 != 0 || end > len(f.txs) {
+		return nil, ton.ErrNoTransactionsWereFound
+	}
+	return f.txs[max(0, end-int(num)):end], nil
+}
+
+func TestTransactionsPage(t *testing.T) {
+	l := &LiteClient{api: newFakeAccount(100), cfg: config.ChainConfig{TransactionsBatchSize: 10}}
+	addr := address.NewAddress(0, 0, make([]byte, 32))
+
+	var got []uint64
+	var from TxRef
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("paging didn't end")
+		}
+		list, next, err := l.TransactionsPage(context.Background(), addr, from, 105, 25)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) > 25 {
+			t.Fatalf("page of %d transactions, want at most 25", len(list))
+		}
+		for _, tx := range list {
+			got = append(got, tx.LT)
+		}
+		if next.LT == 0 {
+			break
+		}
+		from = next
+	}
+	if len(got) != 90 {
+		t.Fatalf("got %d transactions, want 90", len(got))
+	}
+	for i, lt := range got {
+		if want := uint64(1000 - i*10); lt != want {
+			t.Fatalf("transaction %d has lt %d, want %d", i, lt, want)
+		}
+	}
+
+	list, next, err := l.TransactionsPage(context.Background(), addr, TxRef{}, 1000, 25)
+	if err != nil || len(list) != 0 || next.LT != 0 {
+		t.Errorf("TransactionsPage() above the last transaction = %d, %+v, %v, want none", len(list), next, err)
+	}
+	list, next, err = l.TransactionsPage(context.Background(), addr, TxRef{}, 0, 1000)
+	if err != nil || len(list) != 100 || next.LT != 0 {
+		t.Errorf("TransactionsPage() of all = %d, %+v, %v, want 100", len(list), next, err)
+	}
+}
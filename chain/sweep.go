@@ -0,0 +1,120 @@
+package chain
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"math/big"
+
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton/jetton"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+)
+
+// JettonBalance returns the balance in base units of the jetton wallet of
+// owner for master, zero when it isn't deployed.
+func (l *LiteClient) JettonBalance(ctx context.Context, master, owner string) (*big.Int, error) {
+	masterAddr, err := parseAddr(master)
+	if err != nil {
+		return nil, err
+	}
+	ownerAddr, err := parseAddr(owner)
+	if err != nil {
+		return nil, err
+	}
+	tokenWallet, err := jetton.NewJettonMasterClient(l.api, masterAddr).GetJettonWallet(ctx, ownerAddr)
+	if err != nil {
+		return nil, liteError("get jetton wallet", err)
+	}
+	balance, err := tokenWallet.GetBalance(ctx)
+	if err != nil {
+		return nil, liteError("get jetton balance", err)
+	}
+	return balance, nil
+}
+
+// SweepTON sends the balance of the wallet of key to to, all but keep of it.
+// The wallet is deployed by the same message when it isn't yet. With a zero
+// keep CarryAllRemainingBalance leaves nothing behind once fees are paid,
+// otherwise keep stays for later transfers, less the fees of this one.
+func (l *LiteClient) SweepTON(ctx context.Context, key ed25519.PrivateKey, to string, keep tlb.Coins) (*tlb.Transaction, error) {
+	w, err := wallet.FromPrivateKey(l.api, key, l.walletConfig())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	dst, err := parseAddr(to)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &wallet.Message{
+		// v5 deposit wallets reject the whole message without IgnoreErrors
+		Mode: wallet.CarryAllRemainingBalance + wallet.IgnoreErrors,
+		InternalMessage: &tlb.InternalMessage{
+			IHRDisabled: true,
+			Bounce:      dst.IsBounceable(),
+			DstAddr:     dst,
+			Amount:      tlb.ZeroCoins,
+		},
+	}
+	if keep.Nano().Sign() > 0 {
+		block, err := l.api.CurrentMasterchainInfo(ctx)
+		if err != nil {
+			return nil, liteError("get masterchain info", err)
+		}
+		balance, err := w.GetBalance(ctx, block)
+		if err != nil {
+			return nil, liteError("get balance", err)
+		}
+		amount := new(big.Int).Sub(balance.Nano(), keep.Nano())
+		if amount.Sign() <= 0 {
+			return nil, fmt.Errorf("%w: balance %s TON is not above %s TON to keep", ErrInvalidInput, balance.String(), keep.String())
+		}
+		msg = wallet.SimpleMessageAutoBounce(dst, tlb.FromNanoTON(amount), nil)
+	}
+	tx, _, err := w.SendWaitTransaction(ctx, msg)
+	if err != nil {
+		return nil, liteError("send sweep", err)
+	}
+	l.logger(ctx).Info().Str("wallet", l.formatAddr(w.WalletAddress())).Str("to", to).
+		Hex("hash", tx.Hash).Msg("swept ton")
+	return tx, nil
+}
+
+// SweepJetton transfers amount base units of master's jetton from the wallet
+// of key to to. fee is the TON attached for the jetton wallets, what is left
+// of it goes to to as well.
+func (l *LiteClient) SweepJetton(ctx context.Context, key ed25519.PrivateKey, master, to string, amount *big.Int, fee tlb.Coins) (*tlb.Transaction, error) {
+	w, err := wallet.FromPrivateKey(l.api, key, l.walletConfig())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	masterAddr, err := parseAddr(master)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseAddr(to)
+	if err != nil {
+		return nil, err
+	}
+	tokenWallet, err := jetton.NewJettonMasterClient(l.api, masterAddr).GetJettonWallet(ctx, w.WalletAddress())
+	if err != nil {
+		return nil, liteError("get jetton wallet", err)
+	}
+
+	coins, err := tlb.FromNano(amount, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: amount: %w", ErrInvalidInput, err)
+	}
+	payload, err := tokenWallet.BuildTransferPayloadV2(dst, dst, coins, tlb.ZeroCoins, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("build transfer payload: %w", err)
+	}
+	tx, _, err := w.SendWaitTransaction(ctx, wallet.SimpleMessage(tokenWallet.Address(), fee, payload))
+	if err != nil {
+		return nil, liteError("send jetton sweep", err)
+	}
+	l.logger(ctx).Info().Str("wallet", l.formatAddr(w.WalletAddress())).Str("master", master).
+		Str("amount", amount.String()).Str("to", to).Hex("hash", tx.Hash).Msg("swept jetton")
+	return tx, nil
+}
@@ -0,0 +1,196 @@
+package chain
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+// Send mode flags accepted in requests.
+const (
+	ModePayFeesSeparately = "pay_fees_separately"
+	ModeIgnoreErrors      = "ignore_errors"
+	ModeCarryAllBalance   = "carry_all_balance"
+)
+
+var modeFlags = map[string]uint8{
+	ModePayFeesSeparately: wallet.PayGasSeparately,
+	ModeIgnoreErrors:      wallet.IgnoreErrors,
+	ModeCarryAllBalance:   wallet.CarryAllRemainingBalance,
+}
+
+// DefaultMode is the send mode of transfers that set none.
+const DefaultMode = wallet.PayGasSeparately + wallet.IgnoreErrors
+
+// DefaultJettonFee is the TON attached to jetton transfers that set none,
+// for the jetton wallets to pay their fees. What is left goes to the
+// recipient.
+const DefaultJettonFee = "0.05"
+
+// TransferOptions is what a TON transfer carries besides its amount. At most
+// one of Comment and Body is set.
+type TransferOptions struct {
+	Comment string
+	// EncryptComment encrypts Comment to the public key the receiver returns
+	// from get_public_key, so it must be an active wallet.
+	EncryptComment bool
+	Body           *cell.Cell
+	// StateInit deploys the receiver, its address must match.
+	StateInit *tlb.StateInit
+	Mode      uint8
+	// Bounce defaults to the bounceable flag of the receiver address.
+	Bounce *bool
+	// ForwardAmount is the TON a jetton transfer passes on to the recipient
+	// with its transfer notification, Comment or Body being the forward
+	// payload. Jetton transfers only.
+	ForwardAmount tlb.Coins
+}
+
+// ParseMode combines send mode flags, DefaultMode when there are none.
+func ParseMode(flags []string) (uint8, error) {
+	if len(flags) == 0 {
+		return DefaultMode, nil
+	}
+	var mode uint8
+	for _, f := range flags {
+		v, ok := modeFlags[f]
+		if !ok {
+			return 0, fmt.Errorf("%w: unknown send mode flag %q", ErrInvalidInput, f)
+		}
+		mode |= v
+	}
+	return mode, nil
+}
+
+// ParseBoC decodes a base64 bag of cells with a single root.
+func ParseBoC(s string) (*cell.Cell, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: boc is not base64: %w", ErrInvalidInput, err)
+	}
+	c, err := cell.FromBOC(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: boc: %w", ErrInvalidInput, err)
+	}
+	return c, nil
+}
+
+// ParseStateInit decodes a base64 state init BoC.
+func ParseStateInit(s string) (*tlb.StateInit, error) {
+	c, err := ParseBoC(s)
+	if err != nil {
+		return nil, err
+	}
+	var si tlb.StateInit
+	if err = tlb.LoadFromCell(&si, c.BeginParse()); err != nil {
+		return nil, fmt.Errorf("%w: state_init: %w", ErrInvalidInput, err)
+	}
+	return &si, nil
+}
+
+// CarriesAllBalance reports whether the transfer sends the whole balance,
+// whatever its amount.
+func (o TransferOptions) CarriesAllBalance() bool {
+	return o.Mode&wallet.CarryAllRemainingBalance != 0
+}
+
+// check validates o for sending to dst from a wallet of spec.
+func (o TransferOptions) check(spec any, dst *address.Address) error {
+	if o.Comment != "" && o.Body != nil {
+		return fmt.Errorf("%w: a transfer has a comment or a body, not both", ErrInvalidInput)
+	}
+	if o.EncryptComment && o.Comment == "" {
+		return fmt.Errorf("%w: nothing to encrypt without a comment", ErrInvalidInput)
+	}
+	if o.Mode&wallet.CarryAllRemainingIncomingValue != 0 {
+		return fmt.Errorf("%w: external messages have no incoming value to carry", ErrInvalidInput)
+	}
+	switch spec.(type) {
+	case *wallet.SpecV5R1Final, *wallet.SpecV5R1Beta:
+		// v5 rejects the whole message otherwise
+		if o.Mode&wallet.IgnoreErrors == 0 {
+			return fmt.Errorf("%w: wallet v5 sends with %s only", ErrInvalidInput, ModeIgnoreErrors)
+		}
+	}
+	if o.StateInit != nil {
+		c, err := tlb.ToCell(o.StateInit)
+		if err != nil {
+			return fmt.Errorf("%w: state_init: %w", ErrInvalidInput, err)
+		}
+		if addr := address.NewAddress(0, byte(dst.Workchain()), c.Hash()); !addr.Equals(dst) {
+			return fmt.Errorf("%w: state_init is of %s, not the receiver", ErrInvalidInput, addr.String())
+		}
+	}
+	return nil
+}
+
+// checkJetton validates o for a jetton transfer from a wallet of spec to
+// the jetton wallet jw with fee attached.
+func (o TransferOptions) checkJetton(spec any, jw *address.Address, fee tlb.Coins) error {
+	if o.EncryptComment {
+		return fmt.Errorf("%w: jetton transfers can't encrypt comments", ErrInvalidInput)
+	}
+	if o.StateInit != nil || o.Bounce != nil {
+		return fmt.Errorf("%w: jetton transfers take no state_init or bounce", ErrInvalidInput)
+	}
+	if o.CarriesAllBalance() {
+		return fmt.Errorf("%w: jetton transfers can't carry the whole balance", ErrInvalidInput)
+	}
+	if o.ForwardAmount.Nano().Cmp(fee.Nano()) >= 0 {
+		return fmt.Errorf("%w: forward amount %s TON must be below the fee %s TON", ErrInvalidInput, o.ForwardAmount.String(), fee.String())
+	}
+	return o.check(spec, jw)
+}
+
+// forwardPayload is the payload a jetton transfer forwards, nil for none.
+func (o TransferOptions) forwardPayload() (*cell.Cell, error) {
+	if o.Comment == "" {
+		return o.Body, nil
+	}
+	body, err := wallet.CreateCommentCell(o.Comment)
+	if err != nil {
+		return nil, fmt.Errorf("%w: comment: %w", ErrInvalidInput, err)
+	}
+	return body, nil
+}
+
+// message builds the message of a transfer from w of key to dst, theirs is
+// the public key of dst for encrypted comments.
+func (o TransferOptions) message(w *wallet.Wallet, key ed25519.PrivateKey, dst *address.Address, amount tlb.Coins, theirs ed25519.PublicKey) (*wallet.Message, error) {
+	// bounceable messages come back when the destination isn't initialized
+	// or fails
+	bounce := dst.IsBounceable()
+	if o.Bounce != nil {
+		bounce = *o.Bounce
+	}
+
+	body := o.Body
+	switch {
+	case o.EncryptComment:
+		var err error
+		if body, err = wallet.CreateEncryptedCommentCell(o.Comment, w.WalletAddress(), key, theirs); err != nil {
+			return nil, fmt.Errorf("%w: encrypt comment: %w", ErrInvalidInput, err)
+		}
+	case o.Comment != "":
+		var err error
+		if body, err = wallet.CreateCommentCell(o.Comment); err != nil {
+			return nil, fmt.Errorf("%w: comment: %w", ErrInvalidInput, err)
+		}
+	}
+	return &wallet.Message{
+		Mode: o.Mode,
+		InternalMessage: &tlb.InternalMessage{
+			IHRDisabled: true,
+			Bounce:      bounce,
+			DstAddr:     dst,
+			Amount:      amount,
+			Body:        body,
+			StateInit:   o.StateInit,
+		},
+	}, nil
+}
@@ -0,0 +1,122 @@
+package chain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+func TestTransferOptionsCheck(t *testing.T) {
+	stateInit := &tlb.StateInit{
+		Code: cell.BeginCell().MustStoreUInt(1, 8).EndCell(),
+		Data: cell.BeginCell().MustStoreUInt(2, 8).EndCell(),
+	}
+	c, err := tlb.ToCell(stateInit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployed := address.NewAddress(0, 0, c.Hash())
+	other := address.NewAddress(0, 0, make([]byte, 32))
+	body := cell.BeginCell().MustStoreUInt(0, 32).EndCell()
+
+	tests := []struct {
+		name    string
+		opts    TransferOptions
+		spec    any
+		dst     *address.Address
+		wantErr bool
+	}{
+		{name: "defaults", opts: TransferOptions{Mode: DefaultMode}, dst: other},
+		{name: "comment", opts: TransferOptions{Comment: "hi", Mode: DefaultMode}, dst: other},
+		{name: "body", opts: TransferOptions{Body: body, Mode: DefaultMode}, dst: other},
+		{name: "comment and body", opts: TransferOptions{Comment: "hi", Body: body, Mode: DefaultMode}, dst: other, wantErr: true},
+		{name: "encrypted comment", opts: TransferOptions{Comment: "hi", EncryptComment: true, Mode: DefaultMode}, dst: other},
+		{name: "nothing to encrypt", opts: TransferOptions{EncryptComment: true, Mode: DefaultMode}, dst: other, wantErr: true},
+		{name: "carry all balance", opts: TransferOptions{Mode: wallet.CarryAllRemainingBalance}, dst: other},
+		{name: "carry incoming value", opts: TransferOptions{Mode: wallet.CarryAllRemainingIncomingValue}, dst: other, wantErr: true},
+		{name: "v5 ignoring errors", opts: TransferOptions{Mode: DefaultMode}, spec: &wallet.SpecV5R1Final{}, dst: other},
+		{name: "v5 without ignore errors", opts: TransferOptions{Mode: wallet.PayGasSeparately}, spec: &wallet.SpecV5R1Final{}, dst: other, wantErr: true},
+		{name: "v5 beta without ignore errors", opts: TransferOptions{Mode: wallet.PayGasSeparately}, spec: &wallet.SpecV5R1Beta{}, dst: other, wantErr: true},
+		{name: "v4 without ignore errors", opts: TransferOptions{Mode: wallet.PayGasSeparately}, spec: &wallet.SpecV4R2{}, dst: other},
+		{name: "state init of receiver", opts: TransferOptions{StateInit: stateInit, Mode: DefaultMode}, dst: deployed},
+		{name: "state init of another address", opts: TransferOptions{StateInit: stateInit, Mode: DefaultMode}, dst: other, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.check(tt.spec, tt.dst)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidInput) {
+					t.Errorf("check() error = %v, want ErrInvalidInput", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("check() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		flags   []string
+		want    uint8
+		wantErr bool
+	}{
+		{flags: nil, want: DefaultMode},
+		{flags: []string{ModePayFeesSeparately}, want: wallet.PayGasSeparately},
+		{flags: []string{ModeCarryAllBalance, ModeIgnoreErrors}, want: wallet.CarryAllRemainingBalance + wallet.IgnoreErrors},
+		{flags: []string{ModeIgnoreErrors, ModeIgnoreErrors}, want: wallet.IgnoreErrors},
+		{flags: []string{"destroy"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMode(tt.flags)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("ParseMode(%q) error = %v, want ErrInvalidInput", tt.flags, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMode(%q) = %d, %v, want %d", tt.flags, got, err, tt.want)
+		}
+	}
+}
+
+func TestTransferOptionsCheckJetton(t *testing.T) {
+	jw := address.NewAddress(0, 0, make([]byte, 32))
+	fee := tlb.MustFromTON("0.05")
+	bounce := true
+
+	tests := []struct {
+		name    string
+		opts    TransferOptions
+		wantErr bool
+	}{
+		{name: "defaults", opts: TransferOptions{Mode: DefaultMode, ForwardAmount: tlb.ZeroCoins}},
+		{name: "comment and forward amount", opts: TransferOptions{Comment: "hi", Mode: DefaultMode, ForwardAmount: tlb.MustFromTON("0.01")}},
+		{name: "forward amount of the whole fee", opts: TransferOptions{Mode: DefaultMode, ForwardAmount: fee}, wantErr: true},
+		{name: "encrypted comment", opts: TransferOptions{Comment: "hi", EncryptComment: true, Mode: DefaultMode, ForwardAmount: tlb.ZeroCoins}, wantErr: true},
+		{name: "bounce", opts: TransferOptions{Bounce: &bounce, Mode: DefaultMode, ForwardAmount: tlb.ZeroCoins}, wantErr: true},
+		{name: "carry all balance", opts: TransferOptions{Mode: wallet.CarryAllRemainingBalance, ForwardAmount: tlb.ZeroCoins}, wantErr: true},
+		{name: "comment and body", opts: TransferOptions{Comment: "hi", Body: cell.BeginCell().EndCell(), Mode: DefaultMode, ForwardAmount: tlb.ZeroCoins}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.checkJetton(&wallet.SpecV5R1Final{}, jw, fee)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidInput) {
+					t.Errorf("checkJetton() error = %v, want ErrInvalidInput", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("checkJetton() error = %v", err)
+			}
+		})
+	}
+}
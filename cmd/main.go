@@ -1,9 +1,37 @@
 package main
 
-import "github.com/FishDontExist/TONindexer/api"
+import (
+	"os"
+
+	"github.com/FishDontExist/TONindexer/api"
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/logging"
+	"github.com/rs/zerolog"
+)
 
 func main() {
-	
-	api.SetApi()
+	// used until the configured logger is built
+	lg := zerolog.New(os.Stderr).With().Timestamp().Logger()
+
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		if err := runVerify(os.Args[2:]); err != nil {
+			lg.Fatal().Err(err).Msg("verify")
+		}
+		return
+	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		lg.Fatal().Err(err).Msg("config")
+	}
+	configured, err := logging.New(cfg.Log)
+	if err != nil {
+		lg.Fatal().Err(err).Msg("logger")
+	}
+	lg = configured
+
+	if err = api.SetApi(cfg, lg); err != nil {
+		lg.Fatal().Err(err).Msg("api")
+	}
 
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/index"
+	"github.com/FishDontExist/TONindexer/logging"
+	"github.com/FishDontExist/TONindexer/verify"
+)
+
+// errIssues makes the verify command exit with a failure status.
+var errIssues = errors.New("verification found issues")
+
+// runVerify checks indexed blocks of a masterchain seqno range and prints
+// the report as json:
+//
+//	tonindexer verify -from 100 -to 200 [-repair] [config flags]
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	from := fs.Uint("from", 0, "first masterchain seqno to verify")
+	to := fs.Uint("to", 0, "last masterchain seqno to verify, defaults to from")
+	repair := fs.Bool("repair", false, "re-fetch blocks with issues and store the corrected records")
+	cfg, err := config.LoadWith(fs, args)
+	if err != nil {
+		return err
+	}
+	if *to == 0 {
+		*to = *from
+	}
+
+	lg, err := logging.New(cfg.Log)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	ln, err := chain.New(cfg, lg)
+	if err != nil {
+		return err
+	}
+	// the liteserver pool connects in the background
+	for {
+		if _, err = ln.API().GetMasterchainInfo(ctx); err == nil {
+			break
+		}
+		lg.Info().Err(err).Msg("waiting for liteservers")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(cfg.Chain.ReconnectDelay.Duration):
+		}
+	}
+
+	idx, err := index.Open(cfg.Storage.Dir, cfg.Storage.IndexBlocks)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	report, err := verify.New(ln.API(), idx).Run(ctx, uint32(*from), uint32(*to), *repair)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(report); err != nil {
+		return err
+	}
+	repaired := map[uint32]bool{}
+	for _, seqno := range report.Repaired {
+		repaired[seqno] = true
+	}
+	left := 0
+	for _, issue := range report.Issues {
+		if !repaired[issue.Master] {
+			left++
+		}
+	}
+	if left > 0 {
+		return fmt.Errorf("%w: %d not repaired", errIssues, left)
+	}
+	return nil
+}
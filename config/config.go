@@ -1,18 +1,608 @@
 package config
 
 import (
-	"context"
-	"log"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
 
-	"github.com/xssnick/tonutils-go/liteclient"
+	"github.com/rs/zerolog"
 )
 
-func GetConfig() (*liteclient.GlobalConfig, error) {
+// Config is the whole service configuration. It is built from defaults, then
+// an optional JSON file, then TONINDEXER_* environment variables and finally
+// command line flags, each layer overriding the previous one.
+type Config struct {
+	Network   NetworkSettings `json:"network"`
+	HTTP      HTTPConfig      `json:"http"`
+	Chain     ChainConfig     `json:"chain"`
+	Scanner   ScannerConfig   `json:"scanner"`
+	Stream    StreamConfig    `json:"stream"`
+	Storage   StorageConfig   `json:"storage"`
+	Webhook   WebhookConfig   `json:"webhook"`
+	Repair    RepairConfig    `json:"repair"`
+	Health    HealthConfig    `json:"health"`
+	Log       LogConfig       `json:"log"`
+	Admin     AdminConfig     `json:"admin"`
+	API       APIConfig       `json:"api"`
+	Keystore  KeystoreConfig  `json:"keystore"`
+	Deposits  DepositConfig   `json:"deposits"`
+	Sweep     SweepConfig     `json:"sweep"`
+	Payouts   PayoutConfig    `json:"payouts"`
+	Transfers TransferConfig  `json:"transfers"`
 
-	cfg, err := liteclient.GetConfigFromUrl(context.Background(), "https://ton.org/global.config.json")
+	// TON is resolved from Network by Load.
+	TON *NetworkConfig `json:"-"`
+}
+
+type NetworkSettings struct {
+	Name         string `json:"name"`
+	ConfigPath   string `json:"config_path"`
+	GlobalID     int32  `json:"global_id"`
+	JettonMaster string `json:"jetton_master"`
+	USDTMaster   string `json:"usdt_master"`
+	Treasury     string `json:"treasury"`
+}
+
+type HTTPConfig struct {
+	Addr         string   `json:"addr"`
+	ReadTimeout  Duration `json:"read_timeout"`
+	WriteTimeout Duration `json:"write_timeout"`
+	// RequestTimeout bounds liteserver work of read handlers, SendTimeout
+	// of handlers that send a message and wait for it to be confirmed.
+	RequestTimeout Duration `json:"request_timeout"`
+	SendTimeout    Duration `json:"send_timeout"`
+}
+
+type ChainConfig struct {
+	PrevBlocksLimit        int      `json:"prev_blocks_limit"`
+	BlockTransactionsLimit uint32   `json:"block_transactions_limit"`
+	TransactionsBatchSize  uint32   `json:"transactions_batch_size"`
+	HashLookupRetries      int      `json:"hash_lookup_retries"`
+	HashLookupRetryDelay   Duration `json:"hash_lookup_retry_delay"`
+	ReconnectDelay         Duration `json:"reconnect_delay"`
+}
+
+type ScannerConfig struct {
+	// Enabled runs the block scanner that feeds the streaming endpoints.
+	Enabled        bool     `json:"enabled"`
+	Workers        int      `json:"workers"`
+	TaskPoolSize   int      `json:"task_pool_size"`
+	AccountRetries int      `json:"account_retries"`
+	AccountTimeout Duration `json:"account_timeout"`
+	BlockRetries   int      `json:"block_retries"`
+	BlockTimeout   Duration `json:"block_timeout"`
+	OutOfSyncLag   uint32   `json:"out_of_sync_lag"`
+	MaxBatch       uint32   `json:"max_batch"`
+	// MaxResumeLag is how many masterchain blocks behind the chain the
+	// scanner may resume from the last indexed one. Further behind, it
+	// starts at the top and the skipped blocks are reported as a gap.
+	MaxResumeLag uint32 `json:"max_resume_lag"`
+}
+
+// StreamConfig configures the push endpoints fed by the scanner.
+type StreamConfig struct {
+	// Buffer is how many events a subscriber may fall behind before it is
+	// disconnected.
+	Buffer       int      `json:"buffer"`
+	PingInterval Duration `json:"ping_interval"`
+	PongTimeout  Duration `json:"pong_timeout"`
+	// ResumeLimit caps the transactions replayed per address on resume.
+	ResumeLimit int `json:"resume_limit"`
+	// History is how many recent blocks are kept for Last-Event-ID resume.
+	History int `json:"history"`
+}
+
+type StorageConfig struct {
+	// Dir holds the JSON state files of the service.
+	Dir string `json:"dir"`
+	// IndexBlocks is how many of the newest masterchain blocks keep their
+	// record in the block index for verify and repair. Older ones are only
+	// known as indexed.
+	IndexBlocks int `json:"index_blocks"`
+}
+
+// WebhookConfig configures deposit notifications for watched addresses.
+type WebhookConfig struct {
+	// URL is used for watches registered without their own callback url.
+	URL string `json:"url"`
+	// Secret signs every delivery, watches can't be added without it.
+	Secret string `json:"secret"`
+	// Confirmations is how many masterchain blocks, counting the one with
+	// the deposit, must be processed before the webhook is sent.
+	Confirmations  uint32   `json:"confirmations"`
+	Timeout        Duration `json:"timeout"`
+	MaxAttempts    int      `json:"max_attempts"`
+	InitialBackoff Duration `json:"initial_backoff"`
+	MaxBackoff     Duration `json:"max_backoff"`
+}
+
+type LogConfig struct {
+	// Level is a zerolog level name, it can be changed at runtime through
+	// the admin endpoint.
+	Level string `json:"level"`
+	// Format is json or console.
+	Format string `json:"format"`
+}
+
+// AdminConfig protects the admin endpoints. They are unavailable while
+// Token is empty.
+type AdminConfig struct {
+	Token string `json:"token"`
+}
+
+// APIConfig protects the endpoints that sign with or expose custodial
+// wallets. They are unavailable while Token is empty.
+type APIConfig struct {
+	Token string `json:"token"`
+}
+
+// KeystoreConfig configures the encrypted store of custodial wallets. The
+// wallet and transfer endpoints are unavailable while Passphrase is empty.
+type KeystoreConfig struct {
+	// Passphrase derives the encryption key, prefer setting it through
+	// TONINDEXER_KEYSTORE_PASSPHRASE over the config file.
+	Passphrase string `json:"passphrase"`
+}
+
+// DepositConfig configures the per-user deposit addresses, which need the
+// keystore.
+type DepositConfig struct {
+	// MasterWallet is the keystore id of the wallet deposit keys derive
+	// from. When empty one is generated on first start.
+	MasterWallet string `json:"master_wallet"`
+}
+
+// SweepConfig configures moving deposits to the treasury.
+type SweepConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Interval Duration `json:"interval"`
+	// Timeout bounds one transfer, including waiting for its transaction.
+	Timeout  Duration `json:"timeout"`
+	Treasury string   `json:"treasury"`
+	// MinBalance in TON, smaller balances are left until they grow.
+	MinBalance string `json:"min_balance"`
+	// JettonFee in TON is attached to every jetton sweep, the deposit
+	// wallet must hold it.
+	JettonFee string              `json:"jetton_fee"`
+	Jettons   []JettonSweepConfig `json:"jettons"`
+}
+
+type JettonSweepConfig struct {
+	Master string `json:"master"`
+	// MinAmount is in jetton base units.
+	MinAmount string `json:"min_amount"`
+}
+
+// PayoutConfig configures batch payouts from a highload v3 wallet, which
+// need the keystore. Their transfers are followed through the scanner.
+type PayoutConfig struct {
+	// Wallet is the keystore id of the key of the highload wallet. Payouts
+	// are disabled while it is empty.
+	Wallet string `json:"wallet"`
+	// MessageTTL is how long a sent chunk may wait to be executed. It is
+	// part of the wallet address, changing it changes the wallet.
+	MessageTTL Duration `json:"message_ttl"`
+	// ChunkSize is how many transfers go in one external message.
+	ChunkSize int `json:"chunk_size"`
+	MaxItems  int `json:"max_items"`
+	// MaxAttempts is how many times a chunk that expired unexecuted is sent.
+	MaxAttempts int      `json:"max_attempts"`
+	Interval    Duration `json:"interval"`
+	// Timeout bounds one liteserver call.
+	Timeout Duration `json:"timeout"`
+}
+
+// TransferConfig configures following sent transfers to their destination.
+type TransferConfig struct {
+	// Interval is how often receivers the scanner hasn't reported on are
+	// polled.
+	Interval Duration `json:"interval"`
+	// Timeout bounds one poll.
+	Timeout Duration `json:"timeout"`
+}
+
+// HealthConfig sets when /readyz reports the instance as not ready.
+type HealthConfig struct {
+	// MaxScannerLag is how many masterchain blocks the scanner may be behind.
+	MaxScannerLag uint64 `json:"max_scanner_lag"`
+	// MaxBlockAge is how old the last masterchain block known to the
+	// liteserver may be.
+	MaxBlockAge Duration `json:"max_block_age"`
+	Timeout     Duration `json:"timeout"`
+}
+
+// RepairConfig configures rescanning of shard blocks the scanner failed on.
+type RepairConfig struct {
+	Interval       Duration `json:"interval"`
+	MaxAttempts    int      `json:"max_attempts"`
+	InitialBackoff Duration `json:"initial_backoff"`
+	MaxBackoff     Duration `json:"max_backoff"`
+}
+
+// Duration is a time.Duration that reads from JSON strings like "3s".
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func Default() *Config {
+	return &Config{
+		Network: NetworkSettings{
+			Name: string(Mainnet),
+		},
+		HTTP: HTTPConfig{
+			Addr:           ":8000",
+			ReadTimeout:    Duration{15 * time.Second},
+			WriteTimeout:   Duration{2 * time.Minute},
+			RequestTimeout: Duration{30 * time.Second},
+			SendTimeout:    Duration{90 * time.Second},
+		},
+		Chain: ChainConfig{
+			PrevBlocksLimit:        200,
+			BlockTransactionsLimit: 300,
+			TransactionsBatchSize:  15,
+			HashLookupRetries:      4,
+			HashLookupRetryDelay:   Duration{5 * time.Second},
+			ReconnectDelay:         Duration{5 * time.Second},
+		},
+		Scanner: ScannerConfig{
+			Enabled:        true,
+			Workers:        60,
+			TaskPoolSize:   1000,
+			AccountRetries: 20,
+			AccountTimeout: Duration{3 * time.Second},
+			BlockRetries:   20,
+			BlockTimeout:   Duration{20 * time.Second},
+			OutOfSyncLag:   60,
+			MaxBatch:       100,
+			MaxResumeLag:   10000,
+		},
+		Stream: StreamConfig{
+			Buffer:       256,
+			PingInterval: Duration{30 * time.Second},
+			PongTimeout:  Duration{60 * time.Second},
+			ResumeLimit:  1000,
+			History:      1000,
+		},
+		Storage: StorageConfig{
+			Dir:         "data",
+			IndexBlocks: 100000,
+		},
+		Webhook: WebhookConfig{
+			Confirmations:  1,
+			Timeout:        Duration{10 * time.Second},
+			MaxAttempts:    10,
+			InitialBackoff: Duration{5 * time.Second},
+			MaxBackoff:     Duration{30 * time.Minute},
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Health: HealthConfig{
+			MaxScannerLag: 60,
+			MaxBlockAge:   Duration{2 * time.Minute},
+			Timeout:       Duration{5 * time.Second},
+		},
+		Sweep: SweepConfig{
+			Interval:   Duration{10 * time.Minute},
+			Timeout:    Duration{2 * time.Minute},
+			MinBalance: "0.5",
+			JettonFee:  "0.05",
+		},
+		Payouts: PayoutConfig{
+			MessageTTL:  Duration{5 * time.Minute},
+			ChunkSize:   250,
+			MaxItems:    1000,
+			MaxAttempts: 3,
+			Interval:    Duration{2 * time.Second},
+			Timeout:     Duration{30 * time.Second},
+		},
+		Transfers: TransferConfig{
+			Interval: Duration{30 * time.Second},
+			Timeout:  Duration{30 * time.Second},
+		},
+		Repair: RepairConfig{
+			Interval:       Duration{5 * time.Second},
+			MaxAttempts:    50,
+			InitialBackoff: Duration{10 * time.Second},
+			MaxBackoff:     Duration{10 * time.Minute},
+		},
+	}
+}
+
+// Load builds the configuration for the given command line arguments.
+func Load(args []string) (*Config, error) {
+	return LoadWith(flag.NewFlagSet("tonindexer", flag.ContinueOnError), args)
+}
+
+// LoadWith is Load for commands that define their own flags in fs.
+func LoadWith(fs *flag.FlagSet, args []string) (*Config, error) {
+	path := fs.String("config", os.Getenv("TONINDEXER_CONFIG"), "path to json config file")
+	network := fs.String("network", "", "ton network: mainnet, testnet or custom")
+	networkConfig := fs.String("network-config", "", "path to liteserver global config, required for custom network")
+	globalID := fs.Int("global-id", 0, "override network global id used by wallets")
+	jettonMaster := fs.String("jetton-master", "", "override default jetton master address")
+	addr := fs.String("addr", "", "http listen address")
+	workers := fs.Int("workers", 0, "scanner account fetch workers")
+	logLevel := fs.String("log-level", "", "log level: trace, debug, info, warn or error")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	if *path != "" {
+		if err := cfg.readFile(*path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "network":
+			cfg.Network.Name = *network
+		case "network-config":
+			cfg.Network.ConfigPath = *networkConfig
+		case "global-id":
+			cfg.Network.GlobalID = int32(*globalID)
+		case "jetton-master":
+			cfg.Network.JettonMaster = *jettonMaster
+		case "addr":
+			cfg.HTTP.Addr = *addr
+		case "workers":
+			cfg.Scanner.Workers = *workers
+		case "log-level":
+			cfg.Log.Level = *logLevel
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	ton, err := NewNetworkConfig(cfg.Network.Name, cfg.Network.ConfigPath)
 	if err != nil {
-		log.Fatalln("get config err: ", err.Error())
 		return nil, err
 	}
+	if cfg.Network.GlobalID != 0 {
+		ton.GlobalID = cfg.Network.GlobalID
+	}
+	if cfg.Network.JettonMaster != "" {
+		ton.JettonMaster = cfg.Network.JettonMaster
+	}
+	if cfg.Network.USDTMaster != "" {
+		ton.USDTMaster = cfg.Network.USDTMaster
+	}
+	if cfg.Network.Treasury != "" {
+		ton.Treasury = cfg.Network.Treasury
+	}
+	cfg.TON = ton
+
 	return cfg, nil
 }
+
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	if err = json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) applyEnv() error {
+	envString("TONINDEXER_NETWORK", &c.Network.Name)
+	envString("TONINDEXER_NETWORK_CONFIG", &c.Network.ConfigPath)
+	envString("TONINDEXER_JETTON_MASTER", &c.Network.JettonMaster)
+	envString("TONINDEXER_USDT_MASTER", &c.Network.USDTMaster)
+	envString("TONINDEXER_TREASURY", &c.Network.Treasury)
+	envString("TONINDEXER_HTTP_ADDR", &c.HTTP.Addr)
+	envString("TONINDEXER_DATA_DIR", &c.Storage.Dir)
+	envString("TONINDEXER_WEBHOOK_URL", &c.Webhook.URL)
+	envString("TONINDEXER_WEBHOOK_SECRET", &c.Webhook.Secret)
+	envString("TONINDEXER_LOG_LEVEL", &c.Log.Level)
+	envString("TONINDEXER_LOG_FORMAT", &c.Log.Format)
+	envString("TONINDEXER_ADMIN_TOKEN", &c.Admin.Token)
+	envString("TONINDEXER_API_TOKEN", &c.API.Token)
+	envString("TONINDEXER_KEYSTORE_PASSPHRASE", &c.Keystore.Passphrase)
+
+	var globalID int
+	if ok, err := envInt("TONINDEXER_GLOBAL_ID", &globalID); err != nil {
+		return err
+	} else if ok {
+		c.Network.GlobalID = int32(globalID)
+	}
+	if err := envBool("TONINDEXER_SCANNER_ENABLED", &c.Scanner.Enabled); err != nil {
+		return err
+	}
+	if _, err := envInt("TONINDEXER_SCANNER_WORKERS", &c.Scanner.Workers); err != nil {
+		return err
+	}
+	if _, err := envInt("TONINDEXER_SCANNER_TASK_POOL_SIZE", &c.Scanner.TaskPoolSize); err != nil {
+		return err
+	}
+	if err := envDuration("TONINDEXER_HTTP_WRITE_TIMEOUT", &c.HTTP.WriteTimeout); err != nil {
+		return err
+	}
+	if err := envDuration("TONINDEXER_HTTP_REQUEST_TIMEOUT", &c.HTTP.RequestTimeout); err != nil {
+		return err
+	}
+	if err := envDuration("TONINDEXER_HTTP_SEND_TIMEOUT", &c.HTTP.SendTimeout); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	if c.HTTP.Addr == "" {
+		errs = append(errs, errors.New("http.addr is empty"))
+	}
+	if c.HTTP.RequestTimeout.Duration <= 0 || c.HTTP.SendTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("http request timeouts must be positive"))
+	}
+	if c.Chain.PrevBlocksLimit <= 0 {
+		errs = append(errs, errors.New("chain.prev_blocks_limit must be positive"))
+	}
+	if c.Chain.BlockTransactionsLimit == 0 {
+		errs = append(errs, errors.New("chain.block_transactions_limit must be positive"))
+	}
+	if c.Chain.TransactionsBatchSize == 0 {
+		errs = append(errs, errors.New("chain.transactions_batch_size must be positive"))
+	}
+	if c.Chain.HashLookupRetries <= 0 {
+		errs = append(errs, errors.New("chain.hash_lookup_retries must be positive"))
+	}
+	if c.Chain.ReconnectDelay.Duration <= 0 {
+		errs = append(errs, errors.New("chain.reconnect_delay must be positive"))
+	}
+	if c.Scanner.Workers <= 0 {
+		errs = append(errs, errors.New("scanner.workers must be positive"))
+	}
+	if c.Scanner.TaskPoolSize <= 0 {
+		errs = append(errs, errors.New("scanner.task_pool_size must be positive"))
+	}
+	if c.Scanner.AccountRetries <= 0 || c.Scanner.BlockRetries <= 0 {
+		errs = append(errs, errors.New("scanner retries must be positive"))
+	}
+	if c.Scanner.AccountTimeout.Duration <= 0 || c.Scanner.BlockTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("scanner timeouts must be positive"))
+	}
+	if c.Scanner.MaxBatch == 0 {
+		errs = append(errs, errors.New("scanner.max_batch must be positive"))
+	}
+	if c.Stream.Buffer <= 0 {
+		errs = append(errs, errors.New("stream.buffer must be positive"))
+	}
+	if c.Stream.PingInterval.Duration <= 0 || c.Stream.PongTimeout.Duration <= c.Stream.PingInterval.Duration {
+		errs = append(errs, errors.New("stream.pong_timeout must be longer than a positive stream.ping_interval"))
+	}
+	if c.Stream.ResumeLimit <= 0 || c.Stream.History <= 0 {
+		errs = append(errs, errors.New("stream.resume_limit and stream.history must be positive"))
+	}
+	if c.Storage.Dir == "" {
+		errs = append(errs, errors.New("storage.dir is empty"))
+	}
+	if c.Storage.IndexBlocks <= 0 {
+		errs = append(errs, errors.New("storage.index_blocks must be positive"))
+	}
+	if c.Webhook.Confirmations == 0 || c.Webhook.MaxAttempts <= 0 {
+		errs = append(errs, errors.New("webhook.confirmations and webhook.max_attempts must be positive"))
+	}
+	if c.Webhook.Timeout.Duration <= 0 || c.Webhook.InitialBackoff.Duration <= 0 || c.Webhook.MaxBackoff.Duration < c.Webhook.InitialBackoff.Duration {
+		errs = append(errs, errors.New("webhook timeouts must be positive and max_backoff at least initial_backoff"))
+	}
+	if c.Repair.Interval.Duration <= 0 || c.Repair.MaxAttempts <= 0 {
+		errs = append(errs, errors.New("repair.interval and repair.max_attempts must be positive"))
+	}
+	if c.Repair.InitialBackoff.Duration <= 0 || c.Repair.MaxBackoff.Duration < c.Repair.InitialBackoff.Duration {
+		errs = append(errs, errors.New("repair.initial_backoff must be positive and repair.max_backoff at least that"))
+	}
+	if c.Health.MaxScannerLag == 0 || c.Health.MaxBlockAge.Duration <= 0 || c.Health.Timeout.Duration <= 0 {
+		errs = append(errs, errors.New("health.max_scanner_lag, health.max_block_age and health.timeout must be positive"))
+	}
+	if c.Sweep.Enabled && (c.Sweep.Treasury == "" || c.Keystore.Passphrase == "") {
+		errs = append(errs, errors.New("sweep needs sweep.treasury and keystore.passphrase"))
+	}
+	if c.Sweep.Enabled && (c.Sweep.Interval.Duration <= 0 || c.Sweep.Timeout.Duration <= 0) {
+		errs = append(errs, errors.New("sweep.interval and sweep.timeout must be positive"))
+	}
+	if c.Transfers.Interval.Duration <= 0 || c.Transfers.Timeout.Duration <= 0 {
+		errs = append(errs, errors.New("transfers.interval and transfers.timeout must be positive"))
+	}
+	if c.Payouts.Wallet != "" {
+		p := c.Payouts
+		if c.Keystore.Passphrase == "" {
+			errs = append(errs, errors.New("payouts need keystore.passphrase"))
+		}
+		if p.MessageTTL.Duration < time.Minute || p.MessageTTL.Duration >= (1<<22)*time.Second {
+			errs = append(errs, errors.New("payouts.message_ttl must be at least 1m and below 1<<22 seconds"))
+		}
+		if p.ChunkSize <= 0 || p.ChunkSize > 1000 || p.MaxItems <= 0 || p.MaxAttempts <= 0 {
+			errs = append(errs, errors.New("payouts.chunk_size must be 1 to 1000, payouts.max_items and payouts.max_attempts positive"))
+		}
+		if p.Interval.Duration <= 0 || p.Timeout.Duration <= 0 {
+			errs = append(errs, errors.New("payouts.interval and payouts.timeout must be positive"))
+		}
+	}
+	if _, err := zerolog.ParseLevel(c.Log.Level); err != nil || c.Log.Level == "" {
+		errs = append(errs, fmt.Errorf("log.level %q is not a log level", c.Log.Level))
+	}
+	if c.Log.Format != "json" && c.Log.Format != "console" {
+		errs = append(errs, fmt.Errorf("log.format must be json or console, got %q", c.Log.Format))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func envString(key string, dst *string) {
+	if v, ok := os.LookupEnv(key); ok {
+		*dst = v
+	}
+}
+
+func envInt(key string, dst *int) (bool, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return false, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", key, err)
+	}
+	*dst = n
+	return true, nil
+}
+
+func envBool(key string, dst *bool) error {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	*dst = b
+	return nil
+}
+
+func envDuration(key string, dst *Duration) error {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	dst.Duration = d
+	return nil
+}
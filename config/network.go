@@ -0,0 +1,102 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/xssnick/tonutils-go/liteclient"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+)
+
+type Network string
+
+const (
+	Mainnet Network = "mainnet"
+	Testnet Network = "testnet"
+	Custom  Network = "custom"
+)
+
+const (
+	MainnetConfigURL = "https://ton.org/global.config.json"
+	TestnetConfigURL = "https://ton.org/testnet-global.config.json"
+)
+
+// NetworkConfig is the single source of everything that differs between
+// mainnet, testnet and a local network.
+type NetworkConfig struct {
+	Name Network
+	// GlobalID is used by wallets that sign it into messages (V5).
+	GlobalID int32
+	// Testnet marks user-friendly addresses as testnet-only.
+	Testnet bool
+	// ConfigPath takes precedence over ConfigURL when set.
+	ConfigPath string
+	ConfigURL  string
+	// JettonMaster is the default jetton used by /sendjetton/.
+	JettonMaster string
+	// USDTMaster is the jetton GetJettonInfo and GetByAccout read, USDT on
+	// mainnet and unset elsewhere.
+	USDTMaster string
+	// Treasury is the account GetByAccout logs the transfers of, not the
+	// sweep treasury.
+	Treasury string
+	// ToncenterURL is the toncenter v3 api used for hash lookups.
+	ToncenterURL string
+}
+
+// NewNetworkConfig returns the preset for the given network name. For the
+// custom network configPath is required, for the others it optionally replaces
+// the downloaded liteserver config with a local file.
+func NewNetworkConfig(name string, configPath string) (*NetworkConfig, error) {
+	switch Network(strings.ToLower(name)) {
+	case Mainnet, "":
+		return &NetworkConfig{
+			Name:         Mainnet,
+			GlobalID:     wallet.MainnetGlobalID,
+			ConfigPath:   configPath,
+			ConfigURL:    MainnetConfigURL,
+			JettonMaster: "EQC7Vk6yHv-3Sc7sShVUo_kpO-LoCABRepLCjklU5DtQlHvx",
+			USDTMaster:   "EQCxE6mUtQJKFnGfaROTKOt1lZbDiiX1kCixRv7Nw2Id_sDs",
+			Treasury:     "EQAYqo4u7VF0fa4DPAebk4g9lBytj2VFny7pzXR0trjtXQaO",
+			ToncenterURL: "https://toncenter.com/api/v3",
+		}, nil
+	case Testnet:
+		return &NetworkConfig{
+			Name:         Testnet,
+			GlobalID:     wallet.TestnetGlobalID,
+			Testnet:      true,
+			ConfigPath:   configPath,
+			ConfigURL:    TestnetConfigURL,
+			ToncenterURL: "https://testnet.toncenter.com/api/v3",
+		}, nil
+	case Custom:
+		if configPath == "" {
+			return nil, fmt.Errorf("custom network requires a liteserver config file")
+		}
+		return &NetworkConfig{
+			Name:       Custom,
+			GlobalID:   wallet.TestnetGlobalID,
+			Testnet:    true,
+			ConfigPath: configPath,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown network %q", name)
+}
+
+// GetConfig loads the liteserver global config of the network.
+func GetConfig(ctx context.Context, n *NetworkConfig) (*liteclient.GlobalConfig, error) {
+	if n.ConfigPath != "" {
+		cfg, err := liteclient.GetConfigFromFile(n.ConfigPath)
+		if err != nil {
+			return nil, fmt.Errorf("read %s config from %s: %w", n.Name, n.ConfigPath, err)
+		}
+		return cfg, nil
+	}
+
+	cfg, err := liteclient.GetConfigFromUrl(ctx, n.ConfigURL)
+	if err != nil {
+		return nil, fmt.Errorf("download %s config: %w", n.Name, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/FishDontExist/TONindexer/logging"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// authorizeAdmin checks the admin bearer token and writes the error response
+// when it doesn't match.
+func (l *LiteNode) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	return checkBearer(w, r, l.adminToken, "admin")
+}
+
+// authorize checks the API bearer token of the endpoints that sign with or
+// expose custodial wallets, and writes the error response when it doesn't
+// match.
+func (l *LiteNode) authorize(w http.ResponseWriter, r *http.Request) bool {
+	return checkBearer(w, r, l.apiToken, "api")
+}
+
+// checkBearer fails closed: without a configured token nobody is let in.
+func checkBearer(w http.ResponseWriter, r *http.Request, want, name string) bool {
+	if want == "" {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, name+" token is not configured", nil)
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "invalid "+name+" token", nil)
+		return false
+	}
+	return true
+}
+
+func (l *LiteNode) GetLogLevel(w http.ResponseWriter, r *http.Request) {
+	if !l.authorizeAdmin(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LogLevel{Level: logging.Level()})
+}
+
+// SetLogLevel changes the level of every logger of the process.
+func (l *LiteNode) SetLogLevel(w http.ResponseWriter, r *http.Request) {
+	if !l.authorizeAdmin(w, r) {
+		return
+	}
+	var req LogLevel
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, r, err)
+		return
+	}
+	if err := logging.SetLevel(req.Level); err != nil {
+		writeBadRequest(w, r, errors.Join(errors.New("level must be one of trace, debug, info, warn, error, fatal, panic or disabled"), err))
+		return
+	}
+	zerolog.Ctx(r.Context()).WithLevel(zerolog.GlobalLevel()).Str("level", req.Level).Msg("log level changed")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LogLevel{Level: logging.Level()})
+}
+
+// ExportWallet returns the mnemonic of a stored wallet. Every export is
+// logged, as whoever has the mnemonic controls the funds.
+func (l *LiteNode) ExportWallet(w http.ResponseWriter, r *http.Request) {
+	if !l.authorizeAdmin(w, r) {
+		return
+	}
+	if l.keys == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "keystore is disabled", nil)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	wallet, err := l.keys.Get(id)
+	if err != nil {
+		writeChainError(w, r, err)
+		return
+	}
+	secret, err := l.keys.Secret(id)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "failed to decrypt wallet", err)
+		return
+	}
+	zerolog.Ctx(r.Context()).Warn().Str("wallet", id).Str("address", wallet.Address).Msg("mnemonic exported")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(ExportedWallet{Wallet: wallet, Secret: secret})
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/events"
+	"github.com/rs/zerolog"
+)
+
+// BlockGap is sent when a resumed stream misses blocks that are no longer
+// kept in history.
+type BlockGap struct {
+	From uint32 `json:"from"`
+	To   uint32 `json:"to"`
+}
+
+// StreamBlocks sends every processed masterchain block as a Server-Sent
+// Event with the block seqno as its id. A Last-Event-ID header replays the
+// blocks after it from history.
+func (l *LiteNode) StreamBlocks(w http.ResponseWriter, r *http.Request) {
+	if l.hub == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "scanner is disabled", nil)
+		return
+	}
+	var last uint64
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		var err error
+		if last, err = strconv.ParseUint(id, 10, 32); err != nil {
+			writeBadRequest(w, r, fmt.Errorf("Last-Event-ID: %w", err))
+			return
+		}
+	}
+
+	// the stream outlives the server write timeout, deadlines are set per write
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "streaming is not supported", err)
+		return
+	}
+
+	sub := l.hub.Subscribe()
+	defer l.hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event, id string, data any) error {
+		rc.SetWriteDeadline(time.Now().Add(l.timeout))
+		body, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if id != "" {
+			fmt.Fprintf(w, "id: %s\n", id)
+		}
+		if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, body); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	sendBlock := func(b events.Block) error {
+		last = uint64(b.Seqno)
+		return send("block", strconv.FormatUint(last, 10), b)
+	}
+	logErr := func(err error) {
+		zerolog.Ctx(r.Context()).Debug().Err(err).Msg("block stream closed")
+	}
+
+	if last > 0 {
+		blocks, complete := l.history.Since(uint32(last))
+		if !complete && len(blocks) > 0 {
+			if err := send("gap", "", BlockGap{From: uint32(last) + 1, To: blocks[0].Seqno - 1}); err != nil {
+				logErr(err)
+				return
+			}
+		}
+		for _, b := range blocks {
+			if err := sendBlock(b); err != nil {
+				logErr(err)
+				return
+			}
+		}
+	}
+
+	ping := time.NewTicker(l.stream.PingInterval.Duration)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				// the client reconnects with Last-Event-ID
+				return
+			}
+			if b, isBlock := ev.(events.Block); isBlock && uint64(b.Seqno) > last {
+				err = sendBlock(b)
+			}
+		case <-ping.C:
+			rc.SetWriteDeadline(time.Now().Add(l.timeout))
+			if _, err = fmt.Fprint(w, ": ping\n\n"); err == nil {
+				err = rc.Flush()
+			}
+		}
+		if err != nil {
+			logErr(err)
+			return
+		}
+	}
+}
@@ -1,27 +1,100 @@
 package controllers
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
 	"math/big"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/deposits"
+	"github.com/FishDontExist/TONindexer/dumps"
+	"github.com/FishDontExist/TONindexer/events"
+	"github.com/FishDontExist/TONindexer/idempotency"
+	"github.com/FishDontExist/TONindexer/keystore"
+	"github.com/FishDontExist/TONindexer/payouts"
+	"github.com/FishDontExist/TONindexer/repair"
+	"github.com/FishDontExist/TONindexer/sweep"
+	"github.com/FishDontExist/TONindexer/transfers"
+	"github.com/FishDontExist/TONindexer/webhooks"
 	"github.com/xssnick/tonutils-go/tlb"
 	"github.com/xssnick/tonutils-go/ton"
 )
 
 type LiteNode struct {
 	ln *chain.LiteClient
+	// hub is nil when the scanner is disabled.
+	hub     *events.Hub
+	history *events.History
+	hooks   *webhooks.Service
+	// repair and scanner are nil when the scanner is disabled.
+	repair  *repair.Repairer
+	scanner *dumps.Scanner
+	// keys is nil when no keystore passphrase is configured.
+	keys     *keystore.Store
+	deposits *deposits.Registry
+	// sweeper is nil when sweeping is disabled.
+	sweeper *sweep.Sweeper
+	// payouts is nil when no payouts wallet is configured.
+	payouts *payouts.Service
+	// idempotency records the responses of send requests by key.
+	idempotency *idempotency.Store
+	transfers   *transfers.Tracker
+
+	timeout     time.Duration
+	sendTimeout time.Duration
+	stream      config.StreamConfig
+	health      config.HealthConfig
+	adminToken  string
+	apiToken    string
 }
 
-func New() *LiteNode {
+// Services are the long-lived components handlers work with.
+type Services struct {
+	Chain *chain.LiteClient
+	// Hub is nil when the scanner is disabled.
+	Hub      *events.Hub
+	History  *events.History
+	Webhooks *webhooks.Service
+	// Repair and Scanner are nil when the scanner is disabled.
+	Repair  *repair.Repairer
+	Scanner *dumps.Scanner
+	// Keys is nil when no keystore passphrase is configured.
+	Keys     *keystore.Store
+	Deposits *deposits.Registry
+	// Sweeper is nil when sweeping is disabled.
+	Sweeper *sweep.Sweeper
+	// Payouts is nil when no payouts wallet is configured.
+	Payouts     *payouts.Service
+	Idempotency *idempotency.Store
+	Transfers   *transfers.Tracker
+}
+
+func New(cfg *config.Config, svc Services) *LiteNode {
 	return &LiteNode{
-		ln: chain.New(),
+		ln:          svc.Chain,
+		hub:         svc.Hub,
+		history:     svc.History,
+		hooks:       svc.Webhooks,
+		repair:      svc.Repair,
+		scanner:     svc.Scanner,
+		keys:        svc.Keys,
+		deposits:    svc.Deposits,
+		sweeper:     svc.Sweeper,
+		payouts:     svc.Payouts,
+		idempotency: svc.Idempotency,
+		transfers:   svc.Transfers,
+		timeout:     cfg.HTTP.RequestTimeout.Duration,
+		sendTimeout: cfg.HTTP.SendTimeout.Duration,
+		stream:      cfg.Stream,
+		health:      cfg.Health,
+		adminToken:  cfg.Admin.Token,
+		apiToken:    cfg.API.Token,
 	}
 }
 
@@ -33,10 +106,13 @@ func Ping(w http.ResponseWriter, r *http.Request) {
 func (l *LiteNode) GetHeight(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(r.Context(), l.timeout)
+	defer cancel()
 
-	latestBlockInfo, err := l.ln.GetHeight()
+	latestBlockInfo, err := l.ln.GetHeight(ctx)
 	if err != nil {
-		log.Println("get height err: ", err.Error())
+		writeChainError(w, r, err)
+		return
 	}
 
 	response := createConcatHeight(latestBlockInfo)
@@ -47,17 +123,17 @@ func (l *LiteNode) GetHeight(w http.ResponseWriter, r *http.Request) {
 func (l *LiteNode) GetBlockTransactions(w http.ResponseWriter, r *http.Request) {
 	var heightReq HeightReq
 	w.Header().Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(r.Context(), l.timeout)
+	defer cancel()
 	if err := json.NewDecoder(r.Body).Decode(&heightReq); err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]error{"err": err})
+		writeBadRequest(w, r, err)
+		return
 	}
 
 	height, err := decomposeHeight(heightReq.Height)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]error{"err": err})
+		writeBadRequest(w, r, err)
+		return
 	}
 
 	requestedBlock := &ton.BlockIDExt{
@@ -67,81 +143,168 @@ func (l *LiteNode) GetBlockTransactions(w http.ResponseWriter, r *http.Request)
 		RootHash:  height.RootHash,
 		FileHash:  height.FileHash,
 	}
-	transactions, err := l.ln.GetBlockInfoByHeight(requestedBlock)
+	transactions, err := l.ln.GetBlockInfoByHeight(ctx, requestedBlock)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]error{"err": err})
+		writeChainError(w, r, err)
+		return
 	}
 
 	response, err := json.MarshalIndent(transactions, "", "  ")
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]error{"err": err})
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "failed to encode transactions", err)
+		return
 	}
 	json.NewEncoder(w).Encode(string(response))
 
 }
 
+// GenerateNewWallet creates a wallet and stores its mnemonic in the
+// keystore. Only the wallet id and address are returned.
 func (l *LiteNode) GenerateNewWallet(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	if l.keys == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "keystore is disabled", nil)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(r.Context(), l.timeout)
+	defer cancel()
 
-	wallet, err := l.ln.GenerateWallet()
+	wallet, err := l.ln.GenerateWallet(ctx, "")
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		writeChainError(w, r, err)
+		return
+	}
+	stored, err := l.keys.Add(wallet.Address, r.URL.Query().Get("label"), keystore.Secret{Mnemonic: wallet.Mnemonic})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "failed to store wallet", err)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(wallet)
+	json.NewEncoder(w).Encode(stored)
 }
 
 func (l *LiteNode) SendTransactionV2(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(r.Context(), l.sendTimeout)
+	defer cancel()
 	var transaction Transaction
 	if err := json.NewDecoder(r.Body).Decode(&transaction); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		writeBadRequest(w, r, err)
 		return
 	}
-	tx, ok := l.ln.Transfer(transaction.Reciever, transaction.PrivateKey, float64(transaction.Amount))
+	opts, err := transferOptions(transaction)
+	if err != nil {
+		writeChainError(w, r, err)
+		return
+	}
+	amount := tlb.ZeroCoins
+	if transaction.Amount != "" || !opts.CarriesAllBalance() {
+		if amount, err = chain.ParseTON(transaction.Amount, transaction.Unit); err != nil {
+			writeChainError(w, r, err)
+			return
+		}
+	}
+	key, ok := l.signingKey(w, r, transaction.WalletID)
 	if !ok {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Transaction failed"})
 		return
 	}
-	hash := hex.EncodeToString(tx.Hash)
-	w.WriteHeader(http.StatusOK)
+	l.idempotent(w, r, "sendtx", transaction, func(w http.ResponseWriter) {
+		tx, err := l.ln.Transfer(ctx, transaction.Reciever, key, amount, opts)
+		if err != nil {
+			writeChainError(w, r, err)
+			return
+		}
+		transfer := l.transfers.Track(tx)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"tx": hex.EncodeToString(tx.Hash), "transfer": transfer.ID})
+	})
+}
 
-	// ! you can concat hash here
-	// TODO
+func transferOptions(t Transaction) (chain.TransferOptions, error) {
+	opts := chain.TransferOptions{
+		Comment:        t.Comment,
+		EncryptComment: t.EncryptedComment,
+		Bounce:         t.Bounce,
+	}
+	var err error
+	if opts.Mode, err = chain.ParseMode(t.Mode); err != nil {
+		return opts, err
+	}
+	if t.Body != "" {
+		if opts.Body, err = chain.ParseBoC(t.Body); err != nil {
+			return opts, err
+		}
+	}
+	if t.StateInit != "" {
+		if opts.StateInit, err = chain.ParseStateInit(t.StateInit); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
 
-	json.NewEncoder(w).Encode(map[string]string{"tx": hash})
+func jettonOptions(j Jetton) (tlb.Coins, chain.TransferOptions, error) {
+	opts := chain.TransferOptions{Comment: j.Comment, ForwardAmount: tlb.ZeroCoins}
+	fee := j.Fee
+	if fee == "" {
+		fee = chain.DefaultJettonFee
+	}
+	feeCoins, err := chain.ParseTON(fee, chain.UnitTON)
+	if err != nil {
+		return tlb.Coins{}, opts, fmt.Errorf("fee: %w", err)
+	}
+	if j.ForwardAmount != "" {
+		if opts.ForwardAmount, err = chain.ParseTON(j.ForwardAmount, chain.UnitTON); err != nil {
+			return tlb.Coins{}, opts, fmt.Errorf("forward_amount: %w", err)
+		}
+	}
+	if opts.Mode, err = chain.ParseMode(j.Mode); err != nil {
+		return tlb.Coins{}, opts, err
+	}
+	if j.Body != "" {
+		if opts.Body, err = chain.ParseBoC(j.Body); err != nil {
+			return tlb.Coins{}, opts, err
+		}
+	}
+	return feeCoins, opts, nil
 }
 
 func (l *LiteNode) GetBalance(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(r.Context(), l.timeout)
+	defer cancel()
 	var address Balance
 	if err := json.NewDecoder(r.Body).Decode(&address); err != nil {
-		log.Println(err)
-		json.NewEncoder(w).Encode(map[string]string{"err": err.Error()})
+		writeBadRequest(w, r, err)
+		return
 	}
 
-	coins, err := l.ln.GetBalance(address.Address)
+	coins, err := l.ln.GetBalance(ctx, address.Address)
 	if err != nil {
-		log.Println(err)
-		json.NewEncoder(w).Encode(map[string]string{"err": err.Error()})
+		writeChainError(w, r, err)
+		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]int64{"balance": coins.Nano().Int64()})
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]Amount{"balance": newAmount(coins.Nano())})
 }
 
 func (l *LiteNode) GetSimpleBlock(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	blockInfo, err := l.ln.GetHeight()
+	ctx, cancel := context.WithTimeout(r.Context(), l.timeout)
+	defer cancel()
+	blockInfo, err := l.ln.GetHeight(ctx)
 	if err != nil {
-		log.Println(err)
+		writeChainError(w, r, err)
+		return
 	}
 	response := map[string]any{"block": int(blockInfo.SeqNo), "timestamp": time.Now()}
 	json.NewEncoder(w).Encode(response)
@@ -177,14 +340,16 @@ func (l *LiteNode) GetSimpleBlock(w http.ResponseWriter, r *http.Request) {
 func (l *LiteNode) GetTransactionForAddr(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(r.Context(), l.timeout)
+	defer cancel()
 	var address TransactionForAddr
 	if err := json.NewDecoder(r.Body).Decode(&address); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		writeBadRequest(w, r, err)
 		return
 	}
-	transactions, err := l.ln.GetTransactions(address.Addr)
+	transactions, err := l.ln.GetTransactions(ctx, address.Addr)
 	if err != nil {
-		http.Error(w, "cannot retrieve transactions", http.StatusInternalServerError)
+		writeChainError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -195,42 +360,75 @@ func (l *LiteNode) GetTransactionForAddr(w http.ResponseWriter, r *http.Request)
 
 
 func (l *LiteNode) SendJetton(w http.ResponseWriter, r *http.Request) {
-
+	if !l.authorize(w, r) {
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(r.Context(), l.sendTimeout)
+	defer cancel()
 	var jetton Jetton
 	if err := json.NewDecoder(r.Body).Decode(&jetton); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		writeBadRequest(w, r, err)
 		return
 	}
 
-	hash, ok := l.ln.SendJetton(jetton.PrivateKey, jetton.Reciever, jetton.Amount)
+	decimals, err := l.ln.JettonDecimals(ctx)
+	if err != nil {
+		writeChainError(w, r, err)
+		return
+	}
+	amount, err := chain.ParseJetton(jetton.Amount, jetton.Unit, decimals)
+	if err != nil {
+		writeChainError(w, r, err)
+		return
+	}
+	fee, opts, err := jettonOptions(jetton)
+	if err != nil {
+		writeChainError(w, r, err)
+		return
+	}
+	key, ok := l.signingKey(w, r, jetton.WalletID)
 	if !ok {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Transaction failed"})
 		return
 	}
-
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"tx": hash})
+	l.idempotent(w, r, "sendjetton", jetton, func(w http.ResponseWriter) {
+		tx, err := l.ln.SendJetton(ctx, key, amount, jetton.Reciever, fee, opts)
+		if err != nil {
+			writeChainError(w, r, err)
+			return
+		}
+		// followed through the jetton wallet of the sender to the one of
+		// the recipient
+		transfer := l.transfers.TrackJetton(tx)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"tx": hex.EncodeToString(tx.Hash), "transfer": transfer.ID})
+	})
 }
 
-func GetTransactionByHash(w http.ResponseWriter, r *http.Request) {
+func (l *LiteNode) GetTransactionByHash(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(r.Context(), l.timeout)
+	defer cancel()
 	var txHash string
 	if err := json.NewDecoder(r.Body).Decode(&txHash); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		writeBadRequest(w, r, err)
 		return
 	}
-	transactions, err := chain.GetTransactionWithHash(txHash)
+	transactions, err := l.ln.GetTransactionWithHash(ctx, txHash)
 	if err != nil {
-		http.Error(w, "cannot retrieve transactions", http.StatusInternalServerError)
+		writeChainError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(transactions)
 }
 
+func newAmount(nano *big.Int) Amount {
+	return Amount{Nanoton: nano.String(), TON: chain.FormatDecimal(nano, 9)}
+}
+
 func decomposeHeight(combinedHeight string) (*DecomposeHeightT, error) {
 	// Split the combined string by "|"
 	parts := strings.Split(combinedHeight, "|")
@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/FishDontExist/TONindexer/deposits"
+	"github.com/gorilla/mux"
+)
+
+type DepositAddress struct {
+	deposits.Address
+	// Watched is false when the address couldn't be added to the watchlist,
+	// e.g. because webhooks aren't configured. It is retried on restart.
+	Watched bool `json:"watched"`
+}
+
+type DepositList struct {
+	Addresses []deposits.Address `json:"addresses"`
+}
+
+// GetDeposit returns the deposit address of a user and index, deriving and
+// registering it on first use.
+func (l *LiteNode) GetDeposit(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	if l.deposits == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "keystore is disabled", nil)
+		return
+	}
+	vars := mux.Vars(r)
+	index, err := strconv.ParseUint(vars["index"], 10, 32)
+	if err != nil {
+		writeBadRequest(w, r, err)
+		return
+	}
+	addr, watched, err := l.deposits.Address(vars["user_id"], uint32(index))
+	if err != nil {
+		writeChainError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DepositAddress{Address: addr, Watched: watched})
+}
+
+// ListDeposits lists the registered deposit addresses, of one user when the
+// path has one.
+func (l *LiteNode) ListDeposits(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	if l.deposits == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "keystore is disabled", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DepositList{Addresses: l.deposits.List(mux.Vars(r)["user_id"])})
+}
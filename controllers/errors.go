@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/requestid"
+	"github.com/rs/zerolog"
+)
+
+const (
+	CodeBadRequest   = "bad_request"
+	CodeBadAddress   = "bad_address"
+	CodeNotFound     = "not_found"
+	CodeUnknown      = "unknown_block"
+	CodeLiteserver   = "liteserver_error"
+	CodeTimeout      = "timeout"
+	CodeCanceled     = "canceled"
+	CodeInternal     = "internal_error"
+	CodeUnavailable  = "unavailable"
+	CodeUnauthorized = "unauthorized"
+	CodeConflict     = "conflict"
+)
+
+// statusClientClosedRequest is the non-standard status used when the client
+// went away before the response was ready.
+const statusClientClosedRequest = 499
+
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   string `json:"details,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeError writes the error envelope. Handlers must return right after it.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, err error) {
+	body := ErrorBody{
+		Code:      code,
+		Message:   message,
+		RequestID: requestid.From(r.Context()),
+	}
+	if err != nil {
+		body.Details = err.Error()
+	}
+	lg := zerolog.Ctx(r.Context())
+	ev := lg.Info()
+	if status >= http.StatusInternalServerError {
+		ev = lg.Error()
+	}
+	ev.Str("method", r.Method).Str("path", r.URL.Path).Int("status", status).Str("code", code).Str("details", body.Details).Msg("request failed")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: body})
+}
+
+func writeBadRequest(w http.ResponseWriter, r *http.Request, err error) {
+	writeError(w, r, http.StatusBadRequest, CodeBadRequest, "invalid request", err)
+}
+
+// writeChainError maps errors returned by the chain package to http statuses.
+func writeChainError(w http.ResponseWriter, r *http.Request, err error) {
+	status, code, message := classifyChainError(err)
+	writeError(w, r, status, code, message, err)
+}
+
+func classifyChainError(err error) (status int, code, message string) {
+	switch {
+	case errors.Is(err, chain.ErrBadAddress):
+		return http.StatusBadRequest, CodeBadAddress, "bad address"
+	case errors.Is(err, chain.ErrInvalidInput):
+		return http.StatusBadRequest, CodeBadRequest, "invalid request"
+	case errors.Is(err, chain.ErrUnknownBlock):
+		return http.StatusNotFound, CodeUnknown, "unknown block"
+	case errors.Is(err, chain.ErrNotFound):
+		return http.StatusNotFound, CodeNotFound, "not found"
+	case errors.Is(err, chain.ErrCanceled):
+		return statusClientClosedRequest, CodeCanceled, "request canceled"
+	case errors.Is(err, chain.ErrTimeout):
+		return http.StatusGatewayTimeout, CodeTimeout, "liteserver timeout"
+	case errors.Is(err, chain.ErrLiteserver):
+		return http.StatusBadGateway, CodeLiteserver, "liteserver failure"
+	}
+	return http.StatusInternalServerError, CodeInternal, "internal error"
+}
+
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, CodeNotFound, "route not found", nil)
+}
+
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, CodeBadRequest, "method not allowed", nil)
+}
+
+// InternalError reports an unexpected failure, such as a recovered panic.
+func InternalError(w http.ResponseWriter, r *http.Request, err error) {
+	writeError(w, r, http.StatusInternalServerError, CodeInternal, "internal error", err)
+}
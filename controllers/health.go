@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Readiness check statuses.
+const (
+	CheckOK       = "ok"
+	CheckFail     = "fail"
+	CheckDisabled = "disabled"
+)
+
+type Check struct {
+	Status string `json:"status"`
+	Value  any    `json:"value,omitempty"`
+	Limit  any    `json:"limit,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Readiness struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// Healthz reports that the process is up and serving requests.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": CheckOK})
+}
+
+// Readyz checks the liteserver connection, how old the last masterchain
+// block is and how far the scanner is behind. It responds 503 when any of
+// them fails, so the instance stops getting traffic.
+func (l *LiteNode) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), l.health.Timeout.Duration)
+	defer cancel()
+
+	res := Readiness{Status: CheckOK, Checks: map[string]Check{}}
+	set := func(name string, c Check) {
+		res.Checks[name] = c
+		if c.Status == CheckFail {
+			res.Status = CheckFail
+		}
+	}
+
+	st, err := l.ln.MasterchainStatus(ctx)
+	if err != nil {
+		set("liteserver", Check{Status: CheckFail, Error: err.Error()})
+		set("masterchain_age", Check{Status: CheckFail, Error: "liteserver unavailable"})
+	} else {
+		set("liteserver", Check{Status: CheckOK, Value: st.Seqno})
+
+		age := time.Duration(0)
+		if st.Now > st.LastUtime {
+			age = time.Duration(st.Now-st.LastUtime) * time.Second
+		}
+		c := Check{Status: CheckOK, Value: age.String(), Limit: l.health.MaxBlockAge.String()}
+		if age > l.health.MaxBlockAge.Duration {
+			c.Status = CheckFail
+		}
+		set("masterchain_age", c)
+	}
+
+	if l.scanner == nil {
+		set("scanner_lag", Check{Status: CheckDisabled})
+	} else if lag, err := l.scanner.GetLag(ctx); err != nil {
+		set("scanner_lag", Check{Status: CheckFail, Error: err.Error()})
+	} else {
+		c := Check{Status: CheckOK, Value: lag, Limit: l.health.MaxScannerLag}
+		if lag > l.health.MaxScannerLag {
+			c.Status = CheckFail
+			c.Error = fmt.Sprintf("scanner is %d masterchain blocks behind", lag)
+		}
+		set("scanner_lag", c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if res.Status != CheckOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(res)
+}
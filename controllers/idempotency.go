@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+
+	"github.com/FishDontExist/TONindexer/idempotency"
+)
+
+// idempotencyHeader is the request header carrying the key.
+const idempotencyHeader = "Idempotency-Key"
+
+// replayedHeader is set on responses recorded for an earlier request.
+const replayedHeader = "Idempotent-Replayed"
+
+// recorder keeps a copy of the response it writes.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+// idempotent calls send once per Idempotency-Key of scope. A repeated
+// request gets the recorded response of the first one, req tells the two
+// apart from a reuse of the key. After a response that isn't definitive,
+// e.g. a liteserver failure, send is called again. Without a key send is
+// always called.
+func (l *LiteNode) idempotent(w http.ResponseWriter, r *http.Request, scope string, req any, send func(http.ResponseWriter)) {
+	key := r.Header.Get(idempotencyHeader)
+	if key == "" {
+		send(w)
+		return
+	}
+	hash, err := idempotency.Hash(req)
+	if err != nil {
+		writeBadRequest(w, r, err)
+		return
+	}
+	rec, started, err := l.idempotency.Begin(scope, key, hash)
+	if err != nil {
+		writeIdempotencyError(w, r, err)
+		return
+	}
+	if !started {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(replayedHeader, "true")
+		w.WriteHeader(rec.Status)
+		w.Write(rec.Body)
+		return
+	}
+	out := &recorder{ResponseWriter: w}
+	send(out)
+	l.idempotency.Finish(rec, out.status, out.body.Bytes())
+}
+
+// writeIdempotencyError writes the response for errors of the idempotency
+// package and the ones wrapping them, otherwise it maps err as a chain error.
+func writeIdempotencyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, idempotency.ErrConflict):
+		writeError(w, r, http.StatusConflict, CodeConflict, "idempotency key was used for a different request", err)
+	case errors.Is(err, idempotency.ErrInProgress):
+		writeError(w, r, http.StatusConflict, CodeConflict, "request with this idempotency key has no result yet", err)
+	default:
+		writeChainError(w, r, err)
+	}
+}
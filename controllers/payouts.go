@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/FishDontExist/TONindexer/payouts"
+	"github.com/gorilla/mux"
+)
+
+type PayoutBatchReq struct {
+	Items []payouts.Request `json:"items"`
+}
+
+type PayoutWallet struct {
+	// Address must hold the payouts and their fees.
+	Address string `json:"address"`
+}
+
+// SubmitPayouts queues a batch of transfers from the payouts wallet. A
+// request repeated with the same Idempotency-Key header returns the batch
+// queued the first time with 200 instead of 202.
+func (l *LiteNode) SubmitPayouts(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	if l.payouts == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "payouts are disabled", nil)
+		return
+	}
+	var req PayoutBatchReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, r, err)
+		return
+	}
+	batch, replayed, err := l.payouts.Submit(r.Header.Get(idempotencyHeader), req.Items)
+	if err != nil {
+		writeIdempotencyError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if replayed {
+		w.Header().Set(replayedHeader, "true")
+	} else {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(batch)
+}
+
+// GetPayouts returns a batch with the status of each item and chunk.
+func (l *LiteNode) GetPayouts(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	if l.payouts == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "payouts are disabled", nil)
+		return
+	}
+	batch, err := l.payouts.Get(mux.Vars(r)["id"])
+	if err != nil {
+		writeChainError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batch)
+}
+
+// PayoutWallet returns the address of the highload wallet to fund.
+func (l *LiteNode) PayoutWallet(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	if l.payouts == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "payouts are disabled", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PayoutWallet{Address: l.payouts.Address()})
+}
@@ -0,0 +1,27 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Gaps lists the masterchain and shard block ranges that are not fully
+// indexed yet.
+func (l *LiteNode) Gaps(w http.ResponseWriter, r *http.Request) {
+	if l.repair == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "scanner is disabled", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l.repair.Gaps())
+}
+
+// Retries reports the retry queue of failed shard blocks and accounts.
+func (l *LiteNode) Retries(w http.ResponseWriter, r *http.Request) {
+	if l.repair == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "scanner is disabled", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l.repair.Stats())
+}
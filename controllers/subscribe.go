@@ -0,0 +1,274 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/events"
+	"github.com/FishDontExist/TONindexer/requestid"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+	"github.com/xssnick/tonutils-go/address"
+)
+
+var upgrader = websocket.Upgrader{
+	// the api has no cookie based auth, so any origin may connect
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// SubscribeRequest is sent by websocket clients. Type is "subscribe" or
+// "unsubscribe". SinceLT maps an address to the last LT the client has seen,
+// transactions after it are replayed before live ones.
+type SubscribeRequest struct {
+	Type          string            `json:"type"`
+	Addresses     []string          `json:"addresses"`
+	JettonMasters []string          `json:"jetton_masters"`
+	Blocks        bool              `json:"blocks"`
+	SinceLT       map[string]uint64 `json:"since_lt"`
+}
+
+// StreamMessage is pushed to websocket clients. Type is "transaction",
+// "block", "subscribed", "unsubscribed" or "error".
+type StreamMessage struct {
+	Type  string     `json:"type"`
+	Data  any        `json:"data,omitempty"`
+	Error *ErrorBody `json:"error,omitempty"`
+}
+
+// wsFilter is the per-connection subscription state.
+type wsFilter struct {
+	// addresses maps a raw address to the last LT sent for it.
+	addresses     map[string]uint64
+	jettonMasters map[string]struct{}
+	blocks        bool
+}
+
+func (f *wsFilter) match(ev any) bool {
+	switch e := ev.(type) {
+	case events.Transaction:
+		if last, ok := f.addresses[e.Account]; ok && e.Tx.LT > last {
+			f.addresses[e.Account] = e.Tx.LT
+			return true
+		}
+		_, ok := f.jettonMasters[e.JettonMaster]
+		return ok && e.JettonMaster != ""
+	case events.Block:
+		return f.blocks
+	}
+	return false
+}
+
+type wsRead struct {
+	req SubscribeRequest
+	err error
+}
+
+// Subscribe upgrades to a websocket that pushes decoded transactions of
+// subscribed addresses and jetton masters, and new blocks.
+func (l *LiteNode) Subscribe(w http.ResponseWriter, r *http.Request) {
+	if l.hub == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "scanner is disabled", nil)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already replied
+		zerolog.Ctx(r.Context()).Debug().Err(err).Msg("websocket upgrade")
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	sub := l.hub.Subscribe()
+	defer l.hub.Unsubscribe(sub)
+
+	reads := make(chan wsRead)
+	go l.readSubscriptions(ctx, conn, reads)
+
+	ping := time.NewTicker(l.stream.PingInterval.Duration)
+	defer ping.Stop()
+
+	f := &wsFilter{addresses: map[string]uint64{}, jettonMasters: map[string]struct{}{}}
+	for {
+		var err error
+		select {
+		case rd, ok := <-reads:
+			if !ok {
+				return
+			}
+			if rd.err != nil {
+				err = l.writeStreamError(conn, r, CodeBadRequest, "invalid request", rd.err)
+				break
+			}
+			err = l.handleSubscription(ctx, conn, r, f, rd.req)
+			sub.WantTransactions(len(f.addresses) > 0 || len(f.jettonMasters) > 0)
+		case ev, ok := <-sub.C:
+			if !ok {
+				l.closeStream(conn, websocket.ClosePolicyViolation, "client is too slow, resume with since_lt")
+				return
+			}
+			if !f.match(ev) {
+				continue
+			}
+			err = l.writeEvent(conn, ev)
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(l.timeout))
+		}
+		if err != nil {
+			zerolog.Ctx(r.Context()).Debug().Err(err).Msg("websocket closed")
+			return
+		}
+	}
+}
+
+// readSubscriptions reads client messages until the connection fails or no
+// pong arrives within the pong timeout, then closes reads.
+func (l *LiteNode) readSubscriptions(ctx context.Context, conn *websocket.Conn, reads chan<- wsRead) {
+	defer close(reads)
+
+	conn.SetReadLimit(64 << 10)
+	conn.SetReadDeadline(time.Now().Add(l.stream.PongTimeout.Duration))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(l.stream.PongTimeout.Duration))
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var rd wsRead
+		rd.err = json.Unmarshal(data, &rd.req)
+		select {
+		case reads <- rd:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (l *LiteNode) handleSubscription(ctx context.Context, conn *websocket.Conn, r *http.Request, f *wsFilter, req SubscribeRequest) error {
+	var subscribe bool
+	switch req.Type {
+	case "subscribe":
+		subscribe = true
+	case "unsubscribe":
+	default:
+		return l.writeStreamError(conn, r, CodeBadRequest, "invalid request", fmt.Errorf("unknown type %q", req.Type))
+	}
+
+	addrs := make([]*address.Address, 0, len(req.Addresses))
+	for _, a := range req.Addresses {
+		addr, err := address.ParseAddr(a)
+		if err != nil {
+			return l.writeStreamError(conn, r, CodeBadAddress, "bad address", fmt.Errorf("%q: %w", a, err))
+		}
+		addrs = append(addrs, addr)
+	}
+	masters := make([]string, 0, len(req.JettonMasters))
+	for _, a := range req.JettonMasters {
+		addr, err := address.ParseAddr(a)
+		if err != nil {
+			return l.writeStreamError(conn, r, CodeBadAddress, "bad address", fmt.Errorf("%q: %w", a, err))
+		}
+		masters = append(masters, chain.RawAddr(addr))
+	}
+
+	if !subscribe {
+		for _, addr := range addrs {
+			delete(f.addresses, chain.RawAddr(addr))
+		}
+		for _, m := range masters {
+			delete(f.jettonMasters, m)
+		}
+		if req.Blocks {
+			f.blocks = false
+		}
+		return l.writeJSON(conn, StreamMessage{Type: "unsubscribed", Data: req})
+	}
+
+	for _, m := range masters {
+		f.jettonMasters[m] = struct{}{}
+	}
+	if req.Blocks {
+		f.blocks = true
+	}
+	for i, addr := range addrs {
+		key := chain.RawAddr(addr)
+		since, resume := req.SinceLT[req.Addresses[i]]
+		if !resume {
+			if _, ok := f.addresses[key]; !ok {
+				f.addresses[key] = 0
+			}
+			continue
+		}
+		f.addresses[key] = since
+		if err := l.resume(ctx, conn, r, f, addr, since); err != nil {
+			return err
+		}
+	}
+	return l.writeJSON(conn, StreamMessage{Type: "subscribed", Data: req})
+}
+
+// resume replays transactions of addr after since. Live events that were
+// queued meanwhile are skipped by the filter using the last replayed LT.
+func (l *LiteNode) resume(ctx context.Context, conn *websocket.Conn, r *http.Request, f *wsFilter, addr *address.Address, since uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	txs, err := l.ln.TransactionsSince(ctx, addr, since, l.stream.ResumeLimit)
+	if err != nil {
+		_, code, message := classifyChainError(err)
+		return l.writeStreamError(conn, r, code, message, err)
+	}
+	key := chain.RawAddr(addr)
+	for _, tx := range txs {
+		info, err := l.ln.DecodeTransaction(ctx, addr, tx)
+		if err != nil {
+			_, code, message := classifyChainError(err)
+			return l.writeStreamError(conn, r, code, message, err)
+		}
+		if err = l.writeJSON(conn, StreamMessage{Type: "transaction", Data: info}); err != nil {
+			return err
+		}
+		f.addresses[key] = tx.LT
+	}
+	return nil
+}
+
+func (l *LiteNode) writeEvent(conn *websocket.Conn, ev any) error {
+	switch e := ev.(type) {
+	case events.Transaction:
+		return l.writeJSON(conn, StreamMessage{Type: "transaction", Data: e.Tx})
+	case events.Block:
+		return l.writeJSON(conn, StreamMessage{Type: "block", Data: e})
+	}
+	return nil
+}
+
+// writeStreamError reports a failed client request without closing the
+// connection.
+func (l *LiteNode) writeStreamError(conn *websocket.Conn, r *http.Request, code, message string, err error) error {
+	body := &ErrorBody{
+		Code:      code,
+		Message:   message,
+		Details:   err.Error(),
+		RequestID: requestid.From(r.Context()),
+	}
+	zerolog.Ctx(r.Context()).Info().Str("code", code).Str("details", body.Details).Msg("websocket request failed")
+	return l.writeJSON(conn, StreamMessage{Type: "error", Error: body})
+}
+
+func (l *LiteNode) writeJSON(conn *websocket.Conn, v any) error {
+	conn.SetWriteDeadline(time.Now().Add(l.timeout))
+	return conn.WriteJSON(v)
+}
+
+func (l *LiteNode) closeStream(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(l.timeout))
+}
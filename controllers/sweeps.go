@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/FishDontExist/TONindexer/sweep"
+)
+
+type SweepList struct {
+	Sweeps []sweep.Sweep `json:"sweeps"`
+}
+
+// ListSweeps returns recorded sweeps, newest first, optionally filtered with
+// the status query parameter.
+func (l *LiteNode) ListSweeps(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	if l.sweeper == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "sweeping is disabled", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SweepList{Sweeps: l.sweeper.List(r.URL.Query().Get("status"))})
+}
+
+// RunSweep starts a sweep of all deposit wallets without waiting for the
+// next interval. It returns before the transfers are made.
+func (l *LiteNode) RunSweep(w http.ResponseWriter, r *http.Request) {
+	if !l.authorizeAdmin(w, r) {
+		return
+	}
+	if l.sweeper == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "sweeping is disabled", nil)
+		return
+	}
+	go l.sweeper.Sweep(context.Background())
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/FishDontExist/TONindexer/transfers"
+	"github.com/gorilla/mux"
+)
+
+type TransferList struct {
+	Transfers []transfers.Transfer `json:"transfers"`
+}
+
+// GetTransfer returns the status of a transfer by the hash of the wallet
+// transaction that sent it.
+func (l *LiteNode) GetTransfer(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	transfer, err := l.transfers.Get(mux.Vars(r)["id"])
+	if err != nil {
+		writeChainError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transfer)
+}
+
+// ListTransfers returns tracked transfers, newest first, optionally filtered
+// with the status query parameter.
+func (l *LiteNode) ListTransfers(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TransferList{Transfers: l.transfers.List(r.URL.Query().Get("status"))})
+}
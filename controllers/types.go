@@ -15,11 +15,27 @@ type Height struct {
 	Height string `json:"height"`
 }
 
+// Transaction is a TON transfer. Amount is a decimal string in Unit, ton
+// when empty or nanoton; it may be omitted with the carry_all_balance mode.
+// Body and StateInit are base64 BoCs, Mode send mode flags.
 type Transaction struct {
-	PrivateKey []string `json:"private_key"`
-	Sender     string   `json:"sender"`
-	Reciever   string   `json:"receiver"`
-	Amount     int      `json:"amount"`
+	WalletID         string   `json:"wallet_id"`
+	Sender           string   `json:"sender"`
+	Reciever         string   `json:"receiver"`
+	Amount           string   `json:"amount"`
+	Unit             string   `json:"unit,omitempty"`
+	Comment          string   `json:"comment,omitempty"`
+	EncryptedComment bool     `json:"encrypted_comment,omitempty"`
+	Body             string   `json:"body,omitempty"`
+	StateInit        string   `json:"state_init,omitempty"`
+	Mode             []string `json:"mode,omitempty"`
+	Bounce           *bool    `json:"bounce,omitempty"`
+}
+
+// Amount is a TON amount in both units, as exact decimal strings.
+type Amount struct {
+	Nanoton string `json:"nanoton"`
+	TON     string `json:"ton"`
 }
 
 type Balance struct {
@@ -34,8 +50,18 @@ type BlockExt struct {
 	SeqNo uint32 `json:"seqNo"`
 }
 
+// Jetton is a transfer of the configured jetton. Amount is a decimal string
+// in Unit, jetton when empty or base. Fee is the TON attached for the jetton
+// wallets, 0.05 when empty, and ForwardAmount the TON passed on to the
+// recipient with Comment or Body, a base64 BoC. Mode are send mode flags.
 type Jetton struct {
-	Reciever   string   `json:"reciever"`
-	PrivateKey []string `json:"private_key"`
-	Amount     string   `json:"amount"`
+	Reciever      string   `json:"reciever"`
+	WalletID      string   `json:"wallet_id"`
+	Amount        string   `json:"amount"`
+	Unit          string   `json:"unit,omitempty"`
+	Fee           string   `json:"fee,omitempty"`
+	ForwardAmount string   `json:"forward_amount,omitempty"`
+	Comment       string   `json:"comment,omitempty"`
+	Body          string   `json:"body,omitempty"`
+	Mode          []string `json:"mode,omitempty"`
 }
\ No newline at end of file
@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/keystore"
+	"github.com/FishDontExist/TONindexer/mnemonic"
+	"github.com/gorilla/mux"
+)
+
+type WalletList struct {
+	Wallets []keystore.Wallet `json:"wallets"`
+}
+
+type ExportedWallet struct {
+	keystore.Wallet
+	keystore.Secret
+}
+
+// WalletReq creates a wallet in the keystore. Without Mnemonic a new one is
+// generated, Password is then required to use it.
+type WalletReq struct {
+	Mnemonic []string `json:"mnemonic"`
+	Password string   `json:"password"`
+	Label    string   `json:"label"`
+}
+
+// DeriveReq asks for the address of the wallet of Version, v5r1 when empty.
+type DeriveReq struct {
+	Mnemonic  []string `json:"mnemonic"`
+	Password  string   `json:"password"`
+	Version   string   `json:"version"`
+	Subwallet *uint32  `json:"subwallet"`
+	TTL       uint32   `json:"ttl"`
+}
+
+type DeriveResp struct {
+	Address string `json:"address"`
+	Version string `json:"version"`
+}
+
+// Limits of the transactions returned by WalletTransactions.
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+type WalletHistory struct {
+	Transactions []*chain.TxInfo `json:"transactions"`
+}
+
+// signingKey decrypts a stored wallet and derives its key to sign a
+// transfer. It writes the error response and returns false when that's not
+// possible.
+func (l *LiteNode) signingKey(w http.ResponseWriter, r *http.Request, id string) (ed25519.PrivateKey, bool) {
+	if l.keys == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "keystore is disabled", nil)
+		return nil, false
+	}
+	if id == "" {
+		writeError(w, r, http.StatusBadRequest, CodeBadRequest, "wallet_id is required", nil)
+		return nil, false
+	}
+	secret, err := l.keys.Secret(id)
+	if err != nil {
+		writeChainError(w, r, err)
+		return nil, false
+	}
+	key, err := mnemonic.PrivateKey(secret.Mnemonic, secret.Password)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "stored wallet is invalid", err)
+		return nil, false
+	}
+	return key, true
+}
+
+// CreateWallet stores a wallet with a new or an imported mnemonic. Imported
+// mnemonics are validated before anything is stored.
+func (l *LiteNode) CreateWallet(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	if l.keys == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "keystore is disabled", nil)
+		return
+	}
+	var req WalletReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, r, err)
+		return
+	}
+
+	var addr string
+	if len(req.Mnemonic) == 0 {
+		wallet, err := l.ln.GenerateWallet(r.Context(), req.Password)
+		if err != nil {
+			writeChainError(w, r, err)
+			return
+		}
+		addr, req.Mnemonic = wallet.Address, wallet.Mnemonic
+	} else {
+		key, err := mnemonic.PrivateKey(req.Mnemonic, req.Password)
+		if err != nil {
+			writeBadRequest(w, r, err)
+			return
+		}
+		if addr, err = l.ln.WalletAddress(key); err != nil {
+			writeChainError(w, r, err)
+			return
+		}
+	}
+
+	stored, err := l.keys.Add(addr, req.Label, keystore.Secret{Mnemonic: req.Mnemonic, Password: req.Password})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "failed to store wallet", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(stored)
+}
+
+// DeriveAddress returns the wallet address of a mnemonic for any wallet
+// version. Nothing is stored and no liteserver is queried.
+func (l *LiteNode) DeriveAddress(w http.ResponseWriter, r *http.Request) {
+	var req DeriveReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, r, err)
+		return
+	}
+	if req.Version == "" {
+		req.Version = "v5r1"
+	}
+	addr, err := l.ln.DeriveAddress(req.Mnemonic, req.Password, mnemonic.Params{
+		Version:   req.Version,
+		Subwallet: req.Subwallet,
+		TTL:       req.TTL,
+	})
+	if err != nil {
+		writeChainError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeriveResp{Address: addr, Version: req.Version})
+}
+
+func (l *LiteNode) ListWallets(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	if l.keys == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "keystore is disabled", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WalletList{Wallets: l.keys.List()})
+}
+
+func (l *LiteNode) GetWallet(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	if l.keys == nil {
+		writeError(w, r, http.StatusServiceUnavailable, CodeUnavailable, "keystore is disabled", nil)
+		return
+	}
+	wallet, err := l.keys.Get(mux.Vars(r)["id"])
+	if err != nil {
+		writeChainError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wallet)
+}
+
+// WalletTransactions returns the last transactions of a stored wallet,
+// newest first, with encrypted comments decrypted. The limit query parameter
+// defaults to defaultHistoryLimit.
+func (l *LiteNode) WalletTransactions(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	limit := defaultHistoryLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxHistoryLimit {
+			writeError(w, r, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("limit must be 1 to %d", maxHistoryLimit), err)
+			return
+		}
+		limit = n
+	}
+	key, ok := l.signingKey(w, r, id)
+	if !ok {
+		return
+	}
+	stored, err := l.keys.Get(id)
+	if err != nil {
+		writeChainError(w, r, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), l.timeout)
+	defer cancel()
+	txs, err := l.ln.WalletTransactions(ctx, stored.Address, key, limit)
+	if err != nil {
+		writeChainError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WalletHistory{Transactions: txs})
+}
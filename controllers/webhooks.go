@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/FishDontExist/TONindexer/webhooks"
+	"github.com/gorilla/mux"
+)
+
+type WatchReq struct {
+	Address     string `json:"address"`
+	CallbackURL string `json:"callback_url"`
+	Label       string `json:"label"`
+}
+
+type WatchList struct {
+	Watches []webhooks.Watch `json:"watches"`
+}
+
+type ReplayReq struct {
+	// IDs of dead letters to replay, all of them when empty.
+	IDs []string `json:"ids"`
+}
+
+type ReplayResp struct {
+	Replayed []string `json:"replayed"`
+}
+
+type DeadLetters struct {
+	Deliveries []webhooks.Delivery `json:"deliveries"`
+}
+
+func (l *LiteNode) AddWatch(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	var req WatchReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, r, err)
+		return
+	}
+	watch, err := l.hooks.Watch(req.Address, req.CallbackURL, req.Label)
+	if err != nil {
+		writeWatchError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(watch)
+}
+
+func (l *LiteNode) ListWatches(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WatchList{Watches: l.hooks.Watches()})
+}
+
+func (l *LiteNode) RemoveWatch(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	if err := l.hooks.Unwatch(mux.Vars(r)["address"]); err != nil {
+		writeWatchError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (l *LiteNode) DeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeadLetters{Deliveries: l.hooks.DeadLetters()})
+}
+
+func (l *LiteNode) ReplayWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !l.authorize(w, r) {
+		return
+	}
+	var req ReplayReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeBadRequest(w, r, err)
+		return
+	}
+	ids, err := l.hooks.Replay(req.IDs)
+	if err != nil {
+		writeChainError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReplayResp{Replayed: ids})
+}
+
+// writeWatchError writes 409 for watches of deposit addresses, otherwise it
+// maps err as a chain error.
+func writeWatchError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, webhooks.ErrManaged) {
+		writeError(w, r, http.StatusConflict, CodeConflict, "deposit address watches can't be changed", err)
+		return
+	}
+	writeChainError(w, r, err)
+}
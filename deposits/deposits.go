@@ -0,0 +1,231 @@
+// Package deposits derives a deposit wallet for every (user, index) from one
+// master secret kept in the keystore, and registers the derived addresses
+// so the scanner watches them.
+//
+// A deposit key is the ed25519 key seeded with HMAC-SHA512 of the master
+// key over the index and user id, so any number of wallets of the version
+// used for sending is recovered from the master mnemonic alone.
+//
+// Errors wrap the chain package sentinels, so handlers map them to statuses
+// the same way as liteserver errors.
+package deposits
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/keystore"
+	"github.com/FishDontExist/TONindexer/mnemonic"
+	"github.com/FishDontExist/TONindexer/store"
+	"github.com/FishDontExist/TONindexer/webhooks"
+	"github.com/rs/zerolog"
+	"github.com/xssnick/tonutils-go/address"
+)
+
+// derivationDomain separates deposit keys from other uses of the master key.
+const derivationDomain = "tonindexer deposit"
+
+// maxUserID bounds user ids, they are part of webhook labels.
+const maxUserID = 128
+
+type Address struct {
+	UserID    string    `json:"user_id"`
+	Index     uint32    `json:"index"`
+	Address   string    `json:"address"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Label is the watch label of a deposit address, it is sent with every
+// deposit webhook for it.
+func (a Address) Label() string {
+	return fmt.Sprintf("%s/%d", a.UserID, a.Index)
+}
+
+// state is everything persisted between restarts.
+type state struct {
+	// Master is the keystore id of the wallet deposit keys derive from.
+	Master string `json:"master"`
+	// Addresses are keyed by index and user id, see key.
+	Addresses map[string]*Address `json:"addresses"`
+}
+
+// liteClient is what of the liteserver client the registry needs.
+type liteClient interface {
+	GenerateWallet(ctx context.Context, password string) (chain.Wallet, error)
+	WalletAddress(key ed25519.PrivateKey) (string, error)
+}
+
+// watcher is what of the webhooks service the registry needs.
+type watcher interface {
+	WatchedDeposit(raw string) bool
+	WatchDeposit(addr, label string) (*webhooks.Watch, error)
+}
+
+type Registry struct {
+	ln     liteClient
+	hooks  watcher
+	file   *store.File
+	log    zerolog.Logger
+	master ed25519.PrivateKey
+
+	mx sync.Mutex
+	st state
+}
+
+// Open loads the registry. On first use the master wallet is the configured
+// keystore wallet, or a new one generated into the keystore.
+func Open(cfg *config.Config, keys *keystore.Store, ln *chain.LiteClient, hooks *webhooks.Service, lg zerolog.Logger) (*Registry, error) {
+	return open(cfg, keys, ln, hooks, lg)
+}
+
+func open(cfg *config.Config, keys *keystore.Store, ln liteClient, hooks watcher, lg zerolog.Logger) (*Registry, error) {
+	file, err := store.Open(cfg.Storage.Dir, "deposits.json")
+	if err != nil {
+		return nil, err
+	}
+	r := &Registry{
+		ln:    ln,
+		hooks: hooks,
+		file:  file,
+		log:   lg.With().Str("component", "deposits").Logger(),
+		st:    state{Addresses: map[string]*Address{}},
+	}
+	if err = file.Load(&r.st); err != nil {
+		return nil, fmt.Errorf("load deposits: %w", err)
+	}
+
+	switch configured := cfg.Deposits.MasterWallet; {
+	case r.st.Master != "" && configured != "" && configured != r.st.Master && len(r.st.Addresses) > 0:
+		return nil, fmt.Errorf("deposits.master_wallet %s differs from %s the registered addresses derive from", configured, r.st.Master)
+	case configured != "":
+		r.st.Master = configured
+	case r.st.Master == "":
+		w, err := ln.GenerateWallet(context.Background(), "")
+		if err != nil {
+			return nil, err
+		}
+		stored, err := keys.Add(w.Address, "deposit master", keystore.Secret{Mnemonic: w.Mnemonic})
+		if err != nil {
+			return nil, err
+		}
+		r.st.Master = stored.ID
+		r.log.Info().Str("wallet", stored.ID).Msg("generated deposit master wallet")
+	}
+
+	secret, err := keys.Secret(r.st.Master)
+	if err != nil {
+		return nil, fmt.Errorf("deposit master wallet: %w", err)
+	}
+	if r.master, err = mnemonic.PrivateKey(secret.Mnemonic, secret.Password); err != nil {
+		return nil, fmt.Errorf("deposit master wallet: %w", err)
+	}
+	if err = r.file.Save(&r.st); err != nil {
+		return nil, err
+	}
+	r.watchAll()
+	return r, nil
+}
+
+// watchAll adds registered addresses missing from the watchlist, e.g. ones
+// registered while webhooks weren't configured.
+func (r *Registry) watchAll() {
+	r.mx.Lock()
+	list := make([]Address, 0, len(r.st.Addresses))
+	for _, a := range r.st.Addresses {
+		list = append(list, *a)
+	}
+	r.mx.Unlock()
+	for _, a := range list {
+		r.watch(a)
+	}
+}
+
+func (r *Registry) watch(a Address) bool {
+	if addr, err := address.ParseAddr(a.Address); err == nil && r.hooks.WatchedDeposit(chain.RawAddr(addr)) {
+		return true
+	}
+	if _, err := r.hooks.WatchDeposit(a.Address, a.Label()); err != nil {
+		r.log.Warn().Err(err).Str("address", a.Address).Str("label", a.Label()).Msg("watch deposit address")
+		return false
+	}
+	return true
+}
+
+func key(userID string, index uint32) string {
+	return fmt.Sprintf("%d:%s", index, userID)
+}
+
+// Key derives the private key of the deposit wallet of userID and index.
+func (r *Registry) Key(userID string, index uint32) ed25519.PrivateKey {
+	mac := hmac.New(sha512.New, r.master.Seed())
+	mac.Write([]byte(derivationDomain))
+	mac.Write(binary.BigEndian.AppendUint32(nil, index))
+	mac.Write([]byte(userID))
+	return ed25519.NewKeyFromSeed(mac.Sum(nil)[:ed25519.SeedSize])
+}
+
+// Address returns the deposit address of userID and index, registering and
+// watching it the first time. It reports whether the address is watched.
+func (r *Registry) Address(userID string, index uint32) (Address, bool, error) {
+	if userID == "" || len(userID) > maxUserID {
+		return Address{}, false, fmt.Errorf("%w: user_id must be 1 to %d bytes", chain.ErrInvalidInput, maxUserID)
+	}
+
+	r.mx.Lock()
+	a, ok := r.st.Addresses[key(userID, index)]
+	r.mx.Unlock()
+	if ok {
+		return *a, r.watch(*a), nil
+	}
+
+	addr, err := r.ln.WalletAddress(r.Key(userID, index))
+	if err != nil {
+		return Address{}, false, err
+	}
+	a = &Address{
+		UserID:    userID,
+		Index:     index,
+		Address:   addr,
+		CreatedAt: time.Now().UTC(),
+	}
+	r.mx.Lock()
+	if prev, ok := r.st.Addresses[key(userID, index)]; ok {
+		a = prev
+	} else {
+		r.st.Addresses[key(userID, index)] = a
+		if err = r.file.Save(&r.st); err != nil {
+			r.log.Error().Err(err).Msg("save deposits")
+		}
+	}
+	r.mx.Unlock()
+	return *a, r.watch(*a), nil
+}
+
+// List returns the registered addresses of userID, or of everyone when it
+// is empty, by user and index.
+func (r *Registry) List(userID string) []Address {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	res := []Address{}
+	for _, a := range r.st.Addresses {
+		if userID == "" || a.UserID == userID {
+			res = append(res, *a)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].UserID != res[j].UserID {
+			return res[i].UserID < res[j].UserID
+		}
+		return res[i].Index < res[j].Index
+	})
+	return res
+}
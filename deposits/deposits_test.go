@@ -0,0 +1,179 @@
+package deposits
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/keystore"
+	"github.com/FishDontExist/TONindexer/mnemonic"
+	"github.com/FishDontExist/TONindexer/webhooks"
+	"github.com/rs/zerolog"
+	"github.com/xssnick/tonutils-go/address"
+)
+
+// fakeLite derives addresses from the public key alone.
+type fakeLite struct {
+	generated int
+}
+
+func (f *fakeLite) GenerateWallet(context.Context, string) (chain.Wallet, error) {
+	f.generated++
+	words := mnemonic.New("")
+	key, err := mnemonic.PrivateKey(words, "")
+	if err != nil {
+		return chain.Wallet{}, err
+	}
+	addr, _ := f.WalletAddress(key)
+	return chain.Wallet{Address: addr, Mnemonic: words}, nil
+}
+
+func (f *fakeLite) WalletAddress(key ed25519.PrivateKey) (string, error) {
+	return address.NewAddress(0, 0, key.Public().(ed25519.PublicKey)).String(), nil
+}
+
+// fakeHooks watches addresses by raw address, failing while err is set.
+type fakeHooks struct {
+	err     error
+	watched map[string]string
+}
+
+func (f *fakeHooks) WatchedDeposit(raw string) bool {
+	_, ok := f.watched[raw]
+	return ok
+}
+
+func (f *fakeHooks) WatchDeposit(addr, label string) (*webhooks.Watch, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.watched[chain.RawAddr(address.MustParseAddr(addr))] = label
+	return &webhooks.Watch{Address: addr, Label: label, Deposit: true}, nil
+}
+
+type env struct {
+	cfg   *config.Config
+	keys  *keystore.Store
+	ln    *fakeLite
+	hooks *fakeHooks
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	dir := t.TempDir()
+	keys, err := keystore.Open(dir, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{}
+	cfg.Storage.Dir = dir
+	return &env{cfg: cfg, keys: keys, ln: &fakeLite{}, hooks: &fakeHooks{watched: map[string]string{}}}
+}
+
+func (e *env) open(t *testing.T) *Registry {
+	t.Helper()
+	r, err := open(e.cfg, e.keys, e.ln, e.hooks, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	return r
+}
+
+func TestRegistryRestart(t *testing.T) {
+	e := newEnv(t)
+	r := e.open(t)
+	if e.ln.generated != 1 || len(e.keys.List()) != 1 {
+		t.Fatalf("open() generated %d wallets, keystore has %d, want 1", e.ln.generated, len(e.keys.List()))
+	}
+	a, watched, err := r.Address("user", 3)
+	if err != nil || !watched {
+		t.Fatalf("Address() = %+v, %v, %v", a, watched, err)
+	}
+	if e.hooks.watched[chain.RawAddr(address.MustParseAddr(a.Address))] != "user/3" {
+		t.Errorf("watches = %v, want %s labelled user/3", e.hooks.watched, a.Address)
+	}
+
+	// the master is kept, so the same wallets derive again
+	r = e.open(t)
+	if e.ln.generated != 1 {
+		t.Errorf("open() after restart generated another master wallet")
+	}
+	got, _, err := r.Address("user", 3)
+	if err != nil || got != a {
+		t.Errorf("Address() after restart = %+v, %v, want %+v", got, err, a)
+	}
+	if addr, _ := e.ln.WalletAddress(r.Key("user", 3)); addr != a.Address {
+		t.Errorf("Key() derives %s, want %s", addr, a.Address)
+	}
+}
+
+func TestKey(t *testing.T) {
+	r := newEnv(t).open(t)
+	keys := [][]byte{r.Key("user", 0), r.Key("user", 1), r.Key("other", 0), r.Key("user1", 0)}
+	for i := range keys {
+		for j := i + 1; j < len(keys); j++ {
+			if bytes.Equal(keys[i], keys[j]) {
+				t.Errorf("keys %d and %d are equal", i, j)
+			}
+		}
+	}
+	if !bytes.Equal(r.Key("user", 1), keys[1]) {
+		t.Error("Key() isn't deterministic")
+	}
+}
+
+func TestAddress(t *testing.T) {
+	e := newEnv(t)
+	r := e.open(t)
+	for _, id := range []string{"", string(make([]byte, maxUserID+1))} {
+		if _, _, err := r.Address(id, 0); !errors.Is(err, chain.ErrInvalidInput) {
+			t.Errorf("Address(%d byte user id) error = %v, want ErrInvalidInput", len(id), err)
+		}
+	}
+
+	// an address that couldn't be watched is registered, and watched on
+	// the next request
+	e.hooks.err = errors.New("webhook.secret is not configured")
+	a, watched, err := r.Address("b", 1)
+	if err != nil || watched {
+		t.Fatalf("Address() = %+v, %v, %v, want an unwatched address", a, watched, err)
+	}
+	e.hooks.err = nil
+	if _, watched, _ = r.Address("b", 1); !watched {
+		t.Error("Address() again didn't watch the address")
+	}
+
+	r.Address("b", 0)
+	r.Address("a", 2)
+	var got []string
+	for _, a := range r.List("") {
+		got = append(got, a.Label())
+	}
+	if want := []string{"a/2", "b/0", "b/1"}; !slices.Equal(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+	if got := r.List("a"); len(got) != 1 || got[0].Label() != "a/2" {
+		t.Errorf("List(a) = %+v, want a/2", got)
+	}
+}
+
+func TestMasterWalletChange(t *testing.T) {
+	e := newEnv(t)
+	r := e.open(t)
+	if _, _, err := r.Address("user", 0); err != nil {
+		t.Fatal(err)
+	}
+	other, err := e.keys.Add("EQother", "", keystore.Secret{Mnemonic: mnemonic.New("")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.cfg.Deposits.MasterWallet = other.ID
+	if _, err = open(e.cfg, e.keys, e.ln, e.hooks, zerolog.Nop()); err == nil {
+		t.Error("open() with another master wallet than the addresses derive from succeeded")
+	}
+}
@@ -1,82 +1,70 @@
-package ton_service
+package dumps
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 
-	"log"
-	"os"
+	"github.com/rs/zerolog"
 
+	"github.com/FishDontExist/TONindexer/config"
 	"github.com/xssnick/tonutils-go/liteclient"
 	"github.com/xssnick/tonutils-go/ton"
 )
 
 type SimpleBlockInfo struct {
-	Workchain int32 
-	Shard     int64 
+	Workchain int32
+	Shard     int64
 	SeqNo     uint32
 }
-func ConnectToLiteNode() (*ton.APIClient, error) {
-    client := liteclient.NewConnectionPool()
 
-    content, err := os.ReadFile("./global-config.json")
-    if err != nil {
-        log.Fatal("Error when opening file: ", err)
-		return nil, err
-    }
+func ConnectToLiteNode(ctx context.Context, net *config.NetworkConfig) (*ton.APIClient, error) {
+	client := liteclient.NewConnectionPool()
 
-    config := liteclient.GlobalConfig{}
-    err = json.Unmarshal(content, &config)
-    if err != nil {
-        log.Fatal("Error during Unmarshal(): ", err)
-		return nil, err
-    }
+	cfg, err := config.GetConfig(ctx, net)
+	if err != nil {
+		return nil, fmt.Errorf("load network config: %w", err)
+	}
 
-    err = client.AddConnectionsFromConfig(context.Background(), &config)
-    if err != nil {
-        log.Fatalln("connection err: ", err.Error())
-        return nil, err
-    }
-    // initialize ton API lite connection
-    api := ton.NewAPIClient(client)
-    api = api.WithRetry().(*ton.APIClient)
+	err = client.AddConnectionsFromConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("connect to liteservers: %w", err)
+	}
+	// initialize ton API lite connection
+	api := ton.NewAPIClient(client)
+	api = api.WithRetry().(*ton.APIClient)
 	return api, nil
 }
 
-func getMasterchainInfo(api *ton.APIClient) (*ton.BlockIDExt, error) {
-	blockInfo, err := api.GetMasterchainInfo(context.Background())
+func getMasterchainInfo(ctx context.Context, api *ton.APIClient) (*ton.BlockIDExt, error) {
+	blockInfo, err := api.GetMasterchainInfo(ctx)
 	if err != nil {
-		log.Fatal(err)
-		return nil, err
+		return nil, fmt.Errorf("get masterchain info: %w", err)
 	}
 	return blockInfo, nil
 }
 
-func GetLatestBlockInfo(api *ton.APIClient) {
-    masterchainInfo, err := getMasterchainInfo(api)
+func GetLatestBlockInfo(ctx context.Context, api *ton.APIClient, lg zerolog.Logger) error {
+	masterchainInfo, err := getMasterchainInfo(ctx, api)
+	if err != nil {
+		return err
+	}
+	// Lookup the latest block
+	blockInfo, err := api.LookupBlock(ctx, masterchainInfo.Workchain, masterchainInfo.Shard, masterchainInfo.SeqNo)
 	if err != nil {
-		log.Fatal("BlockIDExt erro: ", err)
+		return fmt.Errorf("look up latest block: %w", err)
 	}
-    // Lookup the latest block
-    blockInfo, err := api.LookupBlock(context.Background(), masterchainInfo.Workchain, masterchainInfo.Shard, masterchainInfo.SeqNo)
-    if err != nil {
-        log.Fatal("Something wrong in looking up the latest block: ", err)
-        return
-    }
 
-    // Get transactions in the latest block
-    transactions, getBlockBool, err := api.GetBlockTransactionsV2(context.Background(), blockInfo, 100)
-    if err != nil {
-        log.Fatal("Error getting block transactions: ", err)
-        return
-    }
-	log.Println("getBlockBool: ", getBlockBool)
-	
+	// Get transactions in the latest block
+	transactions, getBlockBool, err := api.GetBlockTransactionsV2(ctx, blockInfo, 100)
+	if err != nil {
+		return fmt.Errorf("get block transactions: %w", err)
+	}
+	lg.Info().Bool("more", getBlockBool).Msg("block transactions")
 
-    // Print the transactions
-    log.Println("Transactions in the latest block:")
-    for _, tx := range transactions {
-        id3 := tx.ID3()
-        log.Printf("Transaction ID3: %+v\n", id3)
-    }
-}
\ No newline at end of file
+	// Print the transactions
+	for _, tx := range transactions {
+		id3 := tx.ID3()
+		lg.Info().Interface("id", id3).Msg("transaction in latest block")
+	}
+	return nil
+}
@@ -8,6 +8,9 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/metrics"
+	"github.com/FishDontExist/TONindexer/traverse"
 	"github.com/rs/zerolog"
 	"github.com/xssnick/ton-payment-network/pkg/payments"
 	"github.com/xssnick/ton-payment-network/tonpayments"
@@ -22,12 +25,14 @@ type Scanner struct {
 	codeHash  []byte
 	lastBlock uint32
 
+	walker         *traverse.Walker
 	taskPool       chan accFetchTask
 	shardLastSeqno map[string]uint32
 
 	globalCtx context.Context
 	stopper   func()
 
+	cfg config.ScannerConfig
 	log zerolog.Logger
 
 	mx sync.RWMutex
@@ -38,27 +43,43 @@ type Contract struct {
 	CodeHash []byte
 }
 
-func NewScanner(api ton.APIClientWrapped, codeHash []byte, lastBlock uint32, lg zerolog.Logger) *Scanner {
+func NewScanner(api ton.APIClientWrapped, codeHash []byte, lastBlock uint32, cfg *config.Config, lg zerolog.Logger) *Scanner {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Scanner{
 		api:            api,
+		cfg:            cfg.Scanner,
 		log:            lg,
 		client:         payments.NewPaymentChannelClient(api),
 		codeHash:       codeHash,
 		lastBlock:      lastBlock,
-		taskPool:       make(chan accFetchTask, 1000),
+		walker:         traverse.New(api),
+		taskPool:       make(chan accFetchTask, cfg.Scanner.TaskPoolSize),
 		shardLastSeqno: map[string]uint32{},
 		globalCtx:      ctx,
 		stopper:        cancel,
 	}
 }
 
+// GetLag returns how many masterchain blocks the chain is ahead of the last
+// block the scanner has processed.
 func (v *Scanner) GetLag(ctx context.Context) (uint64, error) {
 	master, err := v.api.GetMasterchainInfo(ctx)
 	if err != nil {
 		return 0, fmt.Errorf("get masterchain info err: %w", err)
 	}
-	return uint64(master.SeqNo - v.lastBlock), nil
+	v.mx.RLock()
+	last := v.lastBlock
+	v.mx.RUnlock()
+	if master.SeqNo < last {
+		return 0, nil
+	}
+	return uint64(master.SeqNo - last), nil
+}
+
+func (v *Scanner) setLastBlock(seqno uint32) {
+	v.mx.Lock()
+	v.lastBlock = seqno
+	v.mx.Unlock()
 }
 
 func (v *Scanner) Stop() {
@@ -72,15 +93,17 @@ func (v *Scanner) Start(ctx context.Context, ch chan<- any) error {
 		return fmt.Errorf("get masterchain info err: %w", err)
 	}
 
-	go v.accFetcherWorker(ch, 60)
-
+	if v.lastBlock > 0 && master.SeqNo > v.lastBlock && master.SeqNo-v.lastBlock > v.cfg.MaxResumeLag {
+		v.log.Warn().Uint32("from", v.lastBlock).Uint32("to", master.SeqNo-1).
+			Msg("too far behind to resume, skipped blocks are left as a gap")
+		v.setLastBlock(0)
+	}
 	if v.lastBlock > 0 {
 		master, err = v.api.LookupBlock(ctx, master.Workchain, master.Shard, v.lastBlock)
 		if err != nil {
 			return fmt.Errorf("lookup block err: %w", err)
 		}
 	}
-	v.lastBlock = master.SeqNo
 
 	firstShards, err := v.api.GetBlockShardsInfo(ctx, master)
 	if err != nil {
@@ -90,6 +113,11 @@ func (v *Scanner) Start(ctx context.Context, ch chan<- any) error {
 		v.shardLastSeqno[getShardID(shard)] = shard.SeqNo
 	}
 
+	// started only once Start can't fail anymore, so retrying it doesn't
+	// leave extra workers behind
+	go v.accFetcherWorker(ch, v.cfg.Workers)
+
+	v.setLastBlock(master.SeqNo - 1)
 	masters := []*ton.BlockIDExt{master}
 	go func() {
 		outOfSync := false
@@ -97,27 +125,31 @@ func (v *Scanner) Start(ctx context.Context, ch chan<- any) error {
 			start := time.Now()
 
 			var transactionsNum, shardBlocksNum uint64
+			blocks := make([]BlockEvent, len(masters))
 			wg := sync.WaitGroup{}
 			wg.Add(len(masters))
-			for _, m := range masters {
-				go func(m *ton.BlockIDExt) {
-					txNum, bNum := v.fetchBlock(context.Background(), m)
-					atomic.AddUint64(&transactionsNum, txNum)
-					atomic.AddUint64(&shardBlocksNum, bNum)
+			for i, m := range masters {
+				go func(i int, m *ton.BlockIDExt) {
+					blocks[i] = v.fetchBlock(context.Background(), m, ch)
+					atomic.AddUint64(&transactionsNum, blocks[i].Transactions)
+					atomic.AddUint64(&shardBlocksNum, uint64(len(blocks[i].Shards)))
 					wg.Done()
-				}(m)
+				}(i, m)
 			}
 
 			wg.Wait()
 			took := time.Since(start)
+			metrics.MasterBlocks.Add(float64(len(masters)))
 
-			for _, m := range masters {
+			for i, m := range masters {
+				ch <- blocks[i]
 				ch <- tonpayments.BlockCheckedEvent{
 					Seqno: m.SeqNo,
 				}
 			}
 
 			lastProcessed := masters[len(masters)-1]
+			v.setLastBlock(lastProcessed.SeqNo)
 			blocksNum := len(masters)
 			masters = masters[:0]
 
@@ -135,7 +167,8 @@ func (v *Scanner) Start(ctx context.Context, ch chan<- any) error {
 				}
 
 				diff := lastMaster.SeqNo - lastProcessed.SeqNo
-				if diff > 60 {
+				metrics.MasterLag.Set(float64(diff))
+				if diff > v.cfg.OutOfSyncLag {
 					rd := took.Round(time.Millisecond)
 					if shardBlocksNum > 0 {
 						rd /= time.Duration(shardBlocksNum)
@@ -155,8 +188,8 @@ func (v *Scanner) Start(ctx context.Context, ch chan<- any) error {
 
 				v.log.Debug().Uint32("lag_master_blocks", diff).Uint64("processed_transactions", transactionsNum).Msg("scanner delay")
 
-				if diff > 100 {
-					diff = 100
+				if diff > v.cfg.MaxBatch {
+					diff = v.cfg.MaxBatch
 				}
 
 				for i := lastProcessed.SeqNo + 1; i <= lastProcessed.SeqNo+diff; i++ {
@@ -171,7 +204,6 @@ func (v *Scanner) Start(ctx context.Context, ch chan<- any) error {
 						break
 					}
 				}
-				v.lastBlock = masters[len(masters)-1].SeqNo
 				break
 			}
 		}
@@ -188,101 +220,109 @@ type accFetchTask struct {
 }
 
 func (v *Scanner) accFetcherWorker(ch chan<- any, threads int) {
+	metrics.Workers.Add(float64(threads))
 	for y := 0; y < threads; y++ {
 		go func() {
 			for {
 				task := <-v.taskPool
-
-				func() {
-					defer task.callback()
-
-					var acc *tlb.Account
-					{
-						ctx := context.Background()
-						for i := 0; i < 20; i++ { // TODO: retry without loosing
-							var err error
-							ctx, err = v.api.Client().StickyContextNextNode(ctx)
-							if err != nil {
-								v.log.Debug().Err(err).Str("addr", task.addr.String()).Msg("failed to pick next node")
-								break
-							}
-
-							qCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
-							acc, err = v.api.WaitForBlock(task.master.SeqNo).GetAccount(qCtx, task.master, task.addr)
-							cancel()
-							if err != nil {
-								v.log.Debug().Err(err).Str("addr", task.addr.String()).Msg("failed to get account")
-								time.Sleep(100 * time.Millisecond)
-								continue
-							}
-							break
-						}
-					}
-
-					if acc == nil || !acc.IsActive || acc.State.Status != tlb.AccountStatusActive {
-						// not active or failed
-						return
-					}
-
-					p, err := v.client.ParseAsyncChannel(task.addr, acc.Code, acc.Data, true)
-					if err != nil {
-						if !errors.Is(err, payments.ErrVerificationNotPassed) {
-							v.log.Warn().Err(err).Str("addr", task.addr.String()).Msg("failed to parse payment channel")
-						}
-						return
+				metrics.TaskPoolDepth.Set(float64(len(v.taskPool)))
+				metrics.WorkersBusy.Inc()
+
+				if err := v.checkAccount(context.Background(), task.master, task.addr, task.tx, ch); err != nil {
+					v.log.Warn().Err(err).Str("addr", task.addr.String()).Msg("failed to get account, queued for retry")
+					ch <- AccountFailedEvent{
+						Master: task.master,
+						Shard:  task.shard,
+						Addr:   task.addr,
+						Tx:     task.tx,
+						Err:    err,
 					}
-
-					ch <- tonpayments.ChannelUpdatedEvent{
-						Transaction: task.tx,
-						Channel:     p,
-					}
-				}()
+				}
+				metrics.WorkersBusy.Dec()
+				task.callback()
 			}
 		}()
 	}
 }
 
-// func to get storage map key
-func getShardID(shard *ton.BlockIDExt) string {
-	return fmt.Sprintf("%d|%d", shard.Workchain, shard.Shard)
-}
+// checkAccount loads addr at master and sends a ChannelUpdatedEvent when it
+// is a payment channel. An error means the account couldn't be fetched.
+func (v *Scanner) checkAccount(ctx context.Context, master *ton.BlockIDExt, addr *address.Address, tx *tlb.Transaction, ch chan<- any) error {
+	var acc *tlb.Account
+	var err error
+	for i := 0; i < v.cfg.AccountRetries; i++ {
+		ctx, err = v.api.Client().StickyContextNextNode(ctx)
+		if err != nil {
+			v.log.Debug().Err(err).Str("addr", addr.String()).Msg("failed to pick next node")
+			break
+		}
+
+		qCtx, cancel := context.WithTimeout(ctx, v.cfg.AccountTimeout.Duration)
+		acc, err = v.api.WaitForBlock(master.SeqNo).GetAccount(qCtx, master, addr)
+		cancel()
+		if err != nil {
+			v.log.Debug().Err(err).Str("addr", addr.String()).Msg("failed to get account")
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		break
+	}
+	if acc == nil {
+		return fmt.Errorf("get account %s at %d: %w", addr.String(), master.SeqNo, err)
+	}
 
-func (v *Scanner) getNotSeenShards(ctx context.Context, api ton.APIClientWrapped, shard *ton.BlockIDExt, prevShards []*ton.BlockIDExt) (ret []*ton.BlockIDExt, lastTime time.Time, err error) {
-	if shard.Workchain != 0 {
-		// skip non basechain
-		return nil, time.Time{}, nil
+	if !acc.IsActive || acc.State.Status != tlb.AccountStatusActive {
+		return nil
 	}
 
-	for _, prevShard := range prevShards {
-		if shard.Equals(prevShard) {
-			return nil, time.Time{}, nil
+	p, err := v.client.ParseAsyncChannel(addr, acc.Code, acc.Data, true)
+	if err != nil {
+		if !errors.Is(err, payments.ErrVerificationNotPassed) {
+			v.log.Warn().Err(err).Str("addr", addr.String()).Msg("failed to parse payment channel")
 		}
+		return nil
 	}
 
-	b, err := api.GetBlockData(ctx, shard)
-	if err != nil {
-		return nil, time.Time{}, fmt.Errorf("get block data: %w", err)
+	ch <- tonpayments.ChannelUpdatedEvent{
+		Transaction: tx,
+		Channel:     p,
 	}
+	return nil
+}
 
-	parents, err := b.BlockInfo.GetParentBlocks()
+// ScanAccount checks addr again after its transaction lt in shard, as the
+// scanner does for the first transaction of each account in a block.
+func (v *Scanner) ScanAccount(ctx context.Context, master, shard *ton.BlockIDExt, addr *address.Address, lt uint64, ch chan<- any) error {
+	qCtx, cancel := context.WithTimeout(ctx, v.cfg.AccountTimeout.Duration)
+	tx, err := v.api.WaitForBlock(master.SeqNo).GetTransaction(qCtx, shard, addr, lt)
+	cancel()
 	if err != nil {
-		return nil, time.Time{}, fmt.Errorf("get parent blocks (%d:%x:%d): %w", shard.Workchain, uint64(shard.Shard), shard.Shard, err)
+		return fmt.Errorf("get transaction %d of %s: %w", lt, addr.String(), err)
 	}
+	return v.checkAccount(ctx, master, addr, tx, ch)
+}
 
-	genTime := time.Unix(int64(b.BlockInfo.GenUtime), 0)
+// func to get storage map key
+func getShardID(shard *ton.BlockIDExt) string {
+	return fmt.Sprintf("%d|%d", shard.Workchain, shard.Shard)
+}
 
-	for _, parent := range parents {
-		ext, _, err := v.getNotSeenShards(ctx, api, parent, prevShards)
-		if err != nil {
-			return nil, time.Time{}, err
+// basechain skips shards of other workchains.
+func basechain(shards []*ton.BlockIDExt) []*ton.BlockIDExt {
+	var res []*ton.BlockIDExt
+	for _, shard := range shards {
+		if shard.Workchain == 0 {
+			res = append(res, shard)
 		}
-		ret = append(ret, ext...)
 	}
-	return append(ret, shard), genTime, nil
+	return res
 }
 
-func (v *Scanner) fetchBlock(ctx context.Context, master *ton.BlockIDExt) (transactionsNum, shardBlocksNum uint64) {
+// fetchBlock processes the shard blocks first seen in master, sending a
+// TransactionEvent to ch for each of their transactions.
+func (v *Scanner) fetchBlock(ctx context.Context, master *ton.BlockIDExt, ch chan<- any) (ev BlockEvent) {
 	v.log.Debug().Uint32("seqno", master.SeqNo).Msg("scanning master")
+	ev.Master = master
 
 	tm := time.Now()
 	for {
@@ -319,135 +359,168 @@ func (v *Scanner) fetchBlock(ctx context.Context, master *ton.BlockIDExt) (trans
 		}
 		v.log.Debug().Uint32("seqno", master.SeqNo).Dur("took", time.Since(tm)).Msg("shards fetched")
 
+		masterBlock, err := v.api.GetBlockData(ctx, master)
+		if err != nil {
+			v.log.Debug().Err(err).Uint32("master", master.SeqNo).Msg("failed to get master block")
+			time.Sleep(300 * time.Millisecond)
+			continue
+		}
+		ev.GenUtime = masterBlock.BlockInfo.GenUtime
+
 		// shards in master block may have holes, e.g. shard seqno 2756461, then 2756463, and no 2756462 in master chain
 		// thus we need to scan a bit back in case of discovering a hole, till last seen, to fill the misses.
-		var newShards []*ton.BlockIDExt
-		for _, shard := range currentShards {
-			for {
-				select {
-				case <-v.globalCtx.Done():
-					v.log.Warn().Uint32("master", master.SeqNo).Msg("scanner stopped")
-					return
-				case <-ctx.Done():
-					v.log.Warn().Uint32("master", master.SeqNo).Msg("ctx done")
-					return
-				default:
-				}
-
-				notSeen, _, err := v.getNotSeenShards(ctx, v.api, shard, prevShards)
-				if err != nil {
-					v.log.Debug().Err(err).Uint32("master", master.SeqNo).Msg("failed to get not seen shards on block")
-					time.Sleep(300 * time.Millisecond)
-					continue
-				}
-
-				newShards = append(newShards, notSeen...)
-				break
-			}
+		newShards, err := v.walker.NewShardBlocks(ctx, basechain(currentShards), prevShards)
+		if err != nil {
+			v.log.Debug().Err(err).Uint32("master", master.SeqNo).Msg("failed to get not seen shards on block")
+			time.Sleep(300 * time.Millisecond)
+			continue
 		}
 		v.log.Debug().Uint32("seqno", master.SeqNo).Dur("took", time.Since(tm)).Msg("not seen shards fetched")
 
 		var shardsWg sync.WaitGroup
 		shardsWg.Add(len(newShards))
-		shardBlocksNum = uint64(len(newShards))
+		ev.Shards = make([]ShardBlock, len(newShards))
 		// for each shard block getting transactions
-		for _, shard := range newShards {
+		for i, shard := range newShards {
 			v.log.Debug().Uint32("seqno", shard.SeqNo).Uint64("shard", uint64(shard.Shard)).Int32("wc", shard.Workchain).Msg("scanning shard")
 
-			go func(shard *ton.BlockIDExt) {
+			go func(sb *ShardBlock, shard *ton.BlockIDExt) {
 				defer shardsWg.Done()
 
-				var block *tlb.Block
-				{
-					ctx := ctx
-					for z := 0; z < 20; z++ { // TODO: retry without loosing
-						ctx, err = v.api.Client().StickyContextNextNode(ctx)
-						if err != nil {
-							v.log.Debug().Err(err).Uint32("master", master.SeqNo).Int64("shard", shard.Shard).
-								Uint32("shard_seqno", shard.SeqNo).Msg("failed to pick next node")
-							break
-						}
-
-						qCtx, cancel := context.WithTimeout(ctx, 20*time.Second)
-						block, err = v.api.WaitForBlock(master.SeqNo).GetBlockData(qCtx, shard)
-						cancel()
-						if err != nil {
-							v.log.Debug().Err(err).Uint32("master", master.SeqNo).Int64("shard", shard.Shard).
-								Uint32("shard_seqno", shard.SeqNo).Msg("failed to get block")
-							time.Sleep(200 * time.Millisecond)
-							continue
-						}
-						break
+				var err error
+				*sb, err = v.ScanShard(ctx, master, shard, ch)
+				if err != nil {
+					v.log.Error().Err(err).Uint32("seqno", shard.SeqNo).Uint64("shard", uint64(shard.Shard)).Int32("wc", shard.Workchain).Msg("failed to parse block, queued for repair")
+					ch <- ShardFailedEvent{
+						Master: master,
+						Shard:  shard,
+						Err:    err,
 					}
 				}
+			}(&ev.Shards[i], shard)
+		}
+		shardsWg.Wait()
+		for _, sb := range ev.Shards {
+			ev.Transactions += uint64(sb.Transactions)
+		}
+		return
+	}
+}
 
-				err = func() error {
-					if block == nil {
-						return fmt.Errorf("failed to fetch block")
-					}
+// ScanShard fetches a shard block first seen in master and sends a
+// TransactionEvent for each of its transactions. Nothing is sent when the
+// block can't be fetched or parsed, so a failed block can be scanned again.
+func (v *Scanner) ScanShard(ctx context.Context, master, shard *ton.BlockIDExt, ch chan<- any) (ShardBlock, error) {
+	sb := ShardBlock{ID: shard, Failed: true}
+
+	var block *tlb.Block
+	{
+		var err error
+		ctx := ctx
+		for z := 0; z < v.cfg.BlockRetries; z++ {
+			ctx, err = v.api.Client().StickyContextNextNode(ctx)
+			if err != nil {
+				v.log.Debug().Err(err).Uint32("master", master.SeqNo).Int64("shard", shard.Shard).
+					Uint32("shard_seqno", shard.SeqNo).Msg("failed to pick next node")
+				break
+			}
 
-					shr := block.Extra.ShardAccountBlocks.BeginParse()
-					shardAccBlocks, err := shr.LoadDict(256)
-					if err != nil {
-						return fmt.Errorf("faled to load shard account blocks dict: %w", err)
-					}
+			qCtx, cancel := context.WithTimeout(ctx, v.cfg.BlockTimeout.Duration)
+			block, err = v.api.WaitForBlock(master.SeqNo).GetBlockData(qCtx, shard)
+			cancel()
+			if err != nil {
+				v.log.Debug().Err(err).Uint32("master", master.SeqNo).Int64("shard", shard.Shard).
+					Uint32("shard_seqno", shard.SeqNo).Msg("failed to get block")
+				time.Sleep(200 * time.Millisecond)
+				continue
+			}
+			break
+		}
+	}
+	if block == nil {
+		return sb, fmt.Errorf("failed to fetch block")
+	}
 
-					var wg sync.WaitGroup
+	shr := block.Extra.ShardAccountBlocks.BeginParse()
+	shardAccBlocks, err := shr.LoadDict(256)
+	if err != nil {
+		return sb, fmt.Errorf("faled to load shard account blocks dict: %w", err)
+	}
 
-					sab := shardAccBlocks.All()
-					for _, kv := range sab {
-						slc := kv.Value.BeginParse()
-						if err = tlb.LoadFromCell(&tlb.CurrencyCollection{}, slc); err != nil {
-							return fmt.Errorf("faled to load aug currency collection of account block dict: %w", err)
-						}
+	// parse everything before sending, a block failing half way is retried
+	var txs []TransactionEvent
+	var firstTx []int
+	sab := shardAccBlocks.All()
+	for _, kv := range sab {
+		slc := kv.Value.BeginParse()
+		if err = tlb.LoadFromCell(&tlb.CurrencyCollection{}, slc); err != nil {
+			return sb, fmt.Errorf("faled to load aug currency collection of account block dict: %w", err)
+		}
 
-						var ab tlb.AccountBlock
-						if err = tlb.LoadFromCell(&ab, slc); err != nil {
-							return fmt.Errorf("faled to parse account block: %w", err)
-						}
+		var ab tlb.AccountBlock
+		if err = tlb.LoadFromCell(&ab, slc); err != nil {
+			return sb, fmt.Errorf("faled to parse account block: %w", err)
+		}
 
-						allTx := ab.Transactions.All()
-						transactionsNum += uint64(len(allTx))
-						for _, txKV := range allTx {
-							slcTx := txKV.Value.BeginParse()
-							if err = tlb.LoadFromCell(&tlb.CurrencyCollection{}, slcTx); err != nil {
-								return fmt.Errorf("faled to load aug currency collection of transactions dict: %w", err)
-							}
-
-							var tx tlb.Transaction
-							if err = tlb.LoadFromCell(&tx, slcTx.MustLoadRef()); err != nil {
-								return fmt.Errorf("faled to parse transaction: %w", err)
-							}
-
-							wg.Add(1)
-							v.taskPool <- accFetchTask{
-								master:   master,
-								shard:    shard,
-								tx:       &tx,
-								addr:     address.NewAddress(0, byte(shard.Workchain), ab.Addr),
-								callback: wg.Done,
-							}
-							// 1 tx for account is enough for us, as a reference
-							break
-						}
-					}
+		addr := address.NewAddress(0, byte(shard.Workchain), ab.Addr)
+		for i, txKV := range ab.Transactions.All() {
+			slcTx := txKV.Value.BeginParse()
+			if err = tlb.LoadFromCell(&tlb.CurrencyCollection{}, slcTx); err != nil {
+				return sb, fmt.Errorf("faled to load aug currency collection of transactions dict: %w", err)
+			}
+
+			txCell, err := slcTx.LoadRefCell()
+			if err != nil {
+				return sb, fmt.Errorf("faled to load transaction cell: %w", err)
+			}
+			var tx tlb.Transaction
+			if err = tlb.LoadFromCell(&tx, txCell.BeginParse()); err != nil {
+				return sb, fmt.Errorf("faled to parse transaction: %w", err)
+			}
+			tx.Hash = txCell.Hash()
 
-					v.log.Debug().Uint32("seqno", shard.SeqNo).
-						Uint64("shard", uint64(shard.Shard)).
-						Int32("wc", shard.Workchain).
-						Int("affected_accounts", len(sab)).
-						Uint64("transactions", transactionsNum).
-						Msg("scanning transactions")
+			if i == 0 {
+				firstTx = append(firstTx, len(txs))
+			}
+			txs = append(txs, TransactionEvent{
+				Master: master,
+				Shard:  shard,
+				Addr:   addr,
+				Tx:     &tx,
+			})
+		}
+	}
 
-					wg.Wait()
-					return nil
-				}()
-				if err != nil {
-					v.log.Error().Uint32("seqno", shard.SeqNo).Uint64("shard", uint64(shard.Shard)).Int32("wc", shard.Workchain).Msg("failed to parse block, skipping. Fix issue and rescan later")
-				}
-			}(shard)
+	for _, tx := range txs {
+		ch <- tx
+	}
+
+	// 1 tx for account is enough for us, as a reference
+	var wg sync.WaitGroup
+	for _, i := range firstTx {
+		wg.Add(1)
+		v.taskPool <- accFetchTask{
+			master:   master,
+			shard:    shard,
+			tx:       txs[i].Tx,
+			addr:     txs[i].Addr,
+			callback: wg.Done,
 		}
-		shardsWg.Wait()
-		return
+		metrics.TaskPoolDepth.Set(float64(len(v.taskPool)))
 	}
+
+	v.log.Debug().Uint32("seqno", shard.SeqNo).
+		Uint64("shard", uint64(shard.Shard)).
+		Int32("wc", shard.Workchain).
+		Int("affected_accounts", len(sab)).
+		Int("transactions", len(txs)).
+		Msg("scanning transactions")
+
+	wg.Wait()
+	metrics.ShardBlocks.Inc()
+	metrics.Transactions.Add(float64(len(txs)))
+	sb.GenUtime = block.BlockInfo.GenUtime
+	sb.Transactions = len(txs)
+	sb.Failed = false
+	return sb, nil
 }
@@ -0,0 +1,52 @@
+package dumps
+
+import (
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+)
+
+// TransactionEvent is sent by the scanner for every transaction found in a
+// new shard block.
+type TransactionEvent struct {
+	Master *ton.BlockIDExt
+	Shard  *ton.BlockIDExt
+	Addr   *address.Address
+	Tx     *tlb.Transaction
+}
+
+// BlockEvent is sent once a masterchain block and all shard blocks first
+// seen in it have been processed.
+type BlockEvent struct {
+	Master       *ton.BlockIDExt
+	GenUtime     uint32
+	Shards       []ShardBlock
+	Transactions uint64
+}
+
+type ShardBlock struct {
+	ID           *ton.BlockIDExt
+	GenUtime     uint32
+	Transactions int
+	// Failed is set when the block couldn't be scanned, a ShardFailedEvent
+	// was sent for it.
+	Failed bool
+}
+
+// ShardFailedEvent is sent for shard blocks that couldn't be fetched or
+// parsed, so they can be scanned again later with Scanner.ScanShard.
+type ShardFailedEvent struct {
+	Master *ton.BlockIDExt
+	Shard  *ton.BlockIDExt
+	Err    error
+}
+
+// AccountFailedEvent is sent when an account changed by Tx couldn't be
+// fetched, so it can be checked again later with Scanner.ScanAccount.
+type AccountFailedEvent struct {
+	Master *ton.BlockIDExt
+	Shard  *ton.BlockIDExt
+	Addr   *address.Address
+	Tx     *tlb.Transaction
+	Err    error
+}
@@ -2,28 +2,24 @@ package dumps
 
 import (
 	"context"
+	"fmt"
 
-	"log"
-
+	"github.com/rs/zerolog"
 
 	"github.com/xssnick/tonutils-go/liteclient"
 	"github.com/xssnick/tonutils-go/ton"
 )
 
-
-func InitializeTONapi(cfg *liteclient.GlobalConfig, ctx context.Context) ton.APIClientWrapped {
+func InitializeTONapi(ctx context.Context, cfg *liteclient.GlobalConfig, lg zerolog.Logger) (ton.APIClientWrapped, error) {
 	client := liteclient.NewConnectionPool()
 
 	err := client.AddConnectionsFromConfig(ctx, cfg)
 	if err != nil {
-		log.Fatalln("connection err: ", err.Error())
-		return nil
+		return nil, fmt.Errorf("connect to liteservers: %w", err)
 	}
 	api := ton.NewAPIClient(client, ton.ProofCheckPolicyFast).WithRetry()
 	api.SetTrustedBlockFromConfig(cfg)
 
-	log.Println("checking proofs since config init block, it may take near a minute...")
-	return api
-
+	lg.Info().Msg("checking proofs since config init block, it may take near a minute")
+	return api, nil
 }
-
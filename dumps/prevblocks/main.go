@@ -1,54 +1,71 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"log"
+	"os"
 	"sort"
-	"sync"
 	"time"
 
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/logging"
+	"github.com/FishDontExist/TONindexer/traverse"
+	"github.com/rs/zerolog"
 	"github.com/xssnick/tonutils-go/liteclient"
-	"github.com/xssnick/tonutils-go/tlb"
 	"github.com/xssnick/tonutils-go/ton"
 )
 
 type LiteClient struct {
 	ctx                 context.Context
 	api                 ton.APIClientWrapped
+	walker              *traverse.Walker
 	previousMasterBlock *ton.BlockIDExt
+	previousShards      []*ton.BlockIDExt
+	log                 zerolog.Logger
 }
 
-func New() *LiteClient {
+func New(net *config.NetworkConfig, lg zerolog.Logger) (*LiteClient, error) {
 	client := liteclient.NewConnectionPool()
 
-	// cfg, err := liteclient.GetConfigFromUrl(context.Background(), "https://ton.org/global.config.json")
-	filepath := "./globalconfig.json"
-	cfg, err := liteclient.GetConfigFromFile(filepath)
+	cfg, err := config.GetConfig(context.Background(), net)
 	if err != nil {
-		log.Fatalln("get config err: ", err.Error())
-		return nil
+		return nil, fmt.Errorf("get network config: %w", err)
 	}
 
 	err = client.AddConnectionsFromConfig(context.Background(), cfg)
 	if err != nil {
-		log.Fatalln("connection err: ", err.Error())
-		return nil
+		return nil, fmt.Errorf("connect to liteservers: %w", err)
 	}
 
 	api := ton.NewAPIClient(client, ton.ProofCheckPolicyFast).WithRetry()
 	api.SetTrustedBlockFromConfig(cfg)
 	return &LiteClient{
-		api: api,
-		ctx: context.Background(),
-	}
+		api:    api,
+		walker: traverse.New(api),
+		ctx:    context.Background(),
+		log:    lg,
+	}, nil
 }
 
 func main() {
+	// used until the configured logger is built
+	lg := zerolog.New(os.Stderr).With().Timestamp().Logger()
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		lg.Fatal().Err(err).Msg("load config")
+	}
+	configured, err := logging.New(cfg.Log)
+	if err != nil {
+		lg.Fatal().Err(err).Msg("logger")
+	}
+	lg = configured
 
 	// Initialize the LiteClient
-	liteClient := New()
+	liteClient, err := New(cfg.TON, lg)
+	if err != nil {
+		lg.Fatal().Err(err).Msg("connect")
+	}
 
 	// Start processing
 	liteClient.Start()
@@ -63,7 +80,7 @@ func (l *LiteClient) Start() {
 		case <-ticker.C:
 			err := l.ProcessBlocks()
 			if err != nil {
-				log.Printf("Error processing blocks: %v", err)
+				l.log.Error().Err(err).Msg("process blocks")
 			}
 		case <-l.ctx.Done():
 			return
@@ -83,22 +100,24 @@ func (l *LiteClient) ProcessBlocks() error {
 
 	// If this is the first run, set the previousMasterBlock and return
 	if l.previousMasterBlock == nil {
+		shardBlocks, err := api.GetBlockShardsInfo(ctx, currentMasterBlock)
+		if err != nil {
+			return fmt.Errorf("failed to get shard blocks: %v", err)
+		}
 		l.previousMasterBlock = currentMasterBlock
-		log.Println("First run")
+		l.previousShards = shardBlocks
+		l.log.Info().Msg("first run")
 		return nil
 	}
 
 	// Collect masterchain blocks between previous and current masterchain blocks
-	masterBlocks, err := l.collectMasterchainBlocks(ctx, currentMasterBlock, l.previousMasterBlock)
+	masterBlocks, err := l.walker.MasterBlocks(ctx, l.previousMasterBlock, currentMasterBlock)
 	if err != nil {
 		return fmt.Errorf("failed to collect masterchain blocks: %v", err)
 	}
 
 	var blocks []*ton.BlockIDExt
-	blocksMap := make(map[string]*ton.BlockIDExt)
-	var mu sync.Mutex
-	var wg sync.WaitGroup
-
+	prevShards := l.previousShards
 	for _, masterBlock := range masterBlocks {
 		// Get shard blocks for each masterchain block
 		shardBlocks, err := api.GetBlockShardsInfo(ctx, masterBlock)
@@ -117,208 +136,26 @@ func (l *LiteClient) ProcessBlocks() error {
 			return fmt.Errorf("no workchain 0 shard blocks found at masterchain seqno %d", masterBlock.SeqNo)
 		}
 
-		resultCh := make(chan blockResult, len(workchain0Shards))
-
-		for _, shardBlock := range workchain0Shards {
-			wg.Add(1)
-			go func(shardBlock *ton.BlockIDExt) {
-				defer wg.Done()
-				shardBlocksCollected, err := l.collectShardBlocks(ctx, shardBlock, l.previousMasterBlock.SeqNo)
-				if err != nil {
-					resultCh <- blockResult{nil, err}
-					return
-				}
-				resultCh <- blockResult{shardBlocksCollected, nil}
-			}(shardBlock)
-		}
-
-		wg.Wait()
-		close(resultCh)
-
-		for res := range resultCh {
-			if res.err != nil {
-				return fmt.Errorf("error collecting shard blocks: %v", res.err)
-			}
-			mu.Lock()
-			for _, blk := range res.blocks {
-				blockKey := fmt.Sprintf("%d:%d:%d", blk.Workchain, blk.Shard, blk.SeqNo)
-				if _, exists := blocksMap[blockKey]; !exists {
-					blocksMap[blockKey] = blk
-					blocks = append(blocks, blk)
-				}
-			}
-			mu.Unlock()
+		// blocks first seen in this masterchain block, across splits and merges
+		newBlocks, err := l.walker.NewShardBlocks(ctx, workchain0Shards, prevShards)
+		if err != nil {
+			return fmt.Errorf("error collecting shard blocks: %v", err)
 		}
+		blocks = append(blocks, newBlocks...)
+		prevShards = shardBlocks
 	}
 
 	// Update the previous master block for the next iteration
 	l.previousMasterBlock = currentMasterBlock
+	l.previousShards = prevShards
 
 	// Process the collected blocks as needed
-	processBlocks(blocks)
+	processBlocks(blocks, l.log)
 
 	return nil
 }
 
-type blockResult struct {
-	blocks []*ton.BlockIDExt
-	err    error
-}
-
-/*
-func (l *LiteClient) collectMasterchainBlocks(ctx context.Context, startBlock, endBlock *ton.BlockIDExt) ([]*ton.BlockIDExt, error) {
-	var blocks []*ton.BlockIDExt
-	queue := []*ton.BlockIDExt{startBlock}
-	visited := make(map[string]bool)
-
-	for len(queue) > 0 {
-		currentBlockID := queue[0]
-		queue = queue[1:]
-
-		blockKey := fmt.Sprintf("%d:%d:%d", currentBlockID.Workchain, currentBlockID.Shard, currentBlockID.SeqNo)
-		if visited[blockKey] {
-			continue
-		}
-		visited[blockKey] = true
-
-		blocks = append(blocks, currentBlockID)
-
-		// Termination condition
-		if currentBlockID.SeqNo <= endBlock.SeqNo {
-			if currentBlockID.Workchain == endBlock.Workchain &&
-				currentBlockID.Shard == endBlock.Shard &&
-				currentBlockID.SeqNo == endBlock.SeqNo &&
-				bytes.Equal(currentBlockID.RootHash, endBlock.RootHash) &&
-				bytes.Equal(currentBlockID.FileHash, endBlock.FileHash) {
-				// Reached the end block
-				break
-			} else {
-				continue
-			}
-		}
-
-		// Fetch the block data
-		blockData, err := l.api.GetBlockData(ctx, currentBlockID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get block data for block %d: %w", currentBlockID.SeqNo, err)
-		}
-
-		// Get previous block IDs
-		prevBlockIDs, err := getPrevBlocks(&blockData.BlockInfo)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get previous blocks for block %d: %w", currentBlockID.SeqNo, err)
-		}
-
-		// Add previous blocks to the queue
-		queue = append(queue, prevBlockIDs...)
-	}
-
-	return blocks, nil
-}*/
-
-func (l *LiteClient) collectShardBlocks(ctx context.Context, startBlock *ton.BlockIDExt, endSeqNo uint32) ([]*ton.BlockIDExt, error) {
-	var blocks []*ton.BlockIDExt
-	queue := []*ton.BlockIDExt{startBlock}
-	visited := make(map[string]bool)
-
-	for len(queue) > 0 {
-		currentBlockID := queue[0]
-		queue = queue[1:]
-
-		blockKey := fmt.Sprintf("%d:%d:%d", currentBlockID.Workchain, currentBlockID.Shard, currentBlockID.SeqNo)
-		if visited[blockKey] {
-			continue
-		}
-		visited[blockKey] = true
-
-		blocks = append(blocks, currentBlockID)
-
-		// Termination condition
-		if currentBlockID.SeqNo <= endSeqNo {
-			break
-		}
-
-		// Fetch the block data
-		blockData, err := l.api.GetBlockData(ctx, currentBlockID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get block data for block %d: %w", currentBlockID.SeqNo, err)
-		}
-
-		// Get previous block IDs
-		prevBlockIDs, err := getPrevBlocks(&blockData.BlockInfo)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get previous blocks for block %d: %w", currentBlockID.SeqNo, err)
-		}
-
-		// Add previous blocks to the queue
-		queue = append(queue, prevBlockIDs...)
-	}
-
-	return blocks, nil
-}
-
-func getPrevBlocks(blockInfo *tlb.BlockHeader) ([]*ton.BlockIDExt, error) {
-	prevBlocks, err := blockInfo.GetParentBlocks()
-	if err != nil {
-		return nil, err
-	}
-	return prevBlocks, nil
-}
-func (l *LiteClient) collectMasterchainBlocks(ctx context.Context, startBlock, endBlock *ton.BlockIDExt) ([]*ton.BlockIDExt, error) {
-	var blocks []*ton.BlockIDExt
-	limit := 100
-	queue := []*ton.BlockIDExt{startBlock}
-	visited := make(map[string]bool)
-
-	for len(queue) > 0 {
-		currentBlockID := queue[0]
-		queue = queue[1:]
-
-		blockKey := fmt.Sprintf("%d:%d:%d", currentBlockID.Workchain, currentBlockID.Shard, currentBlockID.SeqNo)
-		if visited[blockKey] {
-			continue
-		}
-		visited[blockKey] = true
-
-		blocks = append(blocks, currentBlockID)
-
-		// Check if we've reached the end block
-		if currentBlockID.SeqNo <= endBlock.SeqNo {
-			if currentBlockID.Workchain == endBlock.Workchain &&
-				currentBlockID.Shard == endBlock.Shard &&
-				currentBlockID.SeqNo == endBlock.SeqNo &&
-				bytes.Equal(currentBlockID.RootHash, endBlock.RootHash) &&
-				bytes.Equal(currentBlockID.FileHash, endBlock.FileHash) {
-				// Reached the end block, terminate the function
-				break
-			}
-		}
-
-		// Check if we've reached the limit
-		if len(blocks) >= limit {
-			break
-		}
-
-		// Fetch the block data
-		blockData, err := l.api.GetBlockData(ctx, currentBlockID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get block data for block %d: %w", currentBlockID.SeqNo, err)
-		}
-
-		// Get previous block IDs
-		prevBlockIDs, err := getPrevBlocks(&blockData.BlockInfo)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get previous blocks for block %d: %w", currentBlockID.SeqNo, err)
-		}
-
-		// Add previous blocks to the queue
-		queue = append(queue, prevBlockIDs...)
-	}
-
-	return blocks, nil
-}
-
-func processBlocks(blocks []*ton.BlockIDExt) {
+func processBlocks(blocks []*ton.BlockIDExt, lg zerolog.Logger) {
 	sort.Slice(blocks, func(i, j int) bool {
 		if blocks[i].SeqNo == blocks[j].SeqNo {
 			return blocks[i].Shard > blocks[j].Shard
@@ -327,6 +164,6 @@ func processBlocks(blocks []*ton.BlockIDExt) {
 	})
 
 	for _, blk := range blocks {
-		fmt.Printf("Block: Workchain %d, Shard %d, SeqNo %d\n", blk.Workchain, blk.Shard, blk.SeqNo)
+		lg.Info().Int32("workchain", blk.Workchain).Int64("shard", blk.Shard).Uint32("seqno", blk.SeqNo).Msg("block")
 	}
 }
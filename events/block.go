@@ -0,0 +1,96 @@
+package events
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/xssnick/tonutils-go/ton"
+)
+
+// Block is published once a masterchain block has been fully processed.
+// Shards are the shard blocks first seen in it, Transactions their total.
+type Block struct {
+	Seqno        uint32  `json:"seqno"`
+	Workchain    int32   `json:"workchain"`
+	Shard        string  `json:"shard"`
+	RootHash     string  `json:"root_hash"`
+	FileHash     string  `json:"file_hash"`
+	GenUtime     uint32  `json:"gen_utime"`
+	Transactions uint64  `json:"transactions"`
+	Shards       []Shard `json:"shards"`
+}
+
+type Shard struct {
+	Seqno        uint32 `json:"seqno"`
+	Workchain    int32  `json:"workchain"`
+	Shard        string `json:"shard"`
+	RootHash     string `json:"root_hash"`
+	FileHash     string `json:"file_hash"`
+	GenUtime     uint32 `json:"gen_utime"`
+	Transactions int    `json:"transactions"`
+	// Failed shard blocks couldn't be scanned yet and are queued for repair.
+	Failed bool `json:"failed,omitempty"`
+}
+
+func NewBlock(id *ton.BlockIDExt, genUtime uint32, shards []Shard) Block {
+	b := Block{
+		Seqno:     id.SeqNo,
+		Workchain: id.Workchain,
+		Shard:     shardHex(id),
+		RootHash:  hex.EncodeToString(id.RootHash),
+		FileHash:  hex.EncodeToString(id.FileHash),
+		GenUtime:  genUtime,
+		Shards:    shards,
+	}
+	for _, s := range shards {
+		b.Transactions += uint64(s.Transactions)
+	}
+	return b
+}
+
+func NewShard(id *ton.BlockIDExt, genUtime uint32, transactions int) Shard {
+	return Shard{
+		Seqno:        id.SeqNo,
+		Workchain:    id.Workchain,
+		Shard:        shardHex(id),
+		RootHash:     hex.EncodeToString(id.RootHash),
+		FileHash:     hex.EncodeToString(id.FileHash),
+		GenUtime:     genUtime,
+		Transactions: transactions,
+	}
+}
+
+func (b Block) ID() (*ton.BlockIDExt, error) {
+	return blockID(b.Workchain, b.Shard, b.Seqno, b.RootHash, b.FileHash)
+}
+
+func (s Shard) ID() (*ton.BlockIDExt, error) {
+	return blockID(s.Workchain, s.Shard, s.Seqno, s.RootHash, s.FileHash)
+}
+
+func shardHex(id *ton.BlockIDExt) string {
+	return fmt.Sprintf("%016x", uint64(id.Shard))
+}
+
+func blockID(workchain int32, shard string, seqno uint32, rootHash, fileHash string) (*ton.BlockIDExt, error) {
+	sh, err := strconv.ParseUint(shard, 16, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad shard %q: %w", shard, err)
+	}
+	root, err := hex.DecodeString(rootHash)
+	if err != nil {
+		return nil, fmt.Errorf("bad root hash: %w", err)
+	}
+	file, err := hex.DecodeString(fileHash)
+	if err != nil {
+		return nil, fmt.Errorf("bad file hash: %w", err)
+	}
+	return &ton.BlockIDExt{
+		Workchain: workchain,
+		Shard:     int64(sh),
+		SeqNo:     seqno,
+		RootHash:  root,
+		FileHash:  file,
+	}, nil
+}
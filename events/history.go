@@ -0,0 +1,40 @@
+package events
+
+import "sync"
+
+// History keeps the most recent blocks so streams can resume after a
+// reconnect.
+type History struct {
+	mx     sync.RWMutex
+	blocks []Block
+	size   int
+}
+
+func NewHistory(size int) *History {
+	return &History{size: size}
+}
+
+func (h *History) Add(b Block) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.blocks = append(h.blocks, b)
+	if len(h.blocks) > h.size {
+		h.blocks = append(h.blocks[:0], h.blocks[len(h.blocks)-h.size:]...)
+	}
+}
+
+// Since returns the kept blocks with seqno greater than seqno. complete is
+// false when blocks right after seqno have already been evicted.
+func (h *History) Since(seqno uint32) (blocks []Block, complete bool) {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+	if len(h.blocks) == 0 {
+		return nil, true
+	}
+	for i, b := range h.blocks {
+		if b.Seqno > seqno {
+			return append([]Block(nil), h.blocks[i:]...), b.Seqno == seqno+1 || i > 0
+		}
+	}
+	return nil, true
+}
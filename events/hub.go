@@ -0,0 +1,106 @@
+// Package events fans decoded chain events out to API subscribers.
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/FishDontExist/TONindexer/chain"
+)
+
+// Transaction is published for every transaction processed by the scanner.
+// Account and JettonMaster are raw addresses used for matching filters.
+type Transaction struct {
+	Account      string
+	JettonMaster string
+	Tx           *chain.TxInfo
+}
+
+// Hub delivers published events to all current subscribers.
+type Hub struct {
+	buffer int
+
+	mx   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscription receives events on C. C is closed when the subscriber falls
+// more than the hub buffer behind, or after Unsubscribe.
+type Subscription struct {
+	C <-chan any
+
+	ch chan any
+	// txs is set while the subscriber wants transactions, others only get
+	// blocks.
+	txs atomic.Bool
+}
+
+// WantTransactions sets whether transactions are delivered to s.
+func (s *Subscription) WantTransactions(v bool) {
+	s.txs.Store(v)
+}
+
+func NewHub(buffer int) *Hub {
+	return &Hub{
+		buffer: buffer,
+		subs:   map[*Subscription]struct{}{},
+	}
+}
+
+func (h *Hub) Subscribe() *Subscription {
+	ch := make(chan any, h.buffer)
+	s := &Subscription{C: ch, ch: ch}
+
+	h.mx.Lock()
+	h.subs[s] = struct{}{}
+	h.mx.Unlock()
+	return s
+}
+
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.ch)
+	}
+}
+
+// Len returns the number of active subscribers.
+func (h *Hub) Len() int {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+	return len(h.subs)
+}
+
+// WantsTransactions reports whether any subscriber wants transactions, so
+// they are only decoded when someone listens.
+func (h *Hub) WantsTransactions() bool {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+	for s := range h.subs {
+		if s.txs.Load() {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish never blocks: a subscriber whose buffer is full is dropped, so one
+// slow client can't stall the scanner.
+func (h *Hub) Publish(ev any) {
+	_, tx := ev.(Transaction)
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	for s := range h.subs {
+		if tx && !s.txs.Load() {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+			delete(h.subs, s)
+			close(s.ch)
+		}
+	}
+}
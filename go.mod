@@ -3,15 +3,26 @@ module github.com/FishDontExist/TONindexer
 go 1.22.4
 
 require (
-	github.com/gorilla/mux v1.8.1 // indirect
+	github.com/gorilla/mux v1.8.1
+	github.com/gorilla/websocket v1.5.3
+	github.com/prometheus/client_golang v1.19.1
+	github.com/rs/zerolog v1.30.0
+	github.com/xssnick/ton-payment-network v0.0.0-20240208044522-8b7c424b43e4
+	github.com/xssnick/tonutils-go v1.10.2
+	golang.org/x/crypto v0.17.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/mattn/go-colorable v0.1.12 // indirect
 	github.com/mattn/go-isatty v0.0.14 // indirect
 	github.com/oasisprotocol/curve25519-voi v0.0.0-20220328075252-7dd334e3daae // indirect
-	github.com/rs/zerolog v1.30.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
 	github.com/sigurn/crc16 v0.0.0-20211026045750-20ab5afb07e3 // indirect
 	github.com/tonkeeper/tongo v1.10.2 // indirect
-	github.com/xssnick/ton-payment-network v0.0.0-20240208044522-8b7c424b43e4 // indirect
-	github.com/xssnick/tonutils-go v1.10.2 // indirect
-	golang.org/x/crypto v0.17.0 // indirect
-	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
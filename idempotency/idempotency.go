@@ -0,0 +1,246 @@
+// Package idempotency remembers the responses of send requests by their
+// Idempotency-Key, so a client retrying after a timeout gets the original
+// result instead of a second transfer.
+//
+// A key is taken before the transfer is made. Its response is recorded
+// after when it is definitive, a success or a rejection of the request;
+// other failures release the key, so a retry makes the request again. A key
+// whose request never finished, e.g. because the service stopped, is
+// reported as in progress until it goes stale, then a retry takes it over.
+//
+// Changes are appended to a log, which is rewritten once most of its
+// records are outdated.
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/store"
+	"github.com/rs/zerolog"
+)
+
+var (
+	// ErrConflict is returned for a key reused with a different request.
+	ErrConflict = errors.New("idempotency key was used for a different request")
+	// ErrInProgress is returned for a key whose request has no response yet.
+	ErrInProgress = errors.New("request with this idempotency key is in progress or was interrupted")
+)
+
+// retention is how long keys are remembered.
+const retention = 7 * 24 * time.Hour
+
+// maxKeyLen bounds keys, they are persisted as given.
+const maxKeyLen = 256
+
+// minCompact is how many outdated log records are kept at least, so small
+// stores aren't rewritten on every change.
+const minCompact = 1000
+
+// statusClientClosedRequest is the status of requests canceled by the
+// client.
+const statusClientClosedRequest = 499
+
+type Record struct {
+	Key         string    `json:"key"`
+	Scope       string    `json:"scope"`
+	RequestHash string    `json:"request_hash"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Done is set once the response is recorded.
+	Done   bool            `json:"done"`
+	Status int             `json:"status,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// entry is a log record, the latest version of a record or its removal.
+type entry struct {
+	Record
+	Removed bool `json:"removed,omitempty"`
+}
+
+type Store struct {
+	log    *store.Log
+	logger zerolog.Logger
+	// stale is how long a key stays in progress without a response.
+	stale time.Duration
+
+	mx      sync.Mutex
+	records map[string]*Record
+	// entries is how many records the log holds, outdated ones included.
+	entries int
+}
+
+// Open loads the keys in dir. A request that has no response stale after
+// taking its key is taken to have been interrupted.
+func Open(dir string, stale time.Duration, lg zerolog.Logger) (*Store, error) {
+	l, err := store.OpenLog(dir, "idempotency.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		log:     l,
+		logger:  lg.With().Str("component", "idempotency").Logger(),
+		stale:   stale,
+		records: map[string]*Record{},
+	}
+	err = l.Scan(func(_ int64, line []byte) error {
+		var e entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("parse idempotency key: %w", err)
+		}
+		s.apply(e)
+		s.entries++
+		return nil
+	})
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("load idempotency keys: %w", err)
+	}
+	return s, nil
+}
+
+// Hash returns the request hash of req, its JSON encoding.
+func Hash(req any) (string, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("encode request: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func id(scope, key string) string {
+	return scope + ":" + key
+}
+
+// Definitive reports whether a response with status is recorded: a success,
+// or a rejection of the request that a retry gets again. Server errors,
+// timeouts and canceled requests are not, a retry may succeed.
+func Definitive(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, statusClientClosedRequest:
+		return false
+	}
+	return status < http.StatusInternalServerError
+}
+
+// Begin takes key for a request of scope with hash. When the same request
+// took it before and has its response recorded, the record is returned with
+// false and the request must not be made again. Otherwise the returned
+// record is passed to Finish.
+func (s *Store) Begin(scope, key, hash string) (Record, bool, error) {
+	if len(key) > maxKeyLen {
+		return Record{}, false, fmt.Errorf("%w: idempotency key is longer than %d bytes", chain.ErrInvalidInput, maxKeyLen)
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.prune()
+	now := time.Now().UTC()
+	if rec, ok := s.records[id(scope, key)]; ok && (rec.Done || now.Sub(rec.CreatedAt) < s.stale) {
+		switch {
+		case rec.RequestHash != hash:
+			return Record{}, false, ErrConflict
+		case !rec.Done:
+			return Record{}, false, ErrInProgress
+		}
+		return *rec, false, nil
+	}
+	rec := Record{Key: key, Scope: scope, RequestHash: hash, CreatedAt: now}
+	if err := s.write(entry{Record: rec}); err != nil {
+		return Record{}, false, err
+	}
+	return rec, true, nil
+}
+
+// Finish records the response of the request that took the key of rec, or
+// releases the key when the response isn't definitive. It does nothing when
+// a later request took the key over.
+func (s *Store) Finish(rec Record, status int, body []byte) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	cur, ok := s.records[id(rec.Scope, rec.Key)]
+	if !ok || cur.Done || !cur.CreatedAt.Equal(rec.CreatedAt) {
+		return
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if !Definitive(status) {
+		if err := s.write(entry{Record: *cur, Removed: true}); err != nil {
+			s.logger.Error().Err(err).Str("key", rec.Key).Msg("release idempotency key")
+		}
+		return
+	}
+	done := *cur
+	done.Done, done.Status = true, status
+	if json.Valid(body) {
+		done.Body = append(json.RawMessage(nil), body...)
+	}
+	if err := s.write(entry{Record: done}); err != nil {
+		// the key stays in progress until it goes stale
+		s.logger.Error().Err(err).Str("key", rec.Key).Msg("record idempotent response")
+	}
+}
+
+// prune drops expired keys, it must be called with mx held. Their records
+// go at the next compaction, on restart they expire again.
+func (s *Store) prune() {
+	cutoff := time.Now().Add(-retention)
+	for k, rec := range s.records {
+		if rec.CreatedAt.Before(cutoff) {
+			delete(s.records, k)
+		}
+	}
+}
+
+// apply must be called with mx held, or before the store is shared.
+func (s *Store) apply(e entry) {
+	if e.Removed {
+		delete(s.records, id(e.Scope, e.Key))
+		return
+	}
+	rec := e.Record
+	s.records[id(e.Scope, e.Key)] = &rec
+}
+
+// write appends e to the log and applies it, then compacts the log once
+// most of its records are outdated. It must be called with mx held.
+func (s *Store) write(e entry) error {
+	if _, err := s.log.Append(e); err != nil {
+		return fmt.Errorf("save idempotency key: %w", err)
+	}
+	s.apply(e)
+	s.entries++
+	if s.entries > 2*len(s.records)+minCompact {
+		if err := s.compact(); err != nil {
+			// the log is still complete, only larger than needed
+			s.logger.Error().Err(err).Msg("compact idempotency keys")
+		}
+	}
+	return nil
+}
+
+// compact rewrites the log with the current records, it must be called with
+// mx held.
+func (s *Store) compact() error {
+	records := make([][]byte, 0, len(s.records))
+	for _, rec := range s.records {
+		data, err := json.Marshal(entry{Record: *rec})
+		if err != nil {
+			return fmt.Errorf("encode idempotency key: %w", err)
+		}
+		records = append(records, data)
+	}
+	if _, err := s.log.Rewrite(records); err != nil {
+		return err
+	}
+	s.entries = len(records)
+	return nil
+}
@@ -0,0 +1,129 @@
+package idempotency
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func open(t *testing.T, dir string, stale time.Duration) *Store {
+	t.Helper()
+	s, err := Open(dir, stale, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { s.log.Close() })
+	return s
+}
+
+func begin(t *testing.T, s *Store, key, hash string) (Record, bool) {
+	t.Helper()
+	rec, started, err := s.Begin("sendtx", key, hash)
+	if err != nil {
+		t.Fatalf("Begin(%q) error = %v", key, err)
+	}
+	return rec, started
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, time.Minute)
+	rec, started := begin(t, s, "k", "h")
+	if !started {
+		t.Fatal("Begin() of a new key didn't start")
+	}
+	if _, _, err := s.Begin("sendtx", "k", "h"); !errors.Is(err, ErrInProgress) {
+		t.Errorf("Begin() while in progress error = %v, want ErrInProgress", err)
+	}
+	s.Finish(rec, http.StatusOK, []byte(`{"hash":"ab"}`))
+	if _, _, err := s.Begin("sendtx", "k", "other"); !errors.Is(err, ErrConflict) {
+		t.Errorf("Begin() with another request error = %v, want ErrConflict", err)
+	}
+
+	// the response is read back from the log
+	s = open(t, dir, time.Minute)
+	got, started := begin(t, s, "k", "h")
+	if started || !got.Done || got.Status != http.StatusOK || string(got.Body) != `{"hash":"ab"}` {
+		t.Errorf("Begin() after restart = %+v, %v, want the recorded response", got, started)
+	}
+	if _, started = begin(t, s, "k2", "h"); !started {
+		t.Error("Begin() of a key of no request didn't start")
+	}
+}
+
+func TestDefinitive(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusBadRequest, true},
+		{http.StatusNotFound, true},
+		{http.StatusRequestTimeout, false},
+		{http.StatusTooManyRequests, false},
+		{statusClientClosedRequest, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusBadGateway, false},
+		{http.StatusGatewayTimeout, false},
+	}
+	for _, tt := range tests {
+		if got := Definitive(tt.status); got != tt.want {
+			t.Errorf("Definitive(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestReleased(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, time.Minute)
+	rec, _ := begin(t, s, "k", "h")
+	s.Finish(rec, http.StatusBadGateway, []byte(`{"error":"liteserver failure"}`))
+	if _, started := begin(t, s, "k", "h"); !started {
+		t.Error("Begin() after a failure didn't start again")
+	}
+
+	// the release and the new request are both in the log
+	s = open(t, dir, time.Minute)
+	if _, _, err := s.Begin("sendtx", "k", "h"); !errors.Is(err, ErrInProgress) {
+		t.Errorf("Begin() after restart error = %v, want ErrInProgress", err)
+	}
+}
+
+func TestStale(t *testing.T) {
+	s := open(t, t.TempDir(), time.Millisecond)
+	old, _ := begin(t, s, "k", "h")
+	time.Sleep(5 * time.Millisecond)
+	rec, started := begin(t, s, "k", "h")
+	if !started {
+		t.Fatal("Begin() of a stale key didn't take it over")
+	}
+	// the interrupted request can't record over the one that took over
+	s.Finish(old, http.StatusOK, []byte(`{"old":true}`))
+	s.Finish(rec, http.StatusOK, []byte(`{"old":false}`))
+	got, started := begin(t, s, "k", "h")
+	if started || string(got.Body) != `{"old":false}` {
+		t.Errorf("Begin() = %+v, %v, want the response of the request that took over", got, started)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, time.Minute)
+	kept, _ := begin(t, s, "kept", "h")
+	s.Finish(kept, http.StatusOK, []byte(`{}`))
+	for i := 0; i < minCompact; i++ {
+		rec, _ := begin(t, s, "k", "h")
+		s.Finish(rec, http.StatusBadGateway, nil)
+	}
+	if s.entries > minCompact {
+		t.Errorf("log holds %d records after compaction, want at most %d", s.entries, minCompact)
+	}
+
+	s = open(t, dir, time.Minute)
+	if got, started := begin(t, s, "kept", "h"); started || !got.Done {
+		t.Errorf("Begin() after compaction = %+v, %v, want the recorded response", got, started)
+	}
+}
@@ -0,0 +1,237 @@
+// Package index records which masterchain blocks and shard blocks the
+// scanner has processed.
+//
+// Records are appended to a log. Once it holds twice the records of the
+// blocks kept, it is rewritten with the latest record of the newest blocks
+// only, older blocks are then known as indexed without their record.
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/FishDontExist/TONindexer/events"
+	"github.com/FishDontExist/TONindexer/store"
+)
+
+// Range is an inclusive range of masterchain seqnos.
+type Range struct {
+	From uint32 `json:"from"`
+	To   uint32 `json:"to"`
+}
+
+type Index struct {
+	log *store.Log
+	// ranges holds indexed of the last compaction, the log has no record
+	// of some of them.
+	ranges *store.File
+	// keep is how many of the newest blocks keep their record.
+	keep int
+
+	mx sync.RWMutex
+	// indexed is sorted and its ranges neither overlap nor touch.
+	indexed []Range
+	// offsets is the log offset of the latest record of each block.
+	offsets map[uint32]int64
+	// records is how many records the log holds, replaced ones included.
+	records int
+}
+
+func Open(dir string, keep int) (*Index, error) {
+	ranges, err := store.Open(dir, "blocks-ranges.json")
+	if err != nil {
+		return nil, err
+	}
+	l, err := store.OpenLog(dir, "blocks.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	i := &Index{log: l, ranges: ranges, keep: keep, offsets: map[uint32]int64{}}
+	if err = ranges.Load(&i.indexed); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("load indexed ranges: %w", err)
+	}
+	err = l.Scan(func(off int64, line []byte) error {
+		var b struct {
+			Seqno uint32 `json:"seqno"`
+		}
+		if err := json.Unmarshal(line, &b); err != nil {
+			return fmt.Errorf("parse indexed block: %w", err)
+		}
+		i.mark(b.Seqno)
+		i.offsets[b.Seqno] = off
+		i.records++
+		return nil
+	})
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return i, nil
+}
+
+// Add records a processed masterchain block. Adding the same seqno again
+// replaces the earlier record, which is how corrections are stored.
+func (i *Index) Add(b events.Block) error {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+	off, err := i.log.Append(b)
+	if err != nil {
+		return err
+	}
+	i.mark(b.Seqno)
+	i.offsets[b.Seqno] = off
+	i.records++
+	if i.records > 2*i.keep {
+		return i.compact()
+	}
+	return nil
+}
+
+// compact rewrites the log with the records of the newest keep blocks, it
+// must be called with mx held.
+func (i *Index) compact() error {
+	seqnos := make([]uint32, 0, len(i.offsets))
+	for seqno := range i.offsets {
+		seqnos = append(seqnos, seqno)
+	}
+	sort.Slice(seqnos, func(a, b int) bool { return seqnos[a] < seqnos[b] })
+	seqnos = seqnos[max(len(seqnos)-i.keep, 0):]
+
+	records := make([][]byte, len(seqnos))
+	for k, seqno := range seqnos {
+		rec, err := i.log.Read(i.offsets[seqno])
+		if err != nil {
+			return fmt.Errorf("compact index: %w", err)
+		}
+		records[k] = rec
+	}
+	// the ranges go first, a crash before the rewrite leaves the full log
+	if err := i.ranges.Save(i.indexed); err != nil {
+		return fmt.Errorf("compact index: %w", err)
+	}
+	offsets, err := i.log.Rewrite(records)
+	if err != nil {
+		return fmt.Errorf("compact index: %w", err)
+	}
+	i.offsets = make(map[uint32]int64, len(seqnos))
+	for k, seqno := range seqnos {
+		i.offsets[seqno] = offsets[k]
+	}
+	i.records = len(seqnos)
+	return nil
+}
+
+// mark must be called with mx held, or before the index is shared.
+func (i *Index) mark(seqno uint32) {
+	n := sort.Search(len(i.indexed), func(k int) bool { return i.indexed[k].To >= seqno })
+	if n < len(i.indexed) && i.indexed[n].From <= seqno {
+		return
+	}
+	joinPrev := n > 0 && i.indexed[n-1].To+1 == seqno
+	joinNext := n < len(i.indexed) && i.indexed[n].From == seqno+1
+	switch {
+	case joinPrev && joinNext:
+		i.indexed[n-1].To = i.indexed[n].To
+		i.indexed = append(i.indexed[:n], i.indexed[n+1:]...)
+	case joinPrev:
+		i.indexed[n-1].To = seqno
+	case joinNext:
+		i.indexed[n].From = seqno
+	default:
+		i.indexed = append(i.indexed, Range{})
+		copy(i.indexed[n+1:], i.indexed[n:])
+		i.indexed[n] = Range{From: seqno, To: seqno}
+	}
+}
+
+// Has reports whether masterchain block seqno is indexed.
+func (i *Index) Has(seqno uint32) bool {
+	i.mx.RLock()
+	defer i.mx.RUnlock()
+	n := sort.Search(len(i.indexed), func(k int) bool { return i.indexed[k].To >= seqno })
+	return n < len(i.indexed) && i.indexed[n].From <= seqno
+}
+
+// Span returns the lowest and highest indexed seqno, ok is false when
+// nothing is indexed yet.
+func (i *Index) Span() (r Range, ok bool) {
+	i.mx.RLock()
+	defer i.mx.RUnlock()
+	if len(i.indexed) == 0 {
+		return Range{}, false
+	}
+	return Range{From: i.indexed[0].From, To: i.indexed[len(i.indexed)-1].To}, true
+}
+
+// Gaps returns the masterchain seqno ranges missing between the lowest and
+// the highest indexed block.
+func (i *Index) Gaps() []Range {
+	i.mx.RLock()
+	defer i.mx.RUnlock()
+	gaps := []Range{}
+	for k := 1; k < len(i.indexed); k++ {
+		gaps = append(gaps, Range{From: i.indexed[k-1].To + 1, To: i.indexed[k].From - 1})
+	}
+	return gaps
+}
+
+// Blocks returns the recorded masterchain blocks with seqno in [from, to].
+// Blocks indexed before the records kept have none.
+func (i *Index) Blocks(from, to uint32) (map[uint32]events.Block, error) {
+	i.mx.RLock()
+	defer i.mx.RUnlock()
+	res := map[uint32]events.Block{}
+	for seqno, off := range i.offsets {
+		if seqno < from || seqno > to {
+			continue
+		}
+		line, err := i.log.Read(off)
+		if err != nil {
+			return nil, err
+		}
+		var b events.Block
+		if err = json.Unmarshal(line, &b); err != nil {
+			return nil, fmt.Errorf("parse indexed block: %w", err)
+		}
+		res[seqno] = b
+	}
+	return res, nil
+}
+
+// SetShard replaces the record of shard block s in masterchain block seqno,
+// e.g. once a block that failed to scan has been repaired.
+func (i *Index) SetShard(seqno uint32, s events.Shard) error {
+	blocks, err := i.Blocks(seqno, seqno)
+	if err != nil {
+		return err
+	}
+	b, ok := blocks[seqno]
+	if !ok {
+		return fmt.Errorf("masterchain block %d is not indexed", seqno)
+	}
+
+	shards := make([]events.Shard, 0, len(b.Shards))
+	found := false
+	for _, old := range b.Shards {
+		if old.Workchain == s.Workchain && old.Shard == s.Shard && old.Seqno == s.Seqno {
+			old, found = s, true
+		}
+		shards = append(shards, old)
+	}
+	if !found {
+		return fmt.Errorf("masterchain block %d has no shard block %d:%s:%d", seqno, s.Workchain, s.Shard, s.Seqno)
+	}
+	b.Shards = shards
+	b.Transactions = 0
+	for _, sh := range shards {
+		b.Transactions += uint64(sh.Transactions)
+	}
+	return i.Add(b)
+}
+
+func (i *Index) Close() error {
+	return i.log.Close()
+}
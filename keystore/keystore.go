@@ -0,0 +1,221 @@
+// Package keystore keeps the mnemonics of custodial wallets encrypted at
+// rest. The key is derived from a passphrase with scrypt and every mnemonic
+// is sealed with AES-GCM, bound to the id of its wallet.
+//
+// Errors wrap the chain package sentinels, so handlers map them to statuses
+// the same way as liteserver errors.
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/store"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrPassphrase is returned by Open when the passphrase doesn't decrypt the
+// existing keystore.
+var ErrPassphrase = errors.New("wrong keystore passphrase")
+
+// scrypt parameters of new keystores, existing ones keep theirs.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	keyLen  = 32
+)
+
+// checkText is sealed with the key when the keystore is created, so a wrong
+// passphrase is detected on Open instead of on the first transfer.
+const checkText = "tonindexer keystore"
+
+// Wallet is what is known about a stored wallet without decrypting it.
+type Wallet struct {
+	ID        string    `json:"id"`
+	Address   string    `json:"address"`
+	Label     string    `json:"label,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Secret is what is encrypted for a wallet.
+type Secret struct {
+	Mnemonic []string `json:"mnemonic"`
+	// Password of the mnemonic, usually empty.
+	Password string `json:"password,omitempty"`
+}
+
+type entry struct {
+	Wallet
+	Nonce  []byte `json:"nonce"`
+	Sealed []byte `json:"sealed"`
+}
+
+type kdf struct {
+	Salt []byte `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+}
+
+// state is everything persisted between restarts.
+type state struct {
+	KDF        *kdf              `json:"kdf"`
+	CheckNonce []byte            `json:"check_nonce"`
+	Check      []byte            `json:"check"`
+	Wallets    map[string]*entry `json:"wallets"`
+}
+
+type Store struct {
+	file *store.File
+	aead cipher.AEAD
+
+	mx sync.Mutex
+	st state
+}
+
+// Open loads the keystore from dir, creating it on first use.
+func Open(dir, passphrase string) (*Store, error) {
+	if passphrase == "" {
+		return nil, errors.New("keystore passphrase is empty")
+	}
+	file, err := store.Open(dir, "keystore.json")
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{file: file, st: state{Wallets: map[string]*entry{}}}
+	if err = file.Load(&s.st); err != nil {
+		return nil, fmt.Errorf("load keystore: %w", err)
+	}
+
+	created := s.st.KDF == nil
+	if created {
+		salt := make([]byte, 16)
+		if _, err = rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("keystore salt: %w", err)
+		}
+		s.st.KDF = &kdf{Salt: salt, N: scryptN, R: scryptR, P: scryptP}
+	}
+	key, err := scrypt.Key([]byte(passphrase), s.st.KDF.Salt, s.st.KDF.N, s.st.KDF.R, s.st.KDF.P, keyLen)
+	if err != nil {
+		return nil, fmt.Errorf("derive keystore key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("keystore cipher: %w", err)
+	}
+	if s.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, fmt.Errorf("keystore cipher: %w", err)
+	}
+
+	if created {
+		if s.st.CheckNonce, s.st.Check, err = s.seal([]byte(checkText), nil); err != nil {
+			return nil, err
+		}
+		if err = s.file.Save(&s.st); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	if _, err = s.aead.Open(nil, s.st.CheckNonce, s.st.Check, nil); err != nil {
+		return nil, ErrPassphrase
+	}
+	return s, nil
+}
+
+func (s *Store) seal(plain, ad []byte) (nonce, sealed []byte, err error) {
+	nonce = make([]byte, s.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, nil, fmt.Errorf("keystore nonce: %w", err)
+	}
+	return nonce, s.aead.Seal(nil, nonce, plain, ad), nil
+}
+
+// Add encrypts and stores the secret of the wallet at addr under a new id.
+func (s *Store) Add(addr, label string, secret Secret) (Wallet, error) {
+	plain, err := json.Marshal(secret)
+	if err != nil {
+		return Wallet{}, fmt.Errorf("encode wallet secret: %w", err)
+	}
+
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return Wallet{}, fmt.Errorf("wallet id: %w", err)
+	}
+	e := &entry{Wallet: Wallet{
+		ID:        hex.EncodeToString(id),
+		Address:   addr,
+		Label:     label,
+		CreatedAt: time.Now().UTC(),
+	}}
+	if e.Nonce, e.Sealed, err = s.seal(plain, []byte(e.ID)); err != nil {
+		return Wallet{}, err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.st.Wallets[e.ID] = e
+	if err = s.file.Save(&s.st); err != nil {
+		delete(s.st.Wallets, e.ID)
+		return Wallet{}, err
+	}
+	return e.Wallet, nil
+}
+
+func (s *Store) Get(id string) (Wallet, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	e, ok := s.st.Wallets[id]
+	if !ok {
+		return Wallet{}, fmt.Errorf("%w: wallet %q", chain.ErrNotFound, id)
+	}
+	return e.Wallet, nil
+}
+
+// List returns all stored wallets, oldest first.
+func (s *Store) List() []Wallet {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	res := make([]Wallet, 0, len(s.st.Wallets))
+	for _, e := range s.st.Wallets {
+		res = append(res, e.Wallet)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].CreatedAt.Equal(res[j].CreatedAt) {
+			return res[i].CreatedAt.Before(res[j].CreatedAt)
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// Secret decrypts the secret of wallet id. Callers must not keep or return
+// it unless exporting was explicitly authorized.
+func (s *Store) Secret(id string) (Secret, error) {
+	s.mx.Lock()
+	e, ok := s.st.Wallets[id]
+	s.mx.Unlock()
+	if !ok {
+		return Secret{}, fmt.Errorf("%w: wallet %q", chain.ErrNotFound, id)
+	}
+	plain, err := s.aead.Open(nil, e.Nonce, e.Sealed, []byte(e.ID))
+	if err != nil {
+		return Secret{}, fmt.Errorf("decrypt wallet %q: %w", id, err)
+	}
+	var secret Secret
+	if json.Unmarshal(plain, &secret) != nil {
+		// wallets stored before passwords were supported hold just the words
+		secret = Secret{Mnemonic: strings.Fields(string(plain))}
+	}
+	return secret, nil
+}
@@ -0,0 +1,139 @@
+package keystore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/FishDontExist/TONindexer/chain"
+)
+
+var testSecret = Secret{
+	Mnemonic: strings.Fields("material today hollow size despair face expect fruit fantasy screen account buffalo"),
+	Password: "secret",
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := s.Add("EQaddr", "hot", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Secret(w.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, testSecret) {
+		t.Errorf("Secret() = %+v, want %+v", got, testSecret)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "keystore.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, word := range testSecret.Mnemonic {
+		if strings.Contains(string(data), word) {
+			t.Fatalf("keystore file contains the mnemonic word %q", word)
+		}
+	}
+
+	reopened, err := Open(dir, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err = reopened.Secret(w.ID); err != nil || !reflect.DeepEqual(got, testSecret) {
+		t.Errorf("Secret() after reopening = %+v, %v", got, err)
+	}
+	if wallets := reopened.List(); len(wallets) != 1 || wallets[0] != w {
+		t.Errorf("List() after reopening = %+v, want [%+v]", wallets, w)
+	}
+}
+
+func TestWrongPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Open(dir, "passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir, "other"); !errors.Is(err, ErrPassphrase) {
+		t.Errorf("Open() with another passphrase error = %v, want ErrPassphrase", err)
+	}
+	if _, err := Open(dir, ""); err == nil {
+		t.Error("Open() with an empty passphrase succeeded")
+	}
+}
+
+func TestTamper(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(a, b *entry)
+	}{
+		{name: "flipped ciphertext bit", tamper: func(a, _ *entry) { a.Sealed[0] ^= 1 }},
+		{name: "flipped tag bit", tamper: func(a, _ *entry) { a.Sealed[len(a.Sealed)-1] ^= 1 }},
+		{name: "flipped nonce bit", tamper: func(a, _ *entry) { a.Nonce[0] ^= 1 }},
+		{name: "truncated", tamper: func(a, _ *entry) { a.Sealed = a.Sealed[:len(a.Sealed)-1] }},
+		{name: "secret of another wallet", tamper: func(a, b *entry) {
+			// sealed for b's id, it must not open as a's
+			a.Nonce, a.Sealed = b.Nonce, b.Sealed
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Open(t.TempDir(), "passphrase")
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, err := s.Add("EQa", "", testSecret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := s.Add("EQb", "", Secret{Mnemonic: []string{"other"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(s.st.Wallets[a.ID], s.st.Wallets[b.ID])
+			if got, err := s.Secret(a.ID); err == nil {
+				t.Errorf("Secret() of a tampered wallet = %+v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestUnknownWallet(t *testing.T) {
+	s, err := Open(t.TempDir(), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Secret("missing"); !errors.Is(err, chain.ErrNotFound) {
+		t.Errorf("Secret() error = %v, want ErrNotFound", err)
+	}
+	if _, err = s.Get("missing"); !errors.Is(err, chain.ErrNotFound) {
+		t.Errorf("Get() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLegacySecret(t *testing.T) {
+	s, err := Open(t.TempDir(), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// wallets stored before passwords were supported sealed the bare words
+	e := &entry{Wallet: Wallet{ID: "legacy"}}
+	if e.Nonce, e.Sealed, err = s.seal([]byte(strings.Join(testSecret.Mnemonic, " ")), []byte(e.ID)); err != nil {
+		t.Fatal(err)
+	}
+	s.st.Wallets[e.ID] = e
+	got, err := s.Secret(e.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Secret{Mnemonic: testSecret.Mnemonic}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Secret() = %+v, want %+v", got, want)
+	}
+}
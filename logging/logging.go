@@ -0,0 +1,52 @@
+// Package logging builds the service logger. The level is global, so it can
+// be changed at runtime for every component at once.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/rs/zerolog"
+)
+
+// New returns the logger writing to stderr in the configured format.
+func New(cfg config.LogConfig) (zerolog.Logger, error) {
+	if err := SetLevel(cfg.Level); err != nil {
+		return zerolog.Logger{}, err
+	}
+	var w io.Writer = os.Stderr
+	if cfg.Format == "console" {
+		w = zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339}
+	}
+	return zerolog.New(w).With().Timestamp().Logger(), nil
+}
+
+// Level returns the current level name.
+func Level() string {
+	return zerolog.GlobalLevel().String()
+}
+
+func SetLevel(level string) error {
+	l, err := zerolog.ParseLevel(level)
+	if err == nil && level == "" {
+		err = fmt.Errorf("empty log level")
+	}
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(l)
+	return nil
+}
+
+// From returns the logger carried by ctx, e.g. one with the request id of
+// an HTTP request, or fallback when there is none.
+func From(ctx context.Context, fallback *zerolog.Logger) *zerolog.Logger {
+	if lg := zerolog.Ctx(ctx); lg.GetLevel() != zerolog.Disabled {
+		return lg
+	}
+	return fallback
+}
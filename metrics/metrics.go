@@ -0,0 +1,84 @@
+// Package metrics holds the Prometheus collectors of the service. They are
+// registered with the default registry and served on /metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "tonindexer"
+
+// Scanner.
+var (
+	MasterLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: "scanner", Name: "master_lag_blocks",
+		Help: "Masterchain blocks the scanner is behind the chain.",
+	})
+	MasterBlocks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "scanner", Name: "master_blocks_total",
+		Help: "Masterchain blocks processed.",
+	})
+	ShardBlocks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "scanner", Name: "shard_blocks_total",
+		Help: "Shard blocks processed.",
+	})
+	Transactions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "scanner", Name: "transactions_total",
+		Help: "Transactions found in processed shard blocks.",
+	})
+	TaskPoolDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: "scanner", Name: "task_pool_depth",
+		Help: "Account fetch tasks waiting for a worker.",
+	})
+	Workers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: "scanner", Name: "workers",
+		Help: "Account fetch workers started.",
+	})
+	WorkersBusy = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: "scanner", Name: "workers_busy",
+		Help: "Account fetch workers processing a task.",
+	})
+)
+
+// Retry queue, by task kind.
+var (
+	RetryTasks = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: "retry", Name: "tasks",
+		Help: "Queued tasks, state is pending or failed.",
+	}, []string{"kind", "state"})
+	RetryAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "retry", Name: "failed_attempts_total",
+		Help: "Failed attempts of queued tasks.",
+	}, []string{"kind"})
+	RetryGaveUp = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "retry", Name: "gave_up_total",
+		Help: "Tasks that failed permanently after the last attempt.",
+	}, []string{"kind"})
+)
+
+// Liteserver requests, by node id as assigned by the connection pool.
+var (
+	LiteserverRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "liteserver", Name: "requests_total",
+		Help: "Liteserver requests, result is ok, error or ls_error.",
+	}, []string{"node", "result"})
+	LiteserverDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Subsystem: "liteserver", Name: "request_duration_seconds",
+		Help:    "Liteserver request latency.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"node"})
+)
+
+// HTTP API, by route template.
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "http", Name: "requests_total",
+		Help: "HTTP requests served.",
+	}, []string{"route", "method", "code"})
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Subsystem: "http", Name: "request_duration_seconds",
+		Help:    "HTTP request latency, streams count until they close.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+)
@@ -0,0 +1,186 @@
+// Package mnemonic generates and checks TON wallet mnemonics and derives
+// keys and wallet addresses from them without network access.
+//
+// TON mnemonics use the BIP-39 word list but not its checksum: phrases are
+// checked like the TON reference implementation does, on the HMAC of the
+// words with and without the password. Errors never include the words of a
+// phrase, as they end up in logs.
+package mnemonic
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Size is the word count of generated phrases.
+const Size = 24
+
+// ErrInvalid is wrapped by every error about a phrase that can't be used.
+var ErrInvalid = errors.New("invalid mnemonic")
+
+// Seed checks and key derivation of the TON reference implementation,
+// which wallets import phrases with. tonutils-go checks a password phrase
+// with the fast check alone, so it rejects phrases those wallets accept.
+const (
+	keySalt        = "TON default seed"
+	basicSalt      = "TON seed version"
+	passwordSalt   = "TON fast seed version"
+	keyRounds      = 100000
+	basicRounds    = 100000 / 256
+	passwordRounds = 1
+)
+
+// New returns a random valid phrase of Size words. A phrase generated with
+// a password is only valid together with it.
+func New(password string) []string {
+	words := make([]string, Size)
+	n := big.NewInt(int64(len(wordList)))
+	for {
+		for i := range words {
+			x, err := rand.Int(rand.Reader, n)
+			if err != nil {
+				panic(fmt.Sprintf("mnemonic: read random: %v", err))
+			}
+			words[i] = wordList[x.Int64()]
+		}
+		if check(words, password) == nil {
+			return words
+		}
+	}
+}
+
+// Validate checks that words form a TON mnemonic for password.
+func Validate(words []string, password string) error {
+	if len(words) < 12 || len(words) > Size {
+		return fmt.Errorf("%w: got %d words, want 12 to %d", ErrInvalid, len(words), Size)
+	}
+	for i, w := range words {
+		if !known[w] {
+			return fmt.Errorf("%w: word %d is not in the word list", ErrInvalid, i+1)
+		}
+	}
+	return check(words, password)
+}
+
+// check runs the seed checks of the reference implementation: a phrase used
+// with a password must need one, and any phrase must pass the basic check
+// on its HMAC with the password.
+func check(words []string, password string) error {
+	if password != "" && !passwordNeeded(words) {
+		return fmt.Errorf("%w: checksum mismatch, the phrase takes no password", ErrInvalid)
+	}
+	if !basicSeed(entropy(words, password)) {
+		if password == "" {
+			return fmt.Errorf("%w: checksum mismatch, the phrase may need a password", ErrInvalid)
+		}
+		return fmt.Errorf("%w: checksum mismatch, wrong password or words", ErrInvalid)
+	}
+	return nil
+}
+
+// passwordNeeded reports whether words only form a mnemonic together with
+// a password.
+func passwordNeeded(words []string) bool {
+	e := entropy(words, "")
+	return passwordSeed(e) && !basicSeed(e)
+}
+
+func entropy(words []string, password string) []byte {
+	mac := hmac.New(sha512.New, []byte(strings.Join(words, " ")))
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+func basicSeed(entropy []byte) bool {
+	return pbkdf2.Key(entropy, []byte(basicSalt), basicRounds, 1, sha512.New)[0] == 0
+}
+
+func passwordSeed(entropy []byte) bool {
+	return pbkdf2.Key(entropy, []byte(passwordSalt), passwordRounds, 1, sha512.New)[0] == 1
+}
+
+// PrivateKey derives the wallet key of a valid phrase.
+func PrivateKey(words []string, password string) (ed25519.PrivateKey, error) {
+	if err := Validate(words, password); err != nil {
+		return nil, err
+	}
+	seed := pbkdf2.Key(entropy(words, password), []byte(keySalt), keyRounds, ed25519.SeedSize, sha512.New)
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// Params select the wallet contract an address is derived for.
+type Params struct {
+	// Version is one of Versions.
+	Version string
+	// GlobalID is the network id, used by v5r1 only.
+	GlobalID int32
+	// Subwallet overrides the default subwallet id of the version.
+	Subwallet *uint32
+	// TTL is the message ttl in seconds of highload_v3, which is part of
+	// its address.
+	TTL uint32
+}
+
+// Versions are the wallet versions Address accepts.
+var Versions = []string{
+	"v1r1", "v1r2", "v1r3", "v2r1", "v2r2", "v3r1", "v3r2", "v4r1", "v4r2",
+	"v5r1beta", "v5r1", "highload_v2r2", "highload_v3",
+}
+
+var versions = map[string]wallet.Version{
+	"v1r1": wallet.V1R1, "v1r2": wallet.V1R2, "v1r3": wallet.V1R3,
+	"v2r1": wallet.V2R1, "v2r2": wallet.V2R2,
+	"v3r1": wallet.V3R1, "v3r2": wallet.V3R2,
+	"v4r1": wallet.V4R1, "v4r2": wallet.V4R2,
+	"highload_v2r2": wallet.HighloadV2R2,
+}
+
+// Config returns the tonutils-go version config for p and the default
+// subwallet id of that version.
+func Config(p Params) (wallet.VersionConfig, uint32, error) {
+	switch p.Version {
+	case "v5r1beta":
+		return wallet.ConfigV5R1Beta{NetworkGlobalID: p.GlobalID}, 0, nil
+	case "v5r1":
+		return wallet.ConfigV5R1Final{NetworkGlobalID: p.GlobalID}, 0, nil
+	case "highload_v3":
+		if p.TTL <= 5 || p.TTL >= 1<<22 {
+			return nil, 0, fmt.Errorf("highload_v3 ttl must be between 6 and %d seconds", 1<<22-1)
+		}
+		return wallet.ConfigHighloadV3{MessageTTL: p.TTL}, wallet.DefaultSubwallet, nil
+	}
+	if v, ok := versions[p.Version]; ok {
+		return v, wallet.DefaultSubwallet, nil
+	}
+	return nil, 0, fmt.Errorf("unknown wallet version %q, want one of %s", p.Version, strings.Join(Versions, ", "))
+}
+
+// Address derives the address of the wallet of a valid phrase.
+func Address(words []string, password string, p Params) (*address.Address, error) {
+	cfg, subwallet, err := Config(p)
+	if err != nil {
+		return nil, err
+	}
+	key, err := PrivateKey(words, password)
+	if err != nil {
+		return nil, err
+	}
+	if p.Subwallet != nil {
+		subwallet = *p.Subwallet
+	}
+	addr, err := wallet.AddressFromPubKey(key.Public().(ed25519.PublicKey), cfg, subwallet)
+	if err != nil {
+		return nil, fmt.Errorf("derive address: %w", err)
+	}
+	return addr, nil
+}
@@ -0,0 +1,94 @@
+package mnemonic
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/xssnick/tonutils-go/ton/wallet"
+)
+
+// Phrases generated by New, without a password and with "secret".
+// fastPhrase passes the only check tonutils-go makes with "secret", but
+// doesn't need a password.
+const (
+	plainPhrase    = "material today hollow size despair face expect fruit fantasy screen account buffalo random grass pelican library nuclear helmet giant spatial digital tired print prefer"
+	passwordPhrase = "talent box celery index never body shoe frequent poverty age husband sphere monitor recall already warrior all anger tiny sense street foster divert reveal"
+	fastPhrase     = "stand forward vivid conduct install horror uphold trick develop away exit local already style original ramp floor alter ready grief since nasty wise sleep"
+)
+
+func TestValidate(t *testing.T) {
+	plain := strings.Fields(plainPhrase)
+	protected := strings.Fields(passwordPhrase)
+	swapped := append([]string(nil), plain...)
+	swapped[0], swapped[1] = swapped[1], swapped[0]
+	unknown := append([]string(nil), plain...)
+	unknown[5] = "tonindexer"
+
+	tests := []struct {
+		name     string
+		words    []string
+		password string
+		wantErr  bool
+	}{
+		{name: "no password", words: plain},
+		{name: "password", words: protected, password: "secret"},
+		{name: "password on a plain phrase", words: plain, password: "secret", wantErr: true},
+		{name: "missing password", words: protected, wantErr: true},
+		{name: "wrong password", words: protected, password: "other", wantErr: true},
+		{name: "password not needed", words: strings.Fields(fastPhrase), password: "secret", wantErr: true},
+		{name: "words swapped", words: swapped, wantErr: true},
+		{name: "unknown word", words: unknown, wantErr: true},
+		{name: "too few words", words: plain[:11], wantErr: true},
+		{name: "too many words", words: append(append([]string(nil), plain...), "abandon"), wantErr: true},
+		{name: "empty", words: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.words, tt.password)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalid) {
+					t.Fatalf("Validate() error = %v, want ErrInvalid", err)
+				}
+				for _, w := range tt.words {
+					if strings.Contains(err.Error(), w) {
+						t.Errorf("Validate() error %q contains a word of the phrase", err)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestNewValidates(t *testing.T) {
+	for _, password := range []string{"", "secret"} {
+		words := New(password)
+		if len(words) != Size {
+			t.Errorf("New(%q) has %d words, want %d", password, len(words), Size)
+		}
+		if err := Validate(words, password); err != nil {
+			t.Errorf("Validate(New(%q)) error = %v", password, err)
+		}
+	}
+}
+
+func TestPrivateKey(t *testing.T) {
+	words := strings.Fields(plainPhrase)
+	key, err := PrivateKey(words, "")
+	if err != nil {
+		t.Fatalf("PrivateKey() error = %v", err)
+	}
+	// wallets created before keys were derived here used tonutils-go
+	w, err := wallet.FromSeed(nil, words, wallet.V3R2)
+	if err != nil {
+		t.Fatalf("FromSeed() error = %v", err)
+	}
+	if !bytes.Equal(key, w.PrivateKey()) {
+		t.Error("PrivateKey() differs from the tonutils-go key")
+	}
+}
@@ -0,0 +1,27 @@
+package mnemonic
+
+import (
+	_ "embed"
+	"strings"
+)
+
+// words.txt is the english BIP-39 list, which TON mnemonics are drawn from,
+// as quoted comma separated words.
+//
+//go:embed words.txt
+var wordsFile string
+
+var wordList = strings.FieldsFunc(wordsFile, func(r rune) bool {
+	return r == '"' || r == ',' || r == ' ' || r == '\n' || r == '\r'
+})
+
+var known = func() map[string]bool {
+	if len(wordList) != 2048 {
+		panic("mnemonic: word list must have 2048 words")
+	}
+	m := make(map[string]bool, len(wordList))
+	for _, w := range wordList {
+		m[w] = true
+	}
+	return m
+}()
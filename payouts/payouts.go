@@ -0,0 +1,660 @@
+// Package payouts sends batches of TON transfers from a highload v3 wallet
+// and follows every transfer on chain through the scanner.
+//
+// A batch is split into chunks, each sent as one external message with its
+// own query id. A chunk that wasn't executed once its ttl passed can never
+// be. It is sent again with a new query id only when the wallet state and
+// its transactions since the chunk was created both show it wasn't, so
+// nothing is paid twice. When that can't be shown the chunk waits for
+// someone to look.
+//
+// Errors wrap the chain package sentinels, so handlers map them to statuses
+// the same way as liteserver errors.
+package payouts
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/idempotency"
+	"github.com/FishDontExist/TONindexer/keystore"
+	"github.com/FishDontExist/TONindexer/mnemonic"
+	"github.com/FishDontExist/TONindexer/store"
+	"github.com/rs/zerolog"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+// Item statuses.
+const (
+	// StatusQueued items wait for their chunk to be sent.
+	StatusQueued = "queued"
+	// StatusSubmitted items were sent to a liteserver but not seen on chain.
+	StatusSubmitted = "submitted"
+	// StatusSent items left the highload wallet.
+	StatusSent = "sent"
+	// StatusBounced items came back from the destination.
+	StatusBounced = "bounced"
+	// StatusFailed items were executed but not sent by the highload wallet,
+	// the error of their chunk tells why.
+	StatusFailed = "failed"
+	// StatusExpired items were never executed, after all attempts.
+	StatusExpired = "expired"
+	// StatusReview items may or may not have been sent, their chunk is
+	// neither resent nor followed until someone checked the wallet.
+	StatusReview = "review"
+)
+
+// Chunk statuses, also StatusQueued, StatusSubmitted, StatusExpired and
+// StatusReview.
+const (
+	// StatusProcessed chunks were executed by the highload wallet.
+	StatusProcessed = "processed"
+)
+
+// createdAtSkew backdates queries, liteservers reject external messages
+// created after their last block.
+const createdAtSkew = 30 * time.Second
+
+// expiryGrace is waited after the ttl of a query before checking it, so the
+// check sees a block past its expiry.
+const expiryGrace = time.Minute
+
+// queryIDs is the range of highload v3 query ids.
+const queryIDs = 1 << 23
+
+// seenLimit is how many wallet transactions are looked through for an
+// expired chunk before giving up on proving it wasn't executed.
+const seenLimit = 10000
+
+// Request is one transfer of a batch. Amount is a decimal string in Unit,
+// ton when empty or nanoton.
+type Request struct {
+	To      string `json:"to"`
+	Amount  string `json:"amount"`
+	Unit    string `json:"unit,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type Item struct {
+	To string `json:"to"`
+	// Amount is in nanotons.
+	Amount  string `json:"amount"`
+	Comment string `json:"comment,omitempty"`
+	Status  string `json:"status"`
+	Chunk   int    `json:"chunk"`
+	// TxHash and LT are of the highload wallet transaction that sent it.
+	TxHash string `json:"tx_hash,omitempty"`
+	LT     uint64 `json:"lt,omitempty"`
+	// BounceTxHash is of the transaction that received it back.
+	BounceTxHash string `json:"bounce_tx_hash,omitempty"`
+
+	raw      string
+	amount   *big.Int
+	bodyHash string
+	// bounced is what of the body a bounce of the transfer carries.
+	bounced []byte
+}
+
+type Chunk struct {
+	QueryID   uint32 `json:"query_id"`
+	CreatedAt int64  `json:"created_at"`
+	Attempts  int    `json:"attempts"`
+	Status    string `json:"status"`
+	// MsgHash is of the body of the external message.
+	MsgHash string `json:"msg_hash,omitempty"`
+	// ActionsLT is of the message with the transfers of the chunk the
+	// wallet sent itself and hasn't received yet.
+	ActionsLT uint64 `json:"actions_lt,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type Batch struct {
+	ID             string    `json:"id"`
+	IdempotencyKey string    `json:"idempotency_key,omitempty"`
+	RequestHash    string    `json:"request_hash"`
+	CreatedAt      time.Time `json:"created_at"`
+	Items          []Item    `json:"items"`
+	Chunks         []Chunk   `json:"chunks"`
+}
+
+// state is everything persisted between restarts.
+type state struct {
+	NextQueryID uint32            `json:"next_query_id"`
+	Batches     map[string]*Batch `json:"batches"`
+	// Keys maps idempotency keys to batch ids.
+	Keys map[string]string `json:"keys"`
+}
+
+type Service struct {
+	cfg     config.PayoutConfig
+	ln      *chain.LiteClient
+	key     ed25519.PrivateKey
+	ttl     uint32
+	address string
+	raw     string
+	timeout time.Duration
+	file    *store.File
+	log     zerolog.Logger
+
+	mx sync.Mutex
+	st state
+}
+
+func New(cfg *config.Config, keys *keystore.Store, ln *chain.LiteClient, lg zerolog.Logger) (*Service, error) {
+	secret, err := keys.Secret(cfg.Payouts.Wallet)
+	if err != nil {
+		return nil, fmt.Errorf("payouts wallet: %w", err)
+	}
+	key, err := mnemonic.PrivateKey(secret.Mnemonic, secret.Password)
+	if err != nil {
+		return nil, fmt.Errorf("payouts wallet: %w", err)
+	}
+	s := &Service{
+		cfg:     cfg.Payouts,
+		ln:      ln,
+		key:     key,
+		ttl:     uint32(cfg.Payouts.MessageTTL.Seconds()),
+		timeout: cfg.Payouts.Timeout.Duration,
+		log:     lg.With().Str("component", "payouts").Logger(),
+		st:      state{Batches: map[string]*Batch{}, Keys: map[string]string{}},
+	}
+	if s.address, err = ln.HighloadAddress(key, s.ttl); err != nil {
+		return nil, err
+	}
+	addr, err := address.ParseAddr(s.address)
+	if err != nil {
+		return nil, err
+	}
+	s.raw = chain.RawAddr(addr)
+
+	if s.file, err = store.Open(cfg.Storage.Dir, "payouts.json"); err != nil {
+		return nil, err
+	}
+	if err = s.file.Load(&s.st); err != nil {
+		return nil, fmt.Errorf("load payouts: %w", err)
+	}
+	for _, b := range s.st.Batches {
+		for i := range b.Items {
+			if err = b.Items[i].prepare(); err != nil {
+				return nil, fmt.Errorf("load payouts: batch %s: %w", b.ID, err)
+			}
+		}
+	}
+	s.log.Info().Str("wallet", s.address).Msg("payouts enabled")
+	return s, nil
+}
+
+// prepare sets what outgoing messages are matched by.
+func (it *Item) prepare() error {
+	addr, err := address.ParseAddr(it.To)
+	if err != nil {
+		return fmt.Errorf("%w %q: %w", chain.ErrBadAddress, it.To, err)
+	}
+	it.raw = chain.RawAddr(addr)
+	amount, ok := new(big.Int).SetString(it.Amount, 10)
+	if !ok || amount.Sign() <= 0 {
+		return fmt.Errorf("%w: amount %q", chain.ErrInvalidInput, it.Amount)
+	}
+	it.amount = amount
+	body, err := chain.HighloadBody(it.Comment)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		it.bodyHash = hex.EncodeToString(body.Hash())
+		s := body.BeginParse()
+		it.bounced = s.MustLoadSlice(min(s.BitsLeft(), bounceBits))
+	}
+	return nil
+}
+
+// bounceBits is how much of the body of a message its bounce carries.
+const bounceBits = 256
+
+// bouncedBody returns what of the body of the bounced message the body of
+// a bounce carries, false when it isn't one.
+func bouncedBody(body *cell.Cell) ([]byte, bool) {
+	if body == nil {
+		return nil, false
+	}
+	s := body.BeginParse()
+	if op, err := s.LoadUInt(32); err != nil || op != 0xffffffff {
+		return nil, false
+	}
+	data, err := s.LoadSlice(min(s.BitsLeft(), bounceBits))
+	return data, err == nil
+}
+
+// Address is the highload wallet payouts are sent from, it must be funded.
+func (s *Service) Address() string {
+	return s.address
+}
+
+// save must be called with mx held.
+func (s *Service) save() {
+	if err := s.file.Save(&s.st); err != nil {
+		s.log.Error().Err(err).Msg("save payouts")
+	}
+}
+
+// Submit queues a batch. A batch submitted before with the same idempotency
+// key is returned instead, with true, if the requests are the same.
+func (s *Service) Submit(idempotencyKey string, reqs []Request) (Batch, bool, error) {
+	if len(reqs) == 0 || len(reqs) > s.cfg.MaxItems {
+		return Batch{}, false, fmt.Errorf("%w: a batch has 1 to %d items", chain.ErrInvalidInput, s.cfg.MaxItems)
+	}
+	data, err := json.Marshal(reqs)
+	if err != nil {
+		return Batch{}, false, fmt.Errorf("encode batch: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	reqHash := hex.EncodeToString(sum[:])
+
+	items := make([]Item, len(reqs))
+	for i, r := range reqs {
+		amount, err := chain.ParseTON(r.Amount, r.Unit)
+		if err != nil {
+			return Batch{}, false, fmt.Errorf("item %d: %w", i, err)
+		}
+		items[i] = Item{
+			To:      r.To,
+			Amount:  amount.Nano().String(),
+			Comment: r.Comment,
+			Status:  StatusQueued,
+			Chunk:   i / s.cfg.ChunkSize,
+		}
+		if err = items[i].prepare(); err != nil {
+			return Batch{}, false, fmt.Errorf("item %d: %w", i, err)
+		}
+	}
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return Batch{}, false, fmt.Errorf("batch id: %w", err)
+	}
+	b := &Batch{
+		ID:             hex.EncodeToString(id),
+		IdempotencyKey: idempotencyKey,
+		RequestHash:    reqHash,
+		CreatedAt:      time.Now().UTC(),
+		Items:          items,
+		Chunks:         make([]Chunk, (len(items)+s.cfg.ChunkSize-1)/s.cfg.ChunkSize),
+	}
+	for i := range b.Chunks {
+		b.Chunks[i].Status = StatusQueued
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if idempotencyKey != "" {
+		if prev, ok := s.st.Keys[idempotencyKey]; ok {
+			p := s.st.Batches[prev]
+			if p.RequestHash != reqHash {
+				return Batch{}, false, fmt.Errorf("batch %s: %w", prev, idempotency.ErrConflict)
+			}
+			return copyBatch(p), true, nil
+		}
+		s.st.Keys[idempotencyKey] = b.ID
+	}
+	s.st.Batches[b.ID] = b
+	s.save()
+	return copyBatch(b), false, nil
+}
+
+func copyBatch(b *Batch) Batch {
+	c := *b
+	c.Items = append([]Item(nil), b.Items...)
+	c.Chunks = append([]Chunk(nil), b.Chunks...)
+	return c
+}
+
+func (s *Service) Get(id string) (Batch, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	b, ok := s.st.Batches[id]
+	if !ok {
+		return Batch{}, fmt.Errorf("%w: batch %q", chain.ErrNotFound, id)
+	}
+	return copyBatch(b), nil
+}
+
+// Run sends queued chunks and checks expired ones until ctx is done.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.sendQueued(ctx)
+		s.checkExpired(ctx)
+	}
+}
+
+type chunkRef struct {
+	batch *Batch
+	chunk int
+}
+
+// setStatus sets the status of the chunk and its items that are still in
+// from, it must be called with mx held.
+func (c chunkRef) setStatus(status string, from ...string) {
+	c.batch.Chunks[c.chunk].Status = status
+	for i := range c.batch.Items {
+		it := &c.batch.Items[i]
+		if it.Chunk != c.chunk {
+			continue
+		}
+		for _, f := range from {
+			if it.Status == f {
+				it.Status = status
+			}
+		}
+	}
+}
+
+func (s *Service) chunks(status string) []chunkRef {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	var res []chunkRef
+	for _, b := range s.st.Batches {
+		for i, c := range b.Chunks {
+			if c.Status == status {
+				res = append(res, chunkRef{batch: b, chunk: i})
+			}
+		}
+	}
+	return res
+}
+
+func (s *Service) sendQueued(ctx context.Context) {
+	for _, ref := range s.chunks(StatusQueued) {
+		// the query is recorded before sending, so after a crash it is
+		// checked for expiry rather than sent again under a new id
+		s.mx.Lock()
+		c := &ref.batch.Chunks[ref.chunk]
+		c.QueryID = s.st.NextQueryID
+		s.st.NextQueryID = (s.st.NextQueryID + 1) % queryIDs
+		c.CreatedAt = time.Now().Add(-createdAtSkew).Unix()
+		c.Attempts++
+		c.Error = ""
+		ref.setStatus(StatusSubmitted, StatusQueued)
+		q := chain.HighloadQuery{ID: c.QueryID, CreatedAt: c.CreatedAt, TTL: s.ttl}
+		var msgs []chain.HighloadMessage
+		for _, it := range ref.batch.Items {
+			if it.Chunk == ref.chunk {
+				msgs = append(msgs, chain.HighloadMessage{To: it.To, Amount: tlb.FromNanoTON(it.amount), Comment: it.Comment})
+			}
+		}
+		s.save()
+		s.mx.Unlock()
+
+		sctx, cancel := context.WithTimeout(ctx, s.timeout)
+		hash, err := s.ln.HighloadSend(sctx, s.key, q, msgs)
+		cancel()
+
+		s.mx.Lock()
+		if err != nil {
+			// the message may still have reached the chain, the expiry check
+			// tells
+			c.Error = err.Error()
+			s.log.Warn().Err(err).Str("batch", ref.batch.ID).Int("chunk", ref.chunk).Uint32("query_id", q.ID).Msg("send payout chunk")
+		} else {
+			c.MsgHash = hex.EncodeToString(hash)
+		}
+		s.save()
+		s.mx.Unlock()
+	}
+}
+
+func (s *Service) checkExpired(ctx context.Context) {
+	for _, ref := range s.chunks(StatusSubmitted) {
+		s.mx.Lock()
+		c := ref.batch.Chunks[ref.chunk]
+		s.mx.Unlock()
+		if time.Now().Before(time.Unix(c.CreatedAt, 0).Add(s.cfg.MessageTTL.Duration + expiryGrace)) {
+			continue
+		}
+
+		lg := s.log.With().Str("batch", ref.batch.ID).Int("chunk", ref.chunk).Uint32("query_id", c.QueryID).Logger()
+		cctx, cancel := context.WithTimeout(ctx, s.timeout)
+		processed, err := s.ln.HighloadProcessed(cctx, s.key, s.ttl, c.QueryID)
+		cancel()
+		if err != nil {
+			lg.Warn().Err(err).Msg("check payout chunk")
+			continue
+		}
+		complete := true
+		if !processed {
+			// the query id may have been cleaned up in the wallet, its
+			// transactions tell for sure
+			msgHash, _ := hex.DecodeString(c.MsgHash)
+			q := chain.HighloadQuery{ID: c.QueryID, CreatedAt: c.CreatedAt, TTL: s.ttl}
+			cctx, cancel := context.WithTimeout(ctx, s.timeout)
+			processed, complete, err = s.ln.HighloadSeen(cctx, s.key, q, msgHash, seenLimit)
+			cancel()
+			if err != nil {
+				lg.Warn().Err(err).Msg("check payout chunk transactions")
+				continue
+			}
+		}
+
+		s.mx.Lock()
+		if ref.batch.Chunks[ref.chunk].Status != StatusSubmitted {
+			// the scanner saw it meanwhile
+			s.mx.Unlock()
+			continue
+		}
+		switch {
+		case processed:
+			ref.batch.Chunks[ref.chunk].Status = StatusProcessed
+		case !complete:
+			ref.setStatus(StatusReview, StatusSubmitted)
+			lg.Error().Int("looked_at", seenLimit).Msg("payout chunk needs review, can't tell whether it was executed")
+		case c.Attempts < s.cfg.MaxAttempts:
+			ref.setStatus(StatusQueued, StatusSubmitted)
+		default:
+			ref.setStatus(StatusExpired, StatusSubmitted)
+			lg.Error().Int("attempts", c.Attempts).Msg("payout chunk expired")
+		}
+		s.save()
+		s.mx.Unlock()
+	}
+}
+
+// HandleTransaction updates the items sent or bounced by tx, if it is a
+// transaction of the payouts wallet.
+func (s *Service) HandleTransaction(addr *address.Address, tx *tlb.Transaction) {
+	if chain.RawAddr(addr) != s.raw {
+		return
+	}
+	hash := hex.EncodeToString(tx.Hash)
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	changed := false
+	if ref, ok := s.executed(tx); ok {
+		if c := &ref.batch.Chunks[ref.chunk]; c.Status == StatusSubmitted || c.Status == StatusReview {
+			c.Status = StatusProcessed
+		}
+		s.ran(ref, tx)
+		changed = true
+	} else if ref, ok := s.actions(tx); ok {
+		s.ran(ref, tx)
+		changed = true
+	}
+	if tx.IO.In != nil && tx.IO.In.MsgType == tlb.MsgTypeInternal {
+		in := tx.IO.In.AsInternal()
+		if in.Bounced {
+			// bounces carry what is left of the amount and the start of the
+			// body, match the oldest transfer to the sender that could have
+			// produced it
+			data, _ := bouncedBody(in.Body)
+			if it := s.find(StatusSent, chain.RawAddr(in.SrcAddr), func(it *Item) bool {
+				return bytes.Equal(it.bounced, data) && it.amount.Cmp(in.Amount.Nano()) >= 0
+			}); it != nil {
+				it.Status, it.BounceTxHash = StatusBounced, hash
+				changed = true
+			}
+		}
+	}
+	if changed {
+		s.save()
+	}
+}
+
+// ran updates the items of the chunk sent by tx, which executed its
+// external message or received the actions the wallet sent itself for it.
+// When tx passes actions on to the wallet again the chunk waits for that
+// transaction, otherwise the items tx didn't send failed. It must be called
+// with mx held.
+func (s *Service) ran(ref chunkRef, tx *tlb.Transaction) {
+	hash := hex.EncodeToString(tx.Hash)
+	c := &ref.batch.Chunks[ref.chunk]
+	c.ActionsLT = 0
+	var out []tlb.Message
+	if tx.IO.Out != nil {
+		var err error
+		if out, err = tx.IO.Out.ToSlice(); err != nil {
+			s.log.Warn().Err(err).Str("tx", hash).Msg("load out messages")
+			return
+		}
+	}
+	for _, m := range out {
+		if m.MsgType != tlb.MsgTypeInternal {
+			continue
+		}
+		in := m.AsInternal()
+		if chain.HighloadActions(in) {
+			c.ActionsLT = in.CreatedLT
+			continue
+		}
+		// messages without a body load with an empty one
+		var body string
+		if in.Body != nil && (in.Body.BitsSize() > 0 || in.Body.RefsNum() > 0) {
+			body = hex.EncodeToString(in.Body.Hash())
+		}
+		// only the chunk of this message, identical transfers of other
+		// chunks are left to their own
+		if it := ref.find(chain.RawAddr(in.DstAddr), in.Amount.Nano(), body); it != nil {
+			it.Status, it.TxHash, it.LT = StatusSent, hash, tx.LT
+		}
+	}
+	if c.ActionsLT != 0 {
+		return
+	}
+	failed := 0
+	for i := range ref.batch.Items {
+		it := &ref.batch.Items[i]
+		if it.Chunk == ref.chunk && (it.Status == StatusSubmitted || it.Status == StatusReview) {
+			it.Status, it.TxHash, it.LT = StatusFailed, hash, tx.LT
+			failed++
+		}
+	}
+	if failed > 0 {
+		c.Error = actionsError(tx)
+		s.log.Error().Str("batch", ref.batch.ID).Int("chunk", ref.chunk).Str("tx", hash).Int("failed", failed).
+			Str("reason", c.Error).Msg("payout chunk transfers failed")
+	}
+}
+
+// actionsError tells why tx didn't send all the transfers it was given.
+func actionsError(tx *tlb.Transaction) string {
+	desc, ok := tx.Description.Description.(tlb.TransactionDescriptionOrdinary)
+	switch {
+	case !ok:
+		return "not an ordinary transaction"
+	case desc.ActionPhase == nil:
+		return "compute phase failed"
+	case !desc.ActionPhase.Success:
+		return fmt.Sprintf("action phase failed with result code %d", desc.ActionPhase.ResultCode)
+	default:
+		return fmt.Sprintf("%d actions skipped", desc.ActionPhase.SkippedActions)
+	}
+}
+
+// actions returns the chunk whose transfers the wallet sent itself in the
+// message tx received. It must be called with mx held.
+func (s *Service) actions(tx *tlb.Transaction) (chunkRef, bool) {
+	if tx.IO.In == nil || tx.IO.In.MsgType != tlb.MsgTypeInternal {
+		return chunkRef{}, false
+	}
+	in := tx.IO.In.AsInternal()
+	if !chain.HighloadActions(in) {
+		return chunkRef{}, false
+	}
+	for _, b := range s.st.Batches {
+		for i, c := range b.Chunks {
+			if c.ActionsLT != 0 && c.ActionsLT == in.CreatedLT {
+				return chunkRef{batch: b, chunk: i}, true
+			}
+		}
+	}
+	return chunkRef{}, false
+}
+
+// executed returns the chunk whose external message tx executed. It must be
+// called with mx held.
+func (s *Service) executed(tx *tlb.Transaction) (chunkRef, bool) {
+	if _, ok := chain.HighloadQueryOf(tx); !ok {
+		return chunkRef{}, false
+	}
+	for _, b := range s.st.Batches {
+		for i, c := range b.Chunks {
+			if c.CreatedAt == 0 {
+				continue
+			}
+			msgHash, _ := hex.DecodeString(c.MsgHash)
+			q := chain.HighloadQuery{ID: c.QueryID, CreatedAt: c.CreatedAt, TTL: s.ttl}
+			if chain.HighloadExecuted(tx, q, msgHash) {
+				return chunkRef{batch: b, chunk: i}, true
+			}
+		}
+	}
+	return chunkRef{}, false
+}
+
+// find returns the first item of the chunk to raw of amount and body hash
+// that isn't marked sent yet. It must be called with mx held.
+func (c chunkRef) find(raw string, amount *big.Int, bodyHash string) *Item {
+	for i := range c.batch.Items {
+		it := &c.batch.Items[i]
+		if it.Chunk == c.chunk && (it.Status == StatusSubmitted || it.Status == StatusReview) &&
+			it.raw == raw && it.amount.Cmp(amount) == 0 && it.bodyHash == bodyHash {
+			return it
+		}
+	}
+	return nil
+}
+
+// find returns the item in status to raw matching ok, the one sent first
+// for sent items. It must be called with mx held.
+func (s *Service) find(status, raw string, ok func(*Item) bool) *Item {
+	var res *Item
+	for _, b := range s.st.Batches {
+		for i := range b.Items {
+			it := &b.Items[i]
+			if it.Status != status || it.raw != raw || !ok(it) {
+				continue
+			}
+			if res == nil || it.LT < res.LT {
+				res = it
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,356 @@
+package payouts
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/store"
+	"github.com/rs/zerolog"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+const testTTL = 300
+
+// fixture is a payouts service with one batch of a single chunk, and the
+// highload wallet it is sent from.
+type fixture struct {
+	s     *Service
+	w     *wallet.Wallet
+	batch *Batch
+	lt    uint64
+}
+
+func newFixture(t *testing.T, reqs []Request) *fixture {
+	t.Helper()
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	q := chain.HighloadQuery{ID: 7, CreatedAt: 1700000000, TTL: testTTL}
+	w, err := wallet.FromPrivateKey(nil, key, wallet.ConfigHighloadV3{
+		MessageTTL: q.TTL,
+		MessageBuilder: func(context.Context, uint32) (uint32, int64, error) {
+			return q.ID, q.CreatedAt, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := store.Open(t.TempDir(), "payouts.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Batch{ID: "b", Chunks: []Chunk{{QueryID: q.ID, CreatedAt: q.CreatedAt, Attempts: 1, Status: StatusSubmitted}}}
+	for _, r := range reqs {
+		amount, err := chain.ParseTON(r.Amount, r.Unit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it := Item{To: r.To, Amount: amount.Nano().String(), Comment: r.Comment, Status: StatusSubmitted}
+		if err = it.prepare(); err != nil {
+			t.Fatal(err)
+		}
+		b.Items = append(b.Items, it)
+	}
+	s := &Service{
+		ttl:  testTTL,
+		raw:  chain.RawAddr(w.WalletAddress()),
+		file: file,
+		log:  zerolog.Nop(),
+		st:   state{Batches: map[string]*Batch{b.ID: b}, Keys: map[string]string{}},
+	}
+	return &fixture{s: s, w: w, batch: b, lt: 1000}
+}
+
+// send returns the transaction of the highload wallet executing the
+// external message of the chunk, as HighloadSend builds it.
+func (f *fixture) send(t *testing.T) *tlb.Transaction {
+	t.Helper()
+	var msgs []*wallet.Message
+	for _, it := range f.batch.Items {
+		body, err := chain.HighloadBody(it.Comment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst := address.MustParseAddr(it.To)
+		msgs = append(msgs, &wallet.Message{
+			Mode: wallet.PayGasSeparately + wallet.IgnoreErrors,
+			InternalMessage: &tlb.InternalMessage{
+				IHRDisabled: true,
+				Bounce:      dst.IsBounceable(),
+				DstAddr:     dst,
+				Amount:      tlb.FromNanoTON(it.amount),
+				Body:        body,
+			},
+		})
+	}
+	ext, err := f.w.PrepareExternalMessageForMany(context.Background(), false, msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// signature, then a ref to subwallet_id and a ref to the message
+	payload, err := ext.Body.BeginParse().LoadRef()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := payload.LoadRef()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &tlb.InternalMessage{}
+	if err = tlb.LoadFromCell(out, msg); err != nil {
+		t.Fatal(err)
+	}
+	tx := f.tx(ok())
+	tx.IO.In = &tlb.Message{MsgType: tlb.MsgTypeExternalIn, Msg: ext}
+	tx.IO.Out = f.outMessages(t, out)
+	return tx
+}
+
+// run returns the transaction of the highload wallet receiving the actions
+// it sent itself in m, sending them.
+func (f *fixture) run(t *testing.T, m *tlb.InternalMessage) *tlb.Transaction {
+	t.Helper()
+	body := m.Body.BeginParse()
+	body.MustLoadUInt(32 + 64)
+	list := body.MustLoadRef()
+	var out []*tlb.InternalMessage
+	// the last action comes first, after a ref to the rest
+	for list.RefsNum() > 0 {
+		prev := list.MustLoadRef()
+		list.MustLoadUInt(32 + 8)
+		msg := &tlb.InternalMessage{}
+		if err := tlb.LoadFromCell(msg, list.MustLoadRef()); err != nil {
+			t.Fatal(err)
+		}
+		out = append([]*tlb.InternalMessage{msg}, out...)
+		list = prev
+	}
+	tx := f.tx(ok())
+	tx.IO.In = &tlb.Message{MsgType: tlb.MsgTypeInternal, Msg: m}
+	tx.IO.Out = f.outMessages(t, out...)
+	return tx
+}
+
+// tx returns an empty transaction of the wallet with the next lt.
+func (f *fixture) tx(desc tlb.TransactionDescriptionOrdinary) *tlb.Transaction {
+	f.lt += 10
+	hash := make([]byte, 32)
+	binary.BigEndian.PutUint64(hash, f.lt)
+	return &tlb.Transaction{LT: f.lt, Hash: hash, Description: tlb.TransactionDescription{Description: desc}}
+}
+
+// outMessages builds the out message list of a transaction of the wallet.
+func (f *fixture) outMessages(t *testing.T, msgs ...*tlb.InternalMessage) *tlb.MessagesList {
+	t.Helper()
+	dict := cell.NewDict(15)
+	for i, m := range msgs {
+		m.SrcAddr = f.w.WalletAddress()
+		m.CreatedLT = f.lt + uint64(i) + 1
+		c, err := tlb.ToCell(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = dict.SetIntKey(big.NewInt(int64(i)), cell.BeginCell().MustStoreRef(c).EndCell()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &tlb.MessagesList{List: dict}
+}
+
+func ok() tlb.TransactionDescriptionOrdinary {
+	return tlb.TransactionDescriptionOrdinary{ActionPhase: &tlb.ActionPhase{Success: true, Valid: true}}
+}
+
+func out(t *testing.T, tx *tlb.Transaction) []*tlb.InternalMessage {
+	t.Helper()
+	msgs, err := tx.IO.Out.ToSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make([]*tlb.InternalMessage, len(msgs))
+	for i, m := range msgs {
+		res[i] = m.AsInternal()
+	}
+	return res
+}
+
+func dest(i int) string {
+	return address.NewAddress(0, 0, binary.BigEndian.AppendUint32(make([]byte, 28), uint32(i))).String()
+}
+
+func requests(n int) []Request {
+	reqs := make([]Request, n)
+	for i := range reqs {
+		reqs[i] = Request{To: dest(i), Amount: "0.1"}
+	}
+	reqs[0].Comment = "first"
+	return reqs
+}
+
+func assertItems(t *testing.T, b *Batch, status string, txHash []byte) {
+	t.Helper()
+	for i, it := range b.Items {
+		if it.Status != status {
+			t.Fatalf("item %d status = %q, want %q", i, it.Status, status)
+		}
+		if txHash != nil && it.TxHash != hex.EncodeToString(txHash) {
+			t.Fatalf("item %d tx = %s, want %s", i, it.TxHash, hex.EncodeToString(txHash))
+		}
+	}
+}
+
+func TestHandleTransactionSingle(t *testing.T) {
+	f := newFixture(t, requests(1))
+	tx := f.send(t)
+	f.s.HandleTransaction(f.w.WalletAddress(), tx)
+
+	if c := f.batch.Chunks[0]; c.Status != StatusProcessed || c.ActionsLT != 0 {
+		t.Errorf("chunk = %+v, want processed", c)
+	}
+	assertItems(t, f.batch, StatusSent, tx.Hash)
+}
+
+func TestHandleTransactionPacked(t *testing.T) {
+	f := newFixture(t, requests(3))
+	ext := f.send(t)
+	f.s.HandleTransaction(f.w.WalletAddress(), ext)
+
+	self := out(t, ext)
+	if len(self) != 1 || !chain.HighloadActions(self[0]) {
+		t.Fatalf("external message sent %d messages, want the actions to the wallet itself", len(self))
+	}
+	if c := f.batch.Chunks[0]; c.Status != StatusProcessed || c.ActionsLT != self[0].CreatedLT {
+		t.Fatalf("chunk = %+v, want processed waiting for lt %d", c, self[0].CreatedLT)
+	}
+	// the transfers haven't left the wallet yet
+	assertItems(t, f.batch, StatusSubmitted, nil)
+
+	actions := f.run(t, self[0])
+	f.s.HandleTransaction(f.w.WalletAddress(), actions)
+	assertItems(t, f.batch, StatusSent, actions.Hash)
+	if c := f.batch.Chunks[0]; c.ActionsLT != 0 || c.Error != "" {
+		t.Errorf("chunk = %+v, want done", c)
+	}
+}
+
+func TestHandleTransactionPackedTwice(t *testing.T) {
+	// more transfers than fit one action list are passed on to the wallet
+	// again
+	f := newFixture(t, requests(300))
+	ext := f.send(t)
+	f.s.HandleTransaction(f.w.WalletAddress(), ext)
+
+	first := f.run(t, out(t, ext)[0])
+	f.s.HandleTransaction(f.w.WalletAddress(), first)
+	msgs := out(t, first)
+	next := msgs[len(msgs)-1]
+	if !chain.HighloadActions(next) || f.batch.Chunks[0].ActionsLT != next.CreatedLT {
+		t.Fatalf("chunk = %+v, want waiting for the second action list", f.batch.Chunks[0])
+	}
+	sent := 0
+	for _, it := range f.batch.Items {
+		if it.Status == StatusSent {
+			sent++
+		}
+	}
+	if sent != len(msgs)-1 {
+		t.Fatalf("%d items sent by the first action list, want %d", sent, len(msgs)-1)
+	}
+
+	f.s.HandleTransaction(f.w.WalletAddress(), f.run(t, next))
+	assertItems(t, f.batch, StatusSent, nil)
+}
+
+func TestHandleTransactionActionsFailed(t *testing.T) {
+	f := newFixture(t, requests(3))
+	ext := f.send(t)
+	f.s.HandleTransaction(f.w.WalletAddress(), ext)
+
+	// not enough balance for the transfers, none of them is sent
+	actions := f.run(t, out(t, ext)[0])
+	actions.IO.Out = nil
+	actions.Description.Description = tlb.TransactionDescriptionOrdinary{ActionPhase: &tlb.ActionPhase{ResultCode: 37}}
+	f.s.HandleTransaction(f.w.WalletAddress(), actions)
+
+	assertItems(t, f.batch, StatusFailed, actions.Hash)
+	if c := f.batch.Chunks[0]; !strings.Contains(c.Error, "37") {
+		t.Errorf("chunk error = %q, want the result code", c.Error)
+	}
+}
+
+func TestHandleTransactionOtherActions(t *testing.T) {
+	f := newFixture(t, requests(3))
+	ext := f.send(t)
+	f.s.HandleTransaction(f.w.WalletAddress(), ext)
+
+	// actions of a message the chunk didn't send
+	m := out(t, ext)[0]
+	m.CreatedLT++
+	f.s.HandleTransaction(f.w.WalletAddress(), f.run(t, m))
+	assertItems(t, f.batch, StatusSubmitted, nil)
+}
+
+func TestHandleTransactionBounce(t *testing.T) {
+	to := dest(1)
+	f := newFixture(t, []Request{
+		{To: to, Amount: "1", Comment: "order 1"},
+		{To: to, Amount: "1", Comment: "order 2"},
+		{To: to, Amount: "1"},
+	})
+	for i := range f.batch.Items {
+		it := &f.batch.Items[i]
+		it.Status, it.LT = StatusSent, uint64(i+1)
+	}
+	bounce := func(body *cell.Cell) {
+		t.Helper()
+		tx := f.tx(ok())
+		tx.IO.In = &tlb.Message{MsgType: tlb.MsgTypeInternal, Msg: &tlb.InternalMessage{
+			Bounced: true,
+			SrcAddr: address.MustParseAddr(to),
+			DstAddr: f.w.WalletAddress(),
+			Amount:  tlb.MustFromTON("0.99"),
+			Body:    body,
+		}}
+		f.s.HandleTransaction(f.w.WalletAddress(), tx)
+	}
+	bounced := func(comment string) *cell.Cell {
+		b := cell.BeginCell().MustStoreUInt(0xffffffff, 32)
+		if comment != "" {
+			b.MustStoreUInt(0, 32).MustStoreSlice([]byte(comment), uint(len(comment))*8)
+		}
+		return b.EndCell()
+	}
+
+	// the second transfer came back, not the oldest one to the address
+	bounce(bounced("order 2"))
+	want := []string{StatusSent, StatusBounced, StatusSent}
+	for i, it := range f.batch.Items {
+		if it.Status != want[i] {
+			t.Fatalf("item %d status = %q, want %q", i, it.Status, want[i])
+		}
+	}
+	bounce(bounced(""))
+	if it := f.batch.Items[2]; it.Status != StatusBounced {
+		t.Errorf("item without comment status = %q, want bounced", it.Status)
+	}
+	bounce(bounced("order 3"))
+	if it := f.batch.Items[0]; it.Status != StatusSent {
+		t.Errorf("item status = %q after a bounce of another comment, want sent", it.Status)
+	}
+}
+
+func TestPrepareAmount(t *testing.T) {
+	for _, amount := range []string{"", "abc", "0", "-1", "1.5"} {
+		it := Item{To: dest(1), Amount: amount}
+		if err := it.prepare(); err == nil {
+			t.Errorf("prepare() of amount %q succeeded", amount)
+		}
+	}
+}
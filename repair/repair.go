@@ -0,0 +1,232 @@
+// Package repair rescans shard blocks and accounts the scanner failed on
+// and reports what is not fully indexed yet.
+package repair
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/dumps"
+	"github.com/FishDontExist/TONindexer/events"
+	"github.com/FishDontExist/TONindexer/index"
+	"github.com/FishDontExist/TONindexer/retry"
+	"github.com/rs/zerolog"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/ton"
+)
+
+// Retry queue kinds.
+const (
+	// KindShard is a shard block that failed to scan.
+	KindShard = "shard_block"
+	// KindAccount is an account that couldn't be fetched after it changed.
+	KindAccount = "account"
+)
+
+type shardTask struct {
+	Master *ton.BlockIDExt `json:"master"`
+	Shard  *ton.BlockIDExt `json:"shard"`
+}
+
+type accountTask struct {
+	Master  *ton.BlockIDExt `json:"master"`
+	Shard   *ton.BlockIDExt `json:"shard"`
+	Address string          `json:"address"`
+	LT      uint64          `json:"lt"`
+}
+
+type Repairer struct {
+	interval time.Duration
+	scanner  *dumps.Scanner
+	idx      *index.Index
+	queue    *retry.Queue
+	log      zerolog.Logger
+}
+
+func New(cfg *config.Config, scanner *dumps.Scanner, idx *index.Index, queue *retry.Queue, lg zerolog.Logger) *Repairer {
+	return &Repairer{
+		interval: cfg.Repair.Interval.Duration,
+		scanner:  scanner,
+		idx:      idx,
+		queue:    queue,
+		log:      lg,
+	}
+}
+
+// Failed queues the shard block of ev to be scanned again.
+func (r *Repairer) Failed(ev dumps.ShardFailedEvent) {
+	err := r.queue.Add(blockName(ev.Shard), KindShard, shardTask{Master: ev.Master, Shard: ev.Shard}, ev.Err)
+	if err != nil {
+		r.log.Error().Err(err).Str("block", blockName(ev.Shard)).Msg("queue shard block")
+	}
+}
+
+// AccountFailed queues the account of ev to be checked again.
+func (r *Repairer) AccountFailed(ev dumps.AccountFailedEvent) {
+	id := fmt.Sprintf("%s:%d", chain.RawAddr(ev.Addr), ev.Tx.LT)
+	task := accountTask{Master: ev.Master, Shard: ev.Shard, Address: ev.Addr.String(), LT: ev.Tx.LT}
+	if err := r.queue.Add(id, KindAccount, task, ev.Err); err != nil {
+		r.log.Error().Err(err).Str("task", id).Msg("queue account")
+	}
+}
+
+// Stats returns the retry queue stats by kind.
+func (r *Repairer) Stats() map[string]retry.Stats {
+	return r.queue.Stats()
+}
+
+// Run retries due shard blocks and accounts until ctx is done. What they
+// yield is sent to ch like the scanner sends it.
+func (r *Repairer) Run(ctx context.Context, ch chan<- any) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, t := range r.queue.Due(KindShard) {
+			r.repair(ctx, t, ch)
+		}
+		for _, t := range r.queue.Due(KindAccount) {
+			r.recheck(ctx, t, ch)
+		}
+	}
+}
+
+func (r *Repairer) repair(ctx context.Context, t retry.Task, ch chan<- any) {
+	var task shardTask
+	if err := json.Unmarshal(t.Payload, &task); err != nil {
+		r.log.Error().Err(err).Str("task", t.ID).Msg("drop shard block task")
+		r.queue.Done(t.ID)
+		return
+	}
+	if !r.idx.Has(task.Master.SeqNo) {
+		// the scanner hasn't recorded the masterchain block yet
+		r.queue.Release(t.ID)
+		return
+	}
+
+	sb, err := r.scanner.ScanShard(ctx, task.Master, task.Shard, ch)
+	if err == nil {
+		err = r.idx.SetShard(task.Master.SeqNo, events.NewShard(sb.ID, sb.GenUtime, sb.Transactions))
+	}
+	if err != nil {
+		if r.queue.Fail(t.ID, err) {
+			r.log.Error().Err(err).Str("task", t.ID).Int("attempts", t.Attempts+1).Msg("shard block failed permanently")
+		}
+		return
+	}
+	r.log.Info().Str("block", t.ID).Uint32("master", task.Master.SeqNo).Msg("repaired shard block")
+	r.queue.Done(t.ID)
+}
+
+func (r *Repairer) recheck(ctx context.Context, t retry.Task, ch chan<- any) {
+	var task accountTask
+	if err := json.Unmarshal(t.Payload, &task); err != nil {
+		r.log.Error().Err(err).Str("task", t.ID).Msg("drop account task")
+		r.queue.Done(t.ID)
+		return
+	}
+	addr, err := address.ParseAddr(task.Address)
+	if err != nil {
+		r.log.Error().Err(err).Str("task", t.ID).Msg("drop account task")
+		r.queue.Done(t.ID)
+		return
+	}
+
+	if err = r.scanner.ScanAccount(ctx, task.Master, task.Shard, addr, task.LT, ch); err != nil {
+		if r.queue.Fail(t.ID, err) {
+			r.log.Error().Err(err).Str("task", t.ID).Int("attempts", t.Attempts+1).Msg("account failed permanently")
+		}
+		return
+	}
+	r.queue.Done(t.ID)
+}
+
+// Gaps lists what is not fully indexed: masterchain blocks missing between
+// the first and the last indexed one, and shard blocks that failed to scan.
+type Gaps struct {
+	Indexed *index.Range  `json:"indexed"`
+	Masters []index.Range `json:"masters"`
+	Shards  []ShardGap    `json:"shards"`
+}
+
+// ShardGap is a run of consecutive shard blocks of one shard that failed to
+// scan. Failed is set when any of them won't be retried anymore.
+type ShardGap struct {
+	Workchain int32  `json:"workchain"`
+	Shard     string `json:"shard"`
+	From      uint32 `json:"from"`
+	To        uint32 `json:"to"`
+	Attempts  int    `json:"attempts"`
+	Failed    bool   `json:"failed"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+func (r *Repairer) Gaps() Gaps {
+	g := Gaps{Masters: r.idx.Gaps(), Shards: []ShardGap{}}
+	if span, ok := r.idx.Span(); ok {
+		g.Indexed = &span
+	}
+
+	type failed struct {
+		id   *ton.BlockIDExt
+		task retry.Task
+	}
+	var blocks []failed
+	for _, t := range r.queue.List(KindShard) {
+		var task shardTask
+		if err := json.Unmarshal(t.Payload, &task); err != nil {
+			continue
+		}
+		blocks = append(blocks, failed{id: task.Shard, task: t})
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		a, b := blocks[i].id, blocks[j].id
+		if a.Workchain != b.Workchain {
+			return a.Workchain < b.Workchain
+		}
+		if a.Shard != b.Shard {
+			return uint64(a.Shard) < uint64(b.Shard)
+		}
+		return a.SeqNo < b.SeqNo
+	})
+
+	for _, f := range blocks {
+		s, t := f.id, f.task
+		shard := fmt.Sprintf("%016x", uint64(s.Shard))
+		if n := len(g.Shards); n > 0 {
+			last := &g.Shards[n-1]
+			if last.Workchain == s.Workchain && last.Shard == shard && last.To+1 == s.SeqNo {
+				last.To = s.SeqNo
+				last.Attempts = max(last.Attempts, t.Attempts)
+				last.Failed = last.Failed || t.Failed
+				if t.LastError != "" {
+					last.LastError = t.LastError
+				}
+				continue
+			}
+		}
+		g.Shards = append(g.Shards, ShardGap{
+			Workchain: s.Workchain,
+			Shard:     shard,
+			From:      s.SeqNo,
+			To:        s.SeqNo,
+			Attempts:  t.Attempts,
+			Failed:    t.Failed,
+			LastError: t.LastError,
+		})
+	}
+	return g
+}
+
+func blockName(id *ton.BlockIDExt) string {
+	return fmt.Sprintf("%d:%016x:%d", id.Workchain, uint64(id.Shard), id.SeqNo)
+}
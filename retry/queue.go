@@ -0,0 +1,301 @@
+// Package retry keeps work that failed in a durable queue, so it is tried
+// again with backoff instead of being lost, also across restarts.
+package retry
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/metrics"
+	"github.com/FishDontExist/TONindexer/store"
+	"github.com/rs/zerolog"
+)
+
+// Task is one unit of queued work. Payload is decoded by whoever handles
+// tasks of its Kind.
+type Task struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	// Failed is set once MaxAttempts is reached. The last maxFailed failed
+	// tasks of each kind are kept for inspection but no longer returned by
+	// Due.
+	Failed bool `json:"failed,omitempty"`
+}
+
+// maxFailed is how many failed tasks of a kind are kept, older ones are
+// dropped first.
+const maxFailed = 1000
+
+// Backoff configures the delay between attempts of a task.
+type Backoff struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Stats describes the tasks of one kind. Pending and Failed are the tasks
+// queued now, Retries and GaveUp count failed attempts and tasks that
+// failed permanently since the queue was opened.
+type Stats struct {
+	Pending int    `json:"pending"`
+	Failed  int    `json:"failed"`
+	Retries uint64 `json:"retries"`
+	GaveUp  uint64 `json:"gave_up"`
+}
+
+type Queue struct {
+	backoff Backoff
+	file    *store.File
+	log     zerolog.Logger
+
+	mx       sync.Mutex
+	tasks    map[string]*Task
+	inFlight map[string]bool
+	retries  map[string]uint64
+	gaveUp   map[string]uint64
+	// kinds are all kinds seen, so gauges of emptied kinds go back to 0.
+	kinds map[string]bool
+	// dirty signals the writer that tasks changed since the last save.
+	dirty chan struct{}
+}
+
+func Open(dir, name string, backoff Backoff, lg zerolog.Logger) (*Queue, error) {
+	file, err := store.Open(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	q := &Queue{
+		backoff:  backoff,
+		file:     file,
+		log:      lg,
+		tasks:    map[string]*Task{},
+		inFlight: map[string]bool{},
+		retries:  map[string]uint64{},
+		gaveUp:   map[string]uint64{},
+		kinds:    map[string]bool{},
+		dirty:    make(chan struct{}, 1),
+	}
+	if err = file.Load(&q.tasks); err != nil {
+		return nil, fmt.Errorf("load retry queue: %w", err)
+	}
+	q.report()
+	go q.write()
+	return q, nil
+}
+
+// save schedules writing the tasks and updates the gauges, it must be
+// called with mx held. Callers run on the scanner event loop, so the file
+// is written in the background. A crash may lose the last changes: a task
+// added just before isn't retried, one done just before runs again.
+func (q *Queue) save() {
+	select {
+	case q.dirty <- struct{}{}:
+	default:
+	}
+	q.report()
+}
+
+// write saves the tasks after every change, changes made while saving are
+// written together.
+func (q *Queue) write() {
+	for range q.dirty {
+		q.mx.Lock()
+		snapshot := make(map[string]Task, len(q.tasks))
+		for id, t := range q.tasks {
+			snapshot[id] = *t
+		}
+		q.mx.Unlock()
+		if err := q.file.Save(snapshot); err != nil {
+			q.log.Error().Err(err).Msg("save retry queue")
+		}
+	}
+}
+
+// report updates the task gauges, it must be called with mx held.
+func (q *Queue) report() {
+	pending, failed := map[string]int{}, map[string]int{}
+	for _, t := range q.tasks {
+		q.kinds[t.Kind] = true
+		if t.Failed {
+			failed[t.Kind]++
+		} else {
+			pending[t.Kind]++
+		}
+	}
+	for kind := range q.kinds {
+		metrics.RetryTasks.WithLabelValues(kind, "pending").Set(float64(pending[kind]))
+		metrics.RetryTasks.WithLabelValues(kind, "failed").Set(float64(failed[kind]))
+	}
+}
+
+// Add queues a task to be run after the initial backoff. A task with the
+// same id already queued is left as is.
+func (q *Queue) Add(id, kind string, payload any, cause error) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encode %s task: %w", kind, err)
+	}
+
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	if _, ok := q.tasks[id]; ok {
+		return nil
+	}
+	now := time.Now().UTC()
+	t := &Task{
+		ID:          id,
+		Kind:        kind,
+		Payload:     data,
+		CreatedAt:   now,
+		NextAttempt: now.Add(q.backoff.InitialBackoff),
+	}
+	if cause != nil {
+		t.LastError = cause.Error()
+	}
+	q.tasks[id] = t
+	q.save()
+	return nil
+}
+
+// Due returns tasks of kind whose next attempt is due, oldest first. They
+// are not returned again until Done or Fail is called for them.
+func (q *Queue) Due(kind string) []Task {
+	now := time.Now()
+
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	var due []Task
+	for id, t := range q.tasks {
+		if t.Kind == kind && !t.Failed && !q.inFlight[id] && !t.NextAttempt.After(now) {
+			q.inFlight[id] = true
+			due = append(due, *t)
+		}
+	}
+	sortTasks(due)
+	return due
+}
+
+// Done removes a task that succeeded.
+func (q *Queue) Done(id string) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	delete(q.inFlight, id)
+	if _, ok := q.tasks[id]; ok {
+		delete(q.tasks, id)
+		q.save()
+	}
+}
+
+// Fail records a failed attempt and schedules the next one. It reports
+// whether the task has now failed permanently.
+func (q *Queue) Fail(id string, err error) bool {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	delete(q.inFlight, id)
+	t, ok := q.tasks[id]
+	if !ok {
+		return false
+	}
+	t.Attempts++
+	t.LastError = err.Error()
+	q.retries[t.Kind]++
+	metrics.RetryAttempts.WithLabelValues(t.Kind).Inc()
+	if t.Attempts >= q.backoff.MaxAttempts {
+		t.Failed = true
+		q.gaveUp[t.Kind]++
+		metrics.RetryGaveUp.WithLabelValues(t.Kind).Inc()
+		q.dropFailed(t.Kind)
+	} else {
+		t.NextAttempt = time.Now().Add(q.delay(t.Attempts)).UTC()
+	}
+	q.save()
+	return t.Failed
+}
+
+// dropFailed keeps the last maxFailed failed tasks of kind, it must be
+// called with mx held.
+func (q *Queue) dropFailed(kind string) {
+	var failed []Task
+	for _, t := range q.tasks {
+		if t.Kind == kind && t.Failed {
+			failed = append(failed, *t)
+		}
+	}
+	if len(failed) <= maxFailed {
+		return
+	}
+	sortTasks(failed)
+	for _, t := range failed[:len(failed)-maxFailed] {
+		delete(q.tasks, t.ID)
+	}
+}
+
+// Release returns a task from Due to the queue without counting an attempt.
+func (q *Queue) Release(id string) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	delete(q.inFlight, id)
+}
+
+// List returns all tasks of kind, including failed ones, oldest first.
+func (q *Queue) List(kind string) []Task {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	var res []Task
+	for _, t := range q.tasks {
+		if t.Kind == kind {
+			res = append(res, *t)
+		}
+	}
+	sortTasks(res)
+	return res
+}
+
+// Stats returns the stats of every kind seen since the queue was opened.
+func (q *Queue) Stats() map[string]Stats {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	res := map[string]Stats{}
+	for _, t := range q.tasks {
+		st := res[t.Kind]
+		if t.Failed {
+			st.Failed++
+		} else {
+			st.Pending++
+		}
+		res[t.Kind] = st
+	}
+	for kind, n := range q.retries {
+		st := res[kind]
+		st.Retries = n
+		st.GaveUp = q.gaveUp[kind]
+		res[kind] = st
+	}
+	return res
+}
+
+// delay doubles after every failed attempt up to MaxBackoff.
+func (q *Queue) delay(attempts int) time.Duration {
+	d := q.backoff.InitialBackoff
+	for i := 1; i < attempts && d < q.backoff.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, q.backoff.MaxBackoff)
+}
+
+func sortTasks(tasks []Task) {
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].CreatedAt.Equal(tasks[j].CreatedAt) {
+			return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+		}
+		return tasks[i].ID < tasks[j].ID
+	})
+}
@@ -0,0 +1,74 @@
+// Package store persists service state as JSON documents in the data dir.
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File is one JSON document. Save replaces it atomically, so a crash leaves
+// either the old or the new version on disk.
+type File struct {
+	path string
+	mx   sync.Mutex
+}
+
+func Open(dir, name string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
+	}
+	return &File{path: filepath.Join(dir, name)}, nil
+}
+
+// Load decodes the document into v. A missing file leaves v untouched.
+func (f *File) Load(v any) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read %s: %w", f.path, err)
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parse %s: %w", f.path, err)
+	}
+	return nil
+}
+
+func (f *File) Save(v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", f.path, err)
+	}
+
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return fmt.Errorf("save %s: %w", f.path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f.path)
+	}
+	if err != nil {
+		return fmt.Errorf("save %s: %w", f.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Log is an append-only file of JSON lines, for records that would be too
+// costly to rewrite as a whole. Records are addressed by their offset, which
+// stays valid until the log is rewritten.
+type Log struct {
+	path string
+	mx   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenLog opens the log, dropping a partial last record left by a crash
+// during Append, the way write-ahead log readers do.
+func OpenLog(dir, name string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
+	}
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	l := &Log{path: path, f: f}
+	if l.size, err = complete(f); err == nil {
+		err = f.Truncate(l.size)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// complete returns the size of f up to its last whole record: one that
+// ends with a newline and is valid JSON.
+func complete(f *os.File) (int64, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := st.Size()
+	if size == 0 {
+		return 0, nil
+	}
+	// records are small, look back far enough to find two line ends
+	const window = 1 << 20
+	start := max(size-window, 0)
+	tail := make([]byte, size-start)
+	if _, err = f.ReadAt(tail, start); err != nil {
+		return 0, err
+	}
+	if tail[len(tail)-1] != '\n' {
+		end := bytes.LastIndexByte(tail, '\n')
+		if end < 0 && start > 0 {
+			return 0, fmt.Errorf("last record is longer than %d bytes", window)
+		}
+		tail = tail[:end+1]
+	}
+	// a zero filled or torn line may still end with a newline
+	for len(tail) > 0 {
+		begin := bytes.LastIndexByte(tail[:len(tail)-1], '\n') + 1
+		line := bytes.TrimSpace(tail[begin:])
+		if len(line) == 0 || json.Valid(line) {
+			break
+		}
+		tail = tail[:begin]
+	}
+	return start + int64(len(tail)), nil
+}
+
+// Append writes v as a record and returns its offset.
+func (l *Log) Append(v any) (int64, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, fmt.Errorf("encode %s record: %w", l.path, err)
+	}
+	data = append(data, '\n')
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	off := l.size
+	if _, err = l.f.WriteAt(data, off); err == nil {
+		err = l.f.Sync()
+	}
+	if err != nil {
+		// drop what was written, the next record must start on a new line
+		l.f.Truncate(off)
+		return 0, fmt.Errorf("append %s: %w", l.path, err)
+	}
+	l.size += int64(len(data))
+	return off, nil
+}
+
+// Scan calls fn with every record and its offset in the order they were
+// appended. Like Read, it must not run concurrently with Rewrite.
+func (l *Log) Scan(fn func(off int64, line []byte) error) error {
+	l.mx.Lock()
+	f, size := l.f, l.size
+	l.mx.Unlock()
+
+	r := bufio.NewReaderSize(io.NewSectionReader(f, 0, size), 64<<10)
+	var off int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read %s: %w", l.path, err)
+		}
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			if err = fn(off, trimmed); err != nil {
+				return err
+			}
+		}
+		off += int64(len(line))
+	}
+}
+
+// Read returns the record at off.
+func (l *Log) Read(off int64) ([]byte, error) {
+	l.mx.Lock()
+	f, size := l.f, l.size
+	l.mx.Unlock()
+	if off < 0 || off >= size {
+		return nil, fmt.Errorf("read %s: no record at %d", l.path, off)
+	}
+	line, err := bufio.NewReader(io.NewSectionReader(f, off, size-off)).ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", l.path, err)
+	}
+	return bytes.TrimSpace(line), nil
+}
+
+// Rewrite replaces the log with records, atomically like File.Save, and
+// returns their new offsets. Offsets from before are invalid afterwards.
+func (l *Log) Rewrite(records [][]byte) ([]int64, error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("rewrite %s: %w", l.path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	offsets := make([]int64, len(records))
+	w := bufio.NewWriter(tmp)
+	var size int64
+	for i, rec := range records {
+		offsets[i] = size
+		w.Write(rec)
+		w.WriteByte('\n')
+		size += int64(len(rec)) + 1
+	}
+	if err = w.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), l.path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rewrite %s: %w", l.path, err)
+	}
+
+	f, err := os.OpenFile(l.path, os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("reopen %s: %w", l.path, err)
+	}
+	l.f.Close()
+	l.f, l.size = f, size
+	return offsets, nil
+}
+
+func (l *Log) Close() error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.f.Close()
+}
@@ -0,0 +1,310 @@
+// Package sweep moves funds from deposit wallets to the treasury. Jettons
+// are swept first, as their transfers are paid from the TON of the deposit
+// wallet, then the TON balance is sent. While a jetton stays on the wallet,
+// jetton_fee is left behind to pay for its sweep later.
+//
+// Errors wrap the chain package sentinels, so handlers map them to statuses
+// the same way as liteserver errors.
+package sweep
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/deposits"
+	"github.com/FishDontExist/TONindexer/store"
+	"github.com/rs/zerolog"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+)
+
+// Sweep statuses.
+const (
+	// StatusDone sweeps have their transaction on chain.
+	StatusDone = "done"
+	// StatusFailed sweeps got an error, they are tried again next run.
+	StatusFailed = "failed"
+	// StatusNeedsGas jetton sweeps found too little TON on the deposit
+	// wallet to pay for the transfer.
+	StatusNeedsGas = "needs_gas"
+)
+
+// AssetTON is the Asset of TON sweeps, jetton sweeps have the master address.
+const AssetTON = "ton"
+
+// maxSweeps is how many sweeps are kept, older ones are dropped first.
+const maxSweeps = 10000
+
+type Sweep struct {
+	ID      string `json:"id"`
+	UserID  string `json:"user_id"`
+	Index   uint32 `json:"index"`
+	Address string `json:"address"`
+	Asset   string `json:"asset"`
+	// Amount is in nanotons or jetton base units. TON sweeps send the
+	// balance seen before sending, minus fees and what is left for jettons.
+	Amount    string    `json:"amount"`
+	Status    string    `json:"status"`
+	TxHash    string    `json:"tx_hash,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// liteClient is what of the liteserver client sweeping needs.
+type liteClient interface {
+	GetBalance(ctx context.Context, addr string) (tlb.Coins, error)
+	JettonBalance(ctx context.Context, master, owner string) (*big.Int, error)
+	SweepTON(ctx context.Context, key ed25519.PrivateKey, to string, keep tlb.Coins) (*tlb.Transaction, error)
+	SweepJetton(ctx context.Context, key ed25519.PrivateKey, master, to string, amount *big.Int, fee tlb.Coins) (*tlb.Transaction, error)
+}
+
+// depositRegistry is what of the deposits registry sweeping needs.
+type depositRegistry interface {
+	List(userID string) []deposits.Address
+	Key(userID string, index uint32) ed25519.PrivateKey
+}
+
+type jettonAsset struct {
+	master string
+	min    *big.Int
+}
+
+type Sweeper struct {
+	cfg        config.SweepConfig
+	ln         liteClient
+	registry   depositRegistry
+	file       *store.File
+	log        zerolog.Logger
+	minBalance tlb.Coins
+	jettonFee  tlb.Coins
+	jettons    []jettonAsset
+
+	// running serializes runs, a deposit wallet must not send twice at once.
+	running sync.Mutex
+
+	mx     sync.Mutex
+	sweeps []Sweep
+}
+
+func New(cfg *config.Config, ln *chain.LiteClient, registry *deposits.Registry, lg zerolog.Logger) (*Sweeper, error) {
+	s := &Sweeper{
+		cfg:      cfg.Sweep,
+		ln:       ln,
+		registry: registry,
+		log:      lg.With().Str("component", "sweep").Logger(),
+	}
+	if _, err := address.ParseAddr(cfg.Sweep.Treasury); err != nil {
+		return nil, fmt.Errorf("sweep.treasury: %w", err)
+	}
+	var err error
+	if s.minBalance, err = tlb.FromTON(cfg.Sweep.MinBalance); err != nil {
+		return nil, fmt.Errorf("sweep.min_balance: %w", err)
+	}
+	if s.jettonFee, err = tlb.FromTON(cfg.Sweep.JettonFee); err != nil {
+		return nil, fmt.Errorf("sweep.jetton_fee: %w", err)
+	}
+	for _, j := range cfg.Sweep.Jettons {
+		if _, err = address.ParseAddr(j.Master); err != nil {
+			return nil, fmt.Errorf("sweep.jettons: master %q: %w", j.Master, err)
+		}
+		minAmount, ok := new(big.Int).SetString(j.MinAmount, 10)
+		if !ok || minAmount.Sign() <= 0 {
+			return nil, fmt.Errorf("sweep.jettons: min_amount of %s must be a positive integer", j.Master)
+		}
+		s.jettons = append(s.jettons, jettonAsset{master: j.Master, min: minAmount})
+	}
+
+	if s.file, err = store.Open(cfg.Storage.Dir, "sweeps.json"); err != nil {
+		return nil, err
+	}
+	if err = s.file.Load(&s.sweeps); err != nil {
+		return nil, fmt.Errorf("load sweeps: %w", err)
+	}
+	return s, nil
+}
+
+// Run sweeps deposit wallets every interval until ctx is done.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.Sweep(ctx)
+	}
+}
+
+// Sweep checks every registered deposit wallet once and returns the sweeps
+// it made. A run already in progress is waited for.
+func (s *Sweeper) Sweep(ctx context.Context) []Sweep {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	var res []Sweep
+	for _, a := range s.registry.List("") {
+		if ctx.Err() != nil {
+			break
+		}
+		res = append(res, s.sweepAddress(ctx, a)...)
+	}
+	if len(res) > 0 {
+		s.record(res)
+	}
+	return res
+}
+
+func (s *Sweeper) sweepAddress(ctx context.Context, a deposits.Address) []Sweep {
+	lg := s.log.With().Str("address", a.Address).Str("label", a.Label()).Logger()
+	key := s.registry.Key(a.UserID, a.Index)
+	newSweep := func(asset string, amount *big.Int) Sweep {
+		return Sweep{
+			ID:        fmt.Sprintf("%s:%s:%d", a.Address, asset, time.Now().UnixNano()),
+			UserID:    a.UserID,
+			Index:     a.Index,
+			Address:   a.Address,
+			Asset:     asset,
+			Amount:    amount.String(),
+			CreatedAt: time.Now().UTC(),
+		}
+	}
+	finish := func(sw *Sweep, tx *tlb.Transaction, err error) {
+		if err != nil {
+			sw.Status, sw.Error = StatusFailed, err.Error()
+			lg.Warn().Err(err).Str("asset", sw.Asset).Msg("sweep failed")
+			return
+		}
+		sw.Status, sw.TxHash = StatusDone, hex.EncodeToString(tx.Hash)
+	}
+
+	balance, err := s.balance(ctx, a.Address)
+	if err != nil {
+		lg.Warn().Err(err).Msg("get deposit balance")
+		return nil
+	}
+
+	var res []Sweep
+	// jettonsLeft is set when a jetton stays on the wallet, its later sweep
+	// needs jetton_fee so the ton sweep leaves that much behind
+	jettonsLeft := false
+	for _, j := range s.jettons {
+		amount, err := s.jettonBalance(ctx, j.master, a.Address)
+		if err != nil {
+			lg.Warn().Err(err).Str("master", j.master).Msg("get deposit jetton balance")
+			// the balance is unknown, keep the gas in case there is one
+			jettonsLeft = true
+			continue
+		}
+		if amount.Sign() == 0 {
+			continue
+		}
+		if amount.Cmp(j.min) < 0 {
+			jettonsLeft = true
+			continue
+		}
+		sw := newSweep(j.master, amount)
+		if balance.Nano().Cmp(s.jettonFee.Nano()) < 0 {
+			jettonsLeft = true
+			// recorded once, not every run until the wallet is topped up
+			if s.lastStatus(a.Address, j.master) != StatusNeedsGas {
+				sw.Status = StatusNeedsGas
+				sw.Error = fmt.Sprintf("balance %s TON is below jetton_fee %s TON", balance.String(), s.jettonFee.String())
+				res = append(res, sw)
+			}
+			continue
+		}
+		tctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout.Duration)
+		tx, err := s.ln.SweepJetton(tctx, key, j.master, s.cfg.Treasury, amount, s.jettonFee)
+		cancel()
+		finish(&sw, tx, err)
+		res = append(res, sw)
+		if err != nil {
+			jettonsLeft = true
+		} else {
+			// the fee left, see what remains for the ton sweep
+			if balance, err = s.balance(ctx, a.Address); err != nil {
+				lg.Warn().Err(err).Msg("get deposit balance")
+				return res
+			}
+		}
+	}
+
+	keep := tlb.ZeroCoins
+	if jettonsLeft {
+		keep = s.jettonFee
+	}
+	amount := new(big.Int).Sub(balance.Nano(), keep.Nano())
+	if amount.Cmp(s.minBalance.Nano()) < 0 {
+		return res
+	}
+	sw := newSweep(AssetTON, amount)
+	tctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout.Duration)
+	tx, err := s.ln.SweepTON(tctx, key, s.cfg.Treasury, keep)
+	cancel()
+	finish(&sw, tx, err)
+	return append(res, sw)
+}
+
+func (s *Sweeper) balance(ctx context.Context, addr string) (tlb.Coins, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout.Duration)
+	defer cancel()
+	return s.ln.GetBalance(ctx, addr)
+}
+
+func (s *Sweeper) jettonBalance(ctx context.Context, master, addr string) (*big.Int, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout.Duration)
+	defer cancel()
+	return s.ln.JettonBalance(ctx, master, addr)
+}
+
+// lastStatus returns the status of the latest recorded sweep of asset from
+// addr, empty when there is none.
+func (s *Sweeper) lastStatus(addr, asset string) string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	for i := len(s.sweeps) - 1; i >= 0; i-- {
+		if sw := s.sweeps[i]; sw.Address == addr && sw.Asset == asset {
+			return sw.Status
+		}
+	}
+	return ""
+}
+
+func (s *Sweeper) record(sweeps []Sweep) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.sweeps = append(s.sweeps, sweeps...)
+	if n := len(s.sweeps) - maxSweeps; n > 0 {
+		s.sweeps = append([]Sweep(nil), s.sweeps[n:]...)
+	}
+	if err := s.file.Save(s.sweeps); err != nil {
+		s.log.Error().Err(err).Msg("save sweeps")
+	}
+}
+
+// List returns recorded sweeps, newest first, of one status when it isn't
+// empty.
+func (s *Sweeper) List(status string) []Sweep {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	res := []Sweep{}
+	for _, sw := range s.sweeps {
+		if status == "" || sw.Status == status {
+			res = append(res, sw)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].CreatedAt.After(res[j].CreatedAt)
+	})
+	return res
+}
@@ -0,0 +1,197 @@
+package sweep
+
+import (
+	"context"
+	"crypto/ed25519"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/deposits"
+	"github.com/FishDontExist/TONindexer/store"
+	"github.com/rs/zerolog"
+	"github.com/xssnick/tonutils-go/tlb"
+)
+
+const (
+	depositAddr = "EQDeposit"
+	master      = "EQMaster"
+	treasury    = "EQTreasury"
+)
+
+// fakeLite holds the balances of one deposit wallet. A jetton sweep spends
+// the fee, a ton sweep sends all but keep.
+type fakeLite struct {
+	balance *big.Int
+	jetton  *big.Int
+	tonErr  error
+
+	jettonSweeps int
+	keeps        []tlb.Coins
+}
+
+func (f *fakeLite) GetBalance(context.Context, string) (tlb.Coins, error) {
+	return tlb.FromNanoTON(f.balance), nil
+}
+
+func (f *fakeLite) JettonBalance(context.Context, string, string) (*big.Int, error) {
+	return new(big.Int).Set(f.jetton), nil
+}
+
+func (f *fakeLite) SweepTON(_ context.Context, _ ed25519.PrivateKey, _ string, keep tlb.Coins) (*tlb.Transaction, error) {
+	f.keeps = append(f.keeps, keep)
+	if f.tonErr != nil {
+		return nil, f.tonErr
+	}
+	f.balance = new(big.Int).Set(keep.Nano())
+	return &tlb.Transaction{Hash: []byte{1}}, nil
+}
+
+func (f *fakeLite) SweepJetton(_ context.Context, _ ed25519.PrivateKey, _, _ string, _ *big.Int, fee tlb.Coins) (*tlb.Transaction, error) {
+	f.jettonSweeps++
+	f.balance = new(big.Int).Sub(f.balance, fee.Nano())
+	f.jetton = new(big.Int)
+	return &tlb.Transaction{Hash: []byte{2}}, nil
+}
+
+type fakeRegistry struct{}
+
+func (fakeRegistry) List(string) []deposits.Address {
+	return []deposits.Address{{UserID: "u", Index: 1, Address: depositAddr}}
+}
+
+func (fakeRegistry) Key(string, uint32) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+}
+
+func newSweeper(t *testing.T, ln liteClient) *Sweeper {
+	t.Helper()
+	file, err := store.Open(t.TempDir(), "sweeps.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Sweeper{
+		cfg:        config.SweepConfig{Treasury: treasury, Timeout: config.Duration{Duration: time.Second}},
+		ln:         ln,
+		registry:   fakeRegistry{},
+		file:       file,
+		log:        zerolog.Nop(),
+		minBalance: tlb.MustFromTON("0.5"),
+		jettonFee:  tlb.MustFromTON("0.05"),
+		jettons:    []jettonAsset{{master: master, min: big.NewInt(100)}},
+	}
+}
+
+func ton(s string) *big.Int {
+	return tlb.MustFromTON(s).Nano()
+}
+
+func TestSweep(t *testing.T) {
+	tests := []struct {
+		name    string
+		balance string
+		jetton  int64
+		tonErr  error
+		// want are the asset and status of the sweeps made
+		want         [][2]string
+		jettonSweeps int
+		// keep is what the ton sweep leaves behind
+		keep string
+	}{
+		{
+			name:    "ton only",
+			balance: "2",
+			want:    [][2]string{{AssetTON, StatusDone}},
+			keep:    "0",
+		},
+		{
+			name:         "jetton then ton",
+			balance:      "2",
+			jetton:       500,
+			want:         [][2]string{{master, StatusDone}, {AssetTON, StatusDone}},
+			jettonSweeps: 1,
+			keep:         "0",
+		},
+		{
+			name:    "jetton below minimum keeps its fee",
+			balance: "2",
+			jetton:  50,
+			want:    [][2]string{{AssetTON, StatusDone}},
+			keep:    "0.05",
+		},
+		{
+			name:    "jetton without gas",
+			balance: "0.01",
+			jetton:  500,
+			want:    [][2]string{{master, StatusNeedsGas}},
+		},
+		{
+			name:    "ton below min_balance",
+			balance: "0.4",
+		},
+		{
+			name:    "ton sweep fails",
+			balance: "2",
+			tonErr:  chain.ErrTimeout,
+			want:    [][2]string{{AssetTON, StatusFailed}},
+			keep:    "0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln := &fakeLite{balance: ton(tt.balance), jetton: big.NewInt(tt.jetton), tonErr: tt.tonErr}
+			s := newSweeper(t, ln)
+			got := s.Sweep(context.Background())
+			if len(got) != len(tt.want) {
+				t.Fatalf("Sweep() made %d sweeps, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, sw := range got {
+				if sw.Asset != tt.want[i][0] || sw.Status != tt.want[i][1] {
+					t.Errorf("sweep %d is %s %s, want %s %s", i, sw.Asset, sw.Status, tt.want[i][0], tt.want[i][1])
+				}
+				if (sw.Status == StatusFailed || sw.Status == StatusNeedsGas) != (sw.Error != "") {
+					t.Errorf("sweep %d has status %s and error %q", i, sw.Status, sw.Error)
+				}
+			}
+			if got := s.List(""); len(got) != len(tt.want) {
+				t.Errorf("List() has %d sweeps, want %d", len(got), len(tt.want))
+			}
+			if ln.jettonSweeps != tt.jettonSweeps {
+				t.Errorf("jetton sweeps = %d, want %d", ln.jettonSweeps, tt.jettonSweeps)
+			}
+			if tt.keep == "" {
+				if len(ln.keeps) > 0 {
+					t.Errorf("ton swept keeping %v, want no ton sweep", ln.keeps)
+				}
+				return
+			}
+			if len(ln.keeps) != 1 || ln.keeps[0].Nano().Cmp(ton(tt.keep)) != 0 {
+				t.Errorf("ton swept keeping %v, want %s", ln.keeps, tt.keep)
+			}
+		})
+	}
+}
+
+func TestSweepNeedsGasRecordedOnce(t *testing.T) {
+	ln := &fakeLite{balance: ton("0.01"), jetton: big.NewInt(500)}
+	s := newSweeper(t, ln)
+	s.Sweep(context.Background())
+	if got := s.Sweep(context.Background()); len(got) != 0 {
+		t.Errorf("second Sweep() = %+v, want no sweeps", got)
+	}
+	if got := s.List(StatusNeedsGas); len(got) != 1 {
+		t.Errorf("List(needs_gas) has %d sweeps, want 1", len(got))
+	}
+
+	// topped up, the jetton goes and its fee isn't kept anymore
+	ln.balance = ton("1")
+	got := s.Sweep(context.Background())
+	if len(got) != 2 || got[0].Status != StatusDone || got[1].Asset != AssetTON {
+		t.Errorf("Sweep() after top up = %+v, want the jetton and ton swept", got)
+	}
+	if len(ln.keeps) != 1 || ln.keeps[0].Nano().Sign() != 0 {
+		t.Errorf("ton swept keeping %v, want 0", ln.keeps)
+	}
+}
@@ -0,0 +1,468 @@
+// Package transfers follows messages sent by custodial wallets to the
+// transaction of their destination, and back when they bounce.
+//
+// An internal message is identified by its sender and creation lt, which is
+// how the receiving transaction is recognized, from scanner events or by
+// polling the receiver through the liteserver when the scanner is late or
+// disabled.
+//
+// Jetton transfers take one more hop: the wallet sends to its own jetton
+// wallet, which passes the jettons on to the jetton wallet of the recipient.
+// They are delivered once that second hop is accepted.
+package transfers
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/store"
+	"github.com/rs/zerolog"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+)
+
+// Transfer statuses.
+const (
+	// StatusPending transfers wait for the destination transaction.
+	StatusPending = "pending"
+	// StatusDelivered transfers were accepted by the destination.
+	StatusDelivered = "delivered"
+	// StatusFailed transfers were rejected by the destination, or never
+	// left the wallet. Bounceable ones become StatusBounced once the
+	// bounce is back.
+	StatusFailed = "failed"
+	// StatusBounced transfers came back to the wallet.
+	StatusBounced = "bounced"
+)
+
+// maxTransfers is how many transfers are kept, older ones are dropped first.
+const maxTransfers = 10000
+
+// pollLimit bounds the transactions of a receiver read by one poll, a busy
+// receiver is read over several.
+const pollLimit = 256
+
+// opInternalTransfer is the op of the message a jetton wallet sends to the
+// jetton wallet of the recipient.
+const opInternalTransfer = 0x178d4519
+
+type Transfer struct {
+	// ID is the hex hash of the wallet transaction that sent the transfer.
+	ID     string `json:"id"`
+	Wallet string `json:"wallet"`
+	// To is the destination of the awaited hop, the jetton wallet of the
+	// recipient for jetton transfers past their first hop.
+	To string `json:"to"`
+	// Jetton transfers are followed to the jetton wallet of the recipient.
+	// JettonWallet is the one of the sender, set once the first hop
+	// arrived, it sends the second hop and gets its bounce.
+	Jetton       bool   `json:"jetton,omitempty"`
+	JettonWallet string `json:"jetton_wallet,omitempty"`
+	// Amount is in nanotons.
+	Amount    string `json:"amount"`
+	Bounce    bool   `json:"bounce"`
+	CreatedLT uint64 `json:"created_lt"`
+	Status    string `json:"status"`
+	// DestTxHash is of the transaction of the destination that received it.
+	DestTxHash string `json:"dest_tx_hash,omitempty"`
+	// ExitCode is of the compute phase, ResultCode of the action phase of
+	// the destination transaction. Reason is set when compute was skipped.
+	ExitCode   *int32 `json:"exit_code,omitempty"`
+	ResultCode *int32 `json:"result_code,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	// BounceLT is the creation lt of the bounce sent back, while it's
+	// awaited.
+	BounceLT     uint64 `json:"bounce_lt,omitempty"`
+	BounceTxHash string `json:"bounce_tx_hash,omitempty"`
+	// BouncedAmount is in nanotons, what came back after fees.
+	BouncedAmount string    `json:"bounced_amount,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// receiver is the account whose transaction t waits for, and the sender and
+// creation lt of the message it receives.
+func (t *Transfer) receiver() (to, from string, lt uint64, ok bool) {
+	sender := t.Wallet
+	if t.JettonWallet != "" {
+		sender = t.JettonWallet
+	}
+	switch {
+	case t.Status == StatusPending:
+		return t.To, sender, t.CreatedLT, true
+	case t.Status == StatusFailed && t.BounceLT != 0:
+		return sender, t.To, t.BounceLT, true
+	}
+	return "", "", 0, false
+}
+
+type Tracker struct {
+	cfg  config.TransferConfig
+	ln   *chain.LiteClient
+	file *store.File
+	log  zerolog.Logger
+
+	mx        sync.Mutex
+	transfers []*Transfer
+	// waiting maps the sender and creation lt of awaited messages to their
+	// transfer.
+	waiting map[string]*Transfer
+	// cursors are how far polling got, by the keys of waiting.
+	cursors map[string]*pollCursor
+}
+
+func New(cfg *config.Config, ln *chain.LiteClient, lg zerolog.Logger) (*Tracker, error) {
+	t := &Tracker{
+		cfg:     cfg.Transfers,
+		ln:      ln,
+		log:     lg.With().Str("component", "transfers").Logger(),
+		waiting: map[string]*Transfer{},
+		cursors: map[string]*pollCursor{},
+	}
+	var err error
+	if t.file, err = store.Open(cfg.Storage.Dir, "transfers.json"); err != nil {
+		return nil, err
+	}
+	if err = t.file.Load(&t.transfers); err != nil {
+		return nil, fmt.Errorf("load transfers: %w", err)
+	}
+	for _, tr := range t.transfers {
+		t.wait(tr)
+	}
+	return t, nil
+}
+
+func msgKey(from *address.Address, lt uint64) string {
+	return fmt.Sprintf("%s:%d", chain.RawAddr(from), lt)
+}
+
+// wait indexes tr by the message it waits for, it must be called with mx
+// held.
+func (t *Tracker) wait(tr *Transfer) {
+	_, from, lt, ok := tr.receiver()
+	if !ok {
+		return
+	}
+	addr, err := address.ParseAddr(from)
+	if err != nil {
+		t.log.Warn().Err(err).Str("transfer", tr.ID).Msg("bad transfer address")
+		return
+	}
+	t.waiting[msgKey(addr, lt)] = tr
+}
+
+// save must be called with mx held.
+func (t *Tracker) save() {
+	if err := t.file.Save(t.transfers); err != nil {
+		t.log.Error().Err(err).Msg("save transfers")
+	}
+}
+
+// Track starts following the transfer sent by wallet transaction tx, of a
+// basechain wallet. A transaction that sent nothing is recorded as failed.
+func (t *Tracker) Track(tx *tlb.Transaction) Transfer {
+	return t.track(tx, false)
+}
+
+// TrackJetton is Track for a jetton transfer, sent to the jetton wallet of
+// the wallet.
+func (t *Tracker) TrackJetton(tx *tlb.Transaction) Transfer {
+	return t.track(tx, true)
+}
+
+func (t *Tracker) track(tx *tlb.Transaction, jetton bool) Transfer {
+	now := time.Now().UTC()
+	tr := &Transfer{
+		ID:        hex.EncodeToString(tx.Hash),
+		Wallet:    t.ln.FormatAddr(address.NewAddress(0, 0, tx.AccountAddr)),
+		Jetton:    jetton,
+		Status:    StatusFailed,
+		Reason:    "no message was sent",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if tx.IO.Out != nil {
+		out, err := tx.IO.Out.ToSlice()
+		if err != nil {
+			t.log.Warn().Err(err).Str("tx", tr.ID).Msg("load out messages")
+		}
+		for _, m := range out {
+			if m.MsgType != tlb.MsgTypeInternal {
+				continue
+			}
+			in := m.AsInternal()
+			tr.To = t.ln.FormatAddr(in.DstAddr)
+			tr.Amount = in.Amount.Nano().String()
+			tr.Bounce = in.Bounce
+			tr.CreatedLT = in.CreatedLT
+			tr.Status, tr.Reason = StatusPending, ""
+			break
+		}
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.transfers = append(t.transfers, tr)
+	if n := len(t.transfers) - maxTransfers; n > 0 {
+		for _, old := range t.transfers[:n] {
+			t.unwait(old)
+		}
+		t.transfers = append([]*Transfer(nil), t.transfers[n:]...)
+	}
+	t.wait(tr)
+	t.save()
+	return *tr
+}
+
+// unwait must be called with mx held.
+func (t *Tracker) unwait(tr *Transfer) {
+	for k, w := range t.waiting {
+		if w == tr {
+			delete(t.waiting, k)
+		}
+	}
+}
+
+// HandleTransaction updates the transfer whose message tx of addr received.
+func (t *Tracker) HandleTransaction(addr *address.Address, tx *tlb.Transaction) {
+	if tx.IO.In == nil || tx.IO.In.MsgType != tlb.MsgTypeInternal {
+		return
+	}
+	in := tx.IO.In.AsInternal()
+	key := msgKey(in.SrcAddr, in.CreatedLT)
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	tr, ok := t.waiting[key]
+	if !ok {
+		return
+	}
+	to, _, _, _ := tr.receiver()
+	if dst, err := address.ParseAddr(to); err != nil || chain.RawAddr(dst) != chain.RawAddr(addr) {
+		return
+	}
+	delete(t.waiting, key)
+
+	hash := hex.EncodeToString(tx.Hash)
+	if tr.Status == StatusFailed {
+		tr.Status, tr.BounceTxHash, tr.BouncedAmount = StatusBounced, hash, in.Amount.Nano().String()
+		tr.BounceLT = 0
+	} else {
+		tr.DestTxHash = hash
+		received(tr, tx)
+		if tr.Jetton && tr.JettonWallet == "" && tr.Status == StatusDelivered {
+			t.nextHop(tr, tx)
+		}
+		t.wait(tr)
+	}
+	tr.UpdatedAt = time.Now().UTC()
+	t.log.Info().Str("transfer", tr.ID).Str("status", tr.Status).Msg("transfer updated")
+	t.save()
+}
+
+// nextHop points tr, delivered to the jetton wallet of the sender by tx, at
+// the internal transfer it sent on. It must be called with mx held.
+func (t *Tracker) nextHop(tr *Transfer, tx *tlb.Transaction) {
+	tr.JettonWallet = tr.To
+	tr.Status, tr.Reason = StatusFailed, "jetton wallet sent no internal transfer"
+	if tx.IO.Out == nil {
+		return
+	}
+	out, err := tx.IO.Out.ToSlice()
+	if err != nil {
+		t.log.Warn().Err(err).Str("transfer", tr.ID).Msg("load out messages")
+		return
+	}
+	for _, m := range out {
+		if m.MsgType != tlb.MsgTypeInternal {
+			continue
+		}
+		in := m.AsInternal()
+		if in.Body == nil {
+			continue
+		}
+		if op, err := in.Body.BeginParse().LoadUInt(32); err != nil || op != opInternalTransfer {
+			continue
+		}
+		tr.To = t.ln.FormatAddr(in.DstAddr)
+		tr.Bounce = in.Bounce
+		tr.CreatedLT = in.CreatedLT
+		tr.DestTxHash, tr.ExitCode, tr.ResultCode = "", nil, nil
+		tr.Status, tr.Reason = StatusPending, ""
+		return
+	}
+}
+
+// received sets the outcome of tr from the destination transaction tx.
+func received(tr *Transfer, tx *tlb.Transaction) {
+	d, ok := tx.Description.Description.(tlb.TransactionDescriptionOrdinary)
+	if !ok {
+		tr.Status = StatusDelivered
+		return
+	}
+	skipped := false
+	switch p := d.ComputePhase.Phase.(type) {
+	case tlb.ComputePhaseVM:
+		code := p.Details.ExitCode
+		tr.ExitCode = &code
+	case tlb.ComputePhaseSkipped:
+		tr.Reason = string(p.Reason.Type)
+		skipped = p.Reason.Type == tlb.ComputeSkipReasonNoState
+	}
+	if d.ActionPhase != nil {
+		code := d.ActionPhase.ResultCode
+		tr.ResultCode = &code
+	}
+
+	switch {
+	case !d.Aborted:
+		tr.Status = StatusDelivered
+	case skipped && !tr.Bounce:
+		// an uninitialized account keeps non-bounceable messages
+		tr.Status = StatusDelivered
+	default:
+		tr.Status = StatusFailed
+	}
+	if tr.Status != StatusFailed || d.BouncePhase == nil {
+		return
+	}
+	if _, ok := d.BouncePhase.Phase.(tlb.BouncePhaseOk); !ok {
+		return
+	}
+	if tx.IO.Out == nil {
+		return
+	}
+	out, err := tx.IO.Out.ToSlice()
+	if err != nil {
+		return
+	}
+	for _, m := range out {
+		if m.MsgType == tlb.MsgTypeInternal && m.AsInternal().Bounced {
+			tr.BounceLT = m.AsInternal().CreatedLT
+		}
+	}
+}
+
+// Run polls the receivers of transfers the scanner hasn't resolved until ctx
+// is done.
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.cfg.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		t.poll(ctx)
+	}
+}
+
+type pollTarget struct {
+	key    string
+	to     *address.Address
+	cursor pollCursor
+}
+
+// pollCursor is how far polling the receiver of a message got. Polls walk
+// back from the last transaction of the receiver to floor, a page at a
+// time, and the next walk stops at the top of the previous one.
+type pollCursor struct {
+	// next is where the walk continues, zero to start a new one.
+	next chain.TxRef
+	// top is the newest transaction of the current walk.
+	top   uint64
+	floor uint64
+}
+
+func (t *Tracker) poll(ctx context.Context) {
+	// give the scanner an interval first
+	cutoff := time.Now().Add(-t.cfg.Interval.Duration)
+	t.mx.Lock()
+	for key := range t.cursors {
+		if _, ok := t.waiting[key]; !ok {
+			delete(t.cursors, key)
+		}
+	}
+	var targets []pollTarget
+	for key, tr := range t.waiting {
+		if tr.UpdatedAt.After(cutoff) {
+			continue
+		}
+		to, _, lt, _ := tr.receiver()
+		addr, err := address.ParseAddr(to)
+		if err != nil {
+			continue
+		}
+		// the receiving transaction is after the message was created
+		p := pollTarget{key: key, to: addr, cursor: pollCursor{floor: lt}}
+		if c, ok := t.cursors[key]; ok {
+			p.cursor = *c
+		}
+		targets = append(targets, p)
+	}
+	t.mx.Unlock()
+
+	for _, p := range targets {
+		if ctx.Err() != nil {
+			return
+		}
+		c := p.cursor
+		pctx, cancel := context.WithTimeout(ctx, t.cfg.Timeout.Duration)
+		list, next, err := t.ln.TransactionsPage(pctx, p.to, c.next, c.floor, pollLimit)
+		cancel()
+		if err != nil {
+			t.log.Warn().Err(err).Str("address", p.to.String()).Msg("poll transfer receiver")
+			continue
+		}
+		if c.next.LT == 0 && len(list) > 0 {
+			c.top = list[0].LT
+		}
+		for _, tx := range list {
+			t.HandleTransaction(p.to, tx)
+		}
+		if c.next = next; next.LT == 0 && c.top > c.floor {
+			c.floor = c.top
+		}
+
+		t.mx.Lock()
+		if _, ok := t.waiting[p.key]; ok {
+			t.cursors[p.key] = &c
+		}
+		t.mx.Unlock()
+	}
+}
+
+func (t *Tracker) Get(id string) (Transfer, error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	for _, tr := range t.transfers {
+		if tr.ID == id {
+			return *tr, nil
+		}
+	}
+	return Transfer{}, fmt.Errorf("%w: transfer %q", chain.ErrNotFound, id)
+}
+
+// List returns tracked transfers, newest first, of one status when it isn't
+// empty.
+func (t *Tracker) List(status string) []Transfer {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	res := []Transfer{}
+	for _, tr := range t.transfers {
+		if status == "" || tr.Status == status {
+			res = append(res, *tr)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].CreatedAt.After(res[j].CreatedAt)
+	})
+	return res
+}
@@ -0,0 +1,196 @@
+package transfers
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+// outMessages builds the out message list of a transaction.
+func outMessages(t *testing.T, msgs ...*tlb.InternalMessage) *tlb.MessagesList {
+	t.Helper()
+	dict := cell.NewDict(15)
+	for i, m := range msgs {
+		c, err := tlb.ToCell(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = dict.SetIntKey(big.NewInt(int64(i)), cell.BeginCell().MustStoreRef(c).EndCell()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &tlb.MessagesList{List: dict}
+}
+
+func vm(exitCode int32) tlb.ComputePhase {
+	p := tlb.ComputePhaseVM{Success: exitCode == 0}
+	p.Details.ExitCode = exitCode
+	return tlb.ComputePhase{Phase: p}
+}
+
+func skipped(reason tlb.ComputeSkipReasonType) tlb.ComputePhase {
+	return tlb.ComputePhase{Phase: tlb.ComputePhaseSkipped{Reason: tlb.ComputeSkipReason{Type: reason}}}
+}
+
+func TestReceived(t *testing.T) {
+	wallet := address.NewAddress(0, 0, make([]byte, 32))
+	dest := address.NewAddress(0, 0, append(make([]byte, 31), 1))
+	bounce := &tlb.InternalMessage{
+		IHRDisabled: true,
+		Bounced:     true,
+		SrcAddr:     dest,
+		DstAddr:     wallet,
+		Amount:      tlb.MustFromTON("0.9"),
+		CreatedLT:   77,
+	}
+	bouncePhase := &tlb.BouncePhase{Phase: tlb.BouncePhaseOk{}}
+
+	tests := []struct {
+		name           string
+		bounceable     bool
+		desc           any
+		out            []*tlb.InternalMessage
+		wantStatus     string
+		wantExitCode   *int32
+		wantResultCode *int32
+		wantReason     string
+		wantBounceLT   uint64
+	}{
+		{
+			name:       "not ordinary",
+			desc:       tlb.TransactionDescriptionTickTock{},
+			wantStatus: StatusDelivered,
+		},
+		{
+			name:           "accepted",
+			desc:           tlb.TransactionDescriptionOrdinary{ComputePhase: vm(0), ActionPhase: &tlb.ActionPhase{Success: true}},
+			wantStatus:     StatusDelivered,
+			wantExitCode:   ptr(0),
+			wantResultCode: ptr(0),
+		},
+		{
+			name:         "compute failed and bounced",
+			bounceable:   true,
+			desc:         tlb.TransactionDescriptionOrdinary{ComputePhase: vm(33), Aborted: true, BouncePhase: bouncePhase},
+			out:          []*tlb.InternalMessage{bounce},
+			wantStatus:   StatusFailed,
+			wantExitCode: ptr(33),
+			wantBounceLT: 77,
+		},
+		{
+			name:           "action failed",
+			bounceable:     true,
+			desc:           tlb.TransactionDescriptionOrdinary{ComputePhase: vm(0), ActionPhase: &tlb.ActionPhase{ResultCode: 37}, Aborted: true, BouncePhase: bouncePhase},
+			out:            []*tlb.InternalMessage{bounce},
+			wantStatus:     StatusFailed,
+			wantExitCode:   ptr(0),
+			wantResultCode: ptr(37),
+			wantBounceLT:   77,
+		},
+		{
+			name:         "bounce without funds",
+			bounceable:   true,
+			desc:         tlb.TransactionDescriptionOrdinary{ComputePhase: vm(33), Aborted: true, BouncePhase: &tlb.BouncePhase{Phase: tlb.BouncePhaseNoFunds{}}},
+			wantStatus:   StatusFailed,
+			wantExitCode: ptr(33),
+		},
+		{
+			name:         "failed without a bounce message",
+			bounceable:   true,
+			desc:         tlb.TransactionDescriptionOrdinary{ComputePhase: vm(33), Aborted: true, BouncePhase: bouncePhase},
+			wantStatus:   StatusFailed,
+			wantExitCode: ptr(33),
+		},
+		{
+			// an uninitialized account keeps non-bounceable messages
+			name:       "non-bounceable to uninitialized",
+			desc:       tlb.TransactionDescriptionOrdinary{ComputePhase: skipped(tlb.ComputeSkipReasonNoState), Aborted: true},
+			wantStatus: StatusDelivered,
+			wantReason: string(tlb.ComputeSkipReasonNoState),
+		},
+		{
+			name:         "bounceable to uninitialized",
+			bounceable:   true,
+			desc:         tlb.TransactionDescriptionOrdinary{ComputePhase: skipped(tlb.ComputeSkipReasonNoState), Aborted: true, BouncePhase: bouncePhase},
+			out:          []*tlb.InternalMessage{bounce},
+			wantStatus:   StatusFailed,
+			wantReason:   string(tlb.ComputeSkipReasonNoState),
+			wantBounceLT: 77,
+		},
+		{
+			name:       "no gas",
+			desc:       tlb.TransactionDescriptionOrdinary{ComputePhase: skipped(tlb.ComputeSkipReasonNoGas), Aborted: true},
+			wantStatus: StatusFailed,
+			wantReason: string(tlb.ComputeSkipReasonNoGas),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &tlb.Transaction{Description: tlb.TransactionDescription{Description: tt.desc}}
+			if tt.out != nil {
+				tx.IO.Out = outMessages(t, tt.out...)
+			}
+			tr := &Transfer{Status: StatusPending, Bounce: tt.bounceable}
+			received(tr, tx)
+
+			if tr.Status != tt.wantStatus {
+				t.Errorf("Status = %q, want %q", tr.Status, tt.wantStatus)
+			}
+			if !equal(tr.ExitCode, tt.wantExitCode) {
+				t.Errorf("ExitCode = %v, want %v", deref(tr.ExitCode), deref(tt.wantExitCode))
+			}
+			if !equal(tr.ResultCode, tt.wantResultCode) {
+				t.Errorf("ResultCode = %v, want %v", deref(tr.ResultCode), deref(tt.wantResultCode))
+			}
+			if tr.Reason != tt.wantReason {
+				t.Errorf("Reason = %q, want %q", tr.Reason, tt.wantReason)
+			}
+			if tr.BounceLT != tt.wantBounceLT {
+				t.Errorf("BounceLT = %d, want %d", tr.BounceLT, tt.wantBounceLT)
+			}
+		})
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	tests := []struct {
+		name     string
+		tr       Transfer
+		wantTo   string
+		wantFrom string
+		wantLT   uint64
+		wantOK   bool
+	}{
+		{name: "pending", tr: Transfer{Wallet: "w", To: "d", CreatedLT: 5, Status: StatusPending}, wantTo: "d", wantFrom: "w", wantLT: 5, wantOK: true},
+		{name: "awaiting bounce", tr: Transfer{Wallet: "w", To: "d", CreatedLT: 5, BounceLT: 9, Status: StatusFailed}, wantTo: "w", wantFrom: "d", wantLT: 9, wantOK: true},
+		{name: "jetton second hop", tr: Transfer{Wallet: "w", JettonWallet: "jw", To: "rjw", CreatedLT: 7, Jetton: true, Status: StatusPending}, wantTo: "rjw", wantFrom: "jw", wantLT: 7, wantOK: true},
+		{name: "jetton second hop bounce", tr: Transfer{Wallet: "w", JettonWallet: "jw", To: "rjw", BounceLT: 11, Jetton: true, Status: StatusFailed}, wantTo: "jw", wantFrom: "rjw", wantLT: 11, wantOK: true},
+		{name: "failed without bounce", tr: Transfer{Wallet: "w", To: "d", Status: StatusFailed}},
+		{name: "delivered", tr: Transfer{Wallet: "w", To: "d", Status: StatusDelivered}},
+		{name: "bounced", tr: Transfer{Wallet: "w", To: "d", Status: StatusBounced}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			to, from, lt, ok := tt.tr.receiver()
+			if to != tt.wantTo || from != tt.wantFrom || lt != tt.wantLT || ok != tt.wantOK {
+				t.Errorf("receiver() = %q, %q, %d, %v, want %q, %q, %d, %v", to, from, lt, ok, tt.wantTo, tt.wantFrom, tt.wantLT, tt.wantOK)
+			}
+		})
+	}
+}
+
+func ptr(v int32) *int32 { return &v }
+
+func equal(a, b *int32) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+func deref(v *int32) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
@@ -0,0 +1,194 @@
+// Package traverse walks the block DAG backwards from shard tops.
+//
+// A shard block normally has one parent. After a split both halves point to
+// the same parent block, and after a merge a block has two parents, one per
+// merged half. Following only the first parent loses history on merges, so
+// every walk here follows all parents and dedupes blocks by their full id.
+package traverse
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/xssnick/tonutils-go/ton"
+)
+
+// Key identifies a block by every field of its BlockIDExt, so blocks with
+// the same seqno but different hashes are told apart.
+type Key struct {
+	Workchain int32
+	Shard     int64
+	SeqNo     uint32
+	RootHash  [32]byte
+	FileHash  [32]byte
+}
+
+func KeyOf(b *ton.BlockIDExt) Key {
+	k := Key{Workchain: b.Workchain, Shard: b.Shard, SeqNo: b.SeqNo}
+	copy(k.RootHash[:], b.RootHash)
+	copy(k.FileHash[:], b.FileHash)
+	return k
+}
+
+// Set is a set of blocks keyed by their full id.
+type Set map[Key]*ton.BlockIDExt
+
+func NewSet(blocks ...*ton.BlockIDExt) Set {
+	s := Set{}
+	for _, b := range blocks {
+		s.Add(b)
+	}
+	return s
+}
+
+// Add reports whether b was not in the set yet.
+func (s Set) Add(b *ton.BlockIDExt) bool {
+	k := KeyOf(b)
+	if _, ok := s[k]; ok {
+		return false
+	}
+	s[k] = b
+	return true
+}
+
+func (s Set) Has(b *ton.BlockIDExt) bool {
+	_, ok := s[KeyOf(b)]
+	return ok
+}
+
+type Walker struct {
+	api ton.APIClientWrapped
+}
+
+func New(api ton.APIClientWrapped) *Walker {
+	return &Walker{api: api}
+}
+
+// Parents returns the parent blocks of b: one, or two right after a merge.
+func (w *Walker) Parents(ctx context.Context, b *ton.BlockIDExt) ([]*ton.BlockIDExt, error) {
+	data, err := w.api.GetBlockData(ctx, b)
+	if err != nil {
+		return nil, fmt.Errorf("get block data %d:%x:%d: %w", b.Workchain, uint64(b.Shard), b.SeqNo, err)
+	}
+	parents, err := data.BlockInfo.GetParentBlocks()
+	if err != nil {
+		return nil, fmt.Errorf("get parent blocks %d:%x:%d: %w", b.Workchain, uint64(b.Shard), b.SeqNo, err)
+	}
+	return parents, nil
+}
+
+// Walk visits starts and their ancestors breadth first, newest first, each
+// block once. Blocks for which stop returns true are neither visited nor
+// followed further. A positive limit caps the number of visited blocks.
+func (w *Walker) Walk(ctx context.Context, starts []*ton.BlockIDExt, stop func(*ton.BlockIDExt) bool, limit int) ([]*ton.BlockIDExt, error) {
+	seen := Set{}
+	var frontier, visited []*ton.BlockIDExt
+	add := func(b *ton.BlockIDExt) {
+		if (stop == nil || !stop(b)) && seen.Add(b) {
+			frontier = append(frontier, b)
+		}
+	}
+	for _, b := range starts {
+		add(b)
+	}
+
+	for len(frontier) > 0 {
+		if limit > 0 && len(visited)+len(frontier) > limit {
+			frontier = frontier[:limit-len(visited)]
+		}
+		visited = append(visited, frontier...)
+		if limit > 0 && len(visited) >= limit {
+			break
+		}
+
+		parents, err := w.parentsOf(ctx, frontier)
+		if err != nil {
+			return nil, err
+		}
+		frontier = nil
+		for _, p := range parents {
+			add(p)
+		}
+	}
+	return visited, nil
+}
+
+// parentsOf fetches the parents of all blocks concurrently, keeping the
+// order of blocks. Zero state blocks have no parents.
+func (w *Walker) parentsOf(ctx context.Context, blocks []*ton.BlockIDExt) ([]*ton.BlockIDExt, error) {
+	res := make([][]*ton.BlockIDExt, len(blocks))
+	errs := make([]error, len(blocks))
+
+	var wg sync.WaitGroup
+	for i, b := range blocks {
+		if b.SeqNo == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, b *ton.BlockIDExt) {
+			defer wg.Done()
+			res[i], errs[i] = w.Parents(ctx, b)
+		}(i, b)
+	}
+	wg.Wait()
+
+	var parents []*ton.BlockIDExt
+	for i := range blocks {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		parents = append(parents, res[i]...)
+	}
+	return parents, nil
+}
+
+// NewShardBlocks returns the blocks reachable from shards, the shard tops of
+// a masterchain block, that are not reachable from prevShards, the tops of
+// the previous one. They are sorted oldest first.
+func (w *Walker) NewShardBlocks(ctx context.Context, shards, prevShards []*ton.BlockIDExt) ([]*ton.BlockIDExt, error) {
+	prev := NewSet(prevShards...)
+	blocks, err := w.Walk(ctx, shards, prev.Has, 0)
+	if err != nil {
+		return nil, err
+	}
+	SortOldestFirst(blocks)
+	return blocks, nil
+}
+
+// MasterBlocks returns the masterchain blocks after from up to and including
+// to, oldest first, following parent links so hashes are checked on the way.
+func (w *Walker) MasterBlocks(ctx context.Context, from, to *ton.BlockIDExt) ([]*ton.BlockIDExt, error) {
+	if to.SeqNo <= from.SeqNo {
+		return nil, nil
+	}
+	end := KeyOf(from)
+	blocks, err := w.Walk(ctx, []*ton.BlockIDExt{to}, func(b *ton.BlockIDExt) bool {
+		return b.SeqNo <= from.SeqNo
+	}, int(to.SeqNo-from.SeqNo))
+	if err != nil {
+		return nil, err
+	}
+
+	oldest := blocks[len(blocks)-1]
+	parents, err := w.Parents(ctx, oldest)
+	if err != nil {
+		return nil, err
+	}
+	if len(parents) != 1 || KeyOf(parents[0]) != end {
+		return nil, fmt.Errorf("masterchain block %d doesn't follow block %d", oldest.SeqNo, from.SeqNo)
+	}
+	SortOldestFirst(blocks)
+	return blocks, nil
+}
+
+// SortOldestFirst orders blocks by seqno, then by shard.
+func SortOldestFirst(blocks []*ton.BlockIDExt) {
+	sort.Slice(blocks, func(i, j int) bool {
+		if blocks[i].SeqNo == blocks[j].SeqNo {
+			return blocks[i].Shard < blocks[j].Shard
+		}
+		return blocks[i].SeqNo < blocks[j].SeqNo
+	})
+}
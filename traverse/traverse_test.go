@@ -0,0 +1,241 @@
+package traverse
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"testing"
+
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+)
+
+// fakeAPI serves the headers of a made up block DAG, any other method of
+// the interface panics.
+type fakeAPI struct {
+	ton.APIClientWrapped
+	headers map[Key]*tlb.BlockHeader
+}
+
+var errUnknownBlock = errors.New("unknown block")
+
+func (f *fakeAPI) GetBlockData(_ context.Context, b *ton.BlockIDExt) (*tlb.Block, error) {
+	h, ok := f.headers[KeyOf(b)]
+	if !ok {
+		return nil, errUnknownBlock
+	}
+	return &tlb.Block{BlockInfo: *h}, nil
+}
+
+// add records b with parents. Two parents make it a block after a merge,
+// a parent of a wider shard a block after a split.
+func (f *fakeAPI) add(b *ton.BlockIDExt, parents ...*ton.BlockIDExt) {
+	shard := uint64(b.Shard)
+	low := shard & -shard
+	h := &tlb.BlockHeader{}
+	h.SeqNo = b.SeqNo
+	h.Shard = tlb.ShardIdent{
+		PrefixBits:  int8(63 - bits.TrailingZeros64(shard)),
+		WorkchainID: b.Workchain,
+		ShardPrefix: shard &^ low,
+	}
+	ref := func(p *ton.BlockIDExt) tlb.ExtBlkRef {
+		return tlb.ExtBlkRef{SeqNo: p.SeqNo, RootHash: p.RootHash, FileHash: p.FileHash}
+	}
+	h.PrevRef.Prev1 = ref(parents[0])
+	switch {
+	case len(parents) == 2:
+		h.AfterMerge = true
+		prev2 := ref(parents[1])
+		h.PrevRef.Prev2 = &prev2
+	case parents[0].Shard != b.Shard:
+		h.AfterSplit = true
+	}
+	f.headers[KeyOf(b)] = h
+}
+
+func block(shard uint64, seqno uint32) *ton.BlockIDExt {
+	var id [12]byte
+	binary.BigEndian.PutUint64(id[:], shard)
+	binary.BigEndian.PutUint32(id[8:], seqno)
+	root := sha256.Sum256(append([]byte("root"), id[:]...))
+	file := sha256.Sum256(append([]byte("file"), id[:]...))
+	return &ton.BlockIDExt{Workchain: 0, Shard: int64(shard), SeqNo: seqno, RootHash: root[:], FileHash: file[:]}
+}
+
+const (
+	fullShard  = 1 << 63
+	leftShard  = 1 << 62
+	rightShard = 3 << 62
+)
+
+// dag is a shard that splits after block 10 and merges again at block 13:
+//
+//	zero <- p10 <- l11 <- l12 <- m13
+//	           \        \      /
+//	            r11 <- r12 ---'
+type dag struct {
+	api                                *fakeAPI
+	zero, p10, l11, r11, l12, r12, m13 *ton.BlockIDExt
+}
+
+func newDAG() *dag {
+	d := &dag{
+		api:  &fakeAPI{headers: map[Key]*tlb.BlockHeader{}},
+		zero: block(fullShard, 0),
+		p10:  block(fullShard, 10),
+		l11:  block(leftShard, 11),
+		r11:  block(rightShard, 11),
+		l12:  block(leftShard, 12),
+		r12:  block(rightShard, 12),
+		m13:  block(fullShard, 13),
+	}
+	d.api.add(d.p10, d.zero)
+	d.api.add(d.l11, d.p10)
+	d.api.add(d.r11, d.p10)
+	d.api.add(d.l12, d.l11)
+	d.api.add(d.r12, d.r11)
+	d.api.add(d.m13, d.l12, d.r12)
+	return d
+}
+
+func TestParents(t *testing.T) {
+	d := newDAG()
+	w := New(d.api)
+	tests := []struct {
+		name  string
+		block *ton.BlockIDExt
+		want  []*ton.BlockIDExt
+	}{
+		{name: "plain", block: d.l12, want: []*ton.BlockIDExt{d.l11}},
+		{name: "after split", block: d.r11, want: []*ton.BlockIDExt{d.p10}},
+		{name: "after merge", block: d.m13, want: []*ton.BlockIDExt{d.l12, d.r12}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := w.Parents(context.Background(), tt.block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertBlocks(t, got, tt.want)
+		})
+	}
+}
+
+func TestWalk(t *testing.T) {
+	d := newDAG()
+	tests := []struct {
+		name    string
+		starts  []*ton.BlockIDExt
+		stop    func(*ton.BlockIDExt) bool
+		limit   int
+		want    []*ton.BlockIDExt
+		wantErr error
+	}{
+		{
+			// both halves of the merge are followed, and the block they
+			// split from is visited once
+			name:   "across merge and split",
+			starts: []*ton.BlockIDExt{d.m13},
+			want:   []*ton.BlockIDExt{d.m13, d.l12, d.r12, d.l11, d.r11, d.p10, d.zero},
+		},
+		{
+			name:   "stopped at the split",
+			starts: []*ton.BlockIDExt{d.m13},
+			stop:   NewSet(d.l11, d.r11).Has,
+			want:   []*ton.BlockIDExt{d.m13, d.l12, d.r12},
+		},
+		{
+			name:   "both halves of a split",
+			starts: []*ton.BlockIDExt{d.l12, d.r12},
+			stop:   NewSet(d.zero).Has,
+			want:   []*ton.BlockIDExt{d.l12, d.r12, d.l11, d.r11, d.p10},
+		},
+		{
+			name:   "same start twice",
+			starts: []*ton.BlockIDExt{d.l12, d.l12},
+			stop:   NewSet(d.p10).Has,
+			want:   []*ton.BlockIDExt{d.l12, d.l11},
+		},
+		{
+			name:   "limited",
+			starts: []*ton.BlockIDExt{d.m13},
+			limit:  4,
+			want:   []*ton.BlockIDExt{d.m13, d.l12, d.r12, d.l11},
+		},
+		{
+			name:    "missing block",
+			starts:  []*ton.BlockIDExt{block(leftShard, 20)},
+			wantErr: errUnknownBlock,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(d.api).Walk(context.Background(), tt.starts, tt.stop, tt.limit)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Walk() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertBlocks(t, got, tt.want)
+		})
+	}
+}
+
+func TestNewShardBlocks(t *testing.T) {
+	d := newDAG()
+	// the previous masterchain block saw the shard split in two
+	got, err := New(d.api).NewShardBlocks(context.Background(), []*ton.BlockIDExt{d.m13}, []*ton.BlockIDExt{d.l11, d.r11})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*ton.BlockIDExt{d.l12, d.r12, d.m13}
+	SortOldestFirst(want)
+	assertBlocks(t, got, want)
+	for i := 1; i < len(got); i++ {
+		if got[i].SeqNo < got[i-1].SeqNo {
+			t.Errorf("block %d has seqno %d after %d", i, got[i].SeqNo, got[i-1].SeqNo)
+		}
+	}
+}
+
+func TestSetKeysByHash(t *testing.T) {
+	a := block(fullShard, 10)
+	b := block(fullShard, 10)
+	b.RootHash = append([]byte(nil), b.RootHash...)
+	b.RootHash[0] ^= 1
+	s := NewSet(a)
+	if !s.Has(a) || s.Has(b) {
+		t.Errorf("Has() = %v, %v, want true, false", s.Has(a), s.Has(b))
+	}
+	if s.Add(a) || !s.Add(b) {
+		t.Error("Add() didn't tell blocks apart by hash")
+	}
+}
+
+func assertBlocks(t *testing.T, got, want []*ton.BlockIDExt) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d blocks %v, want %d %v", len(got), ids(got), len(want), ids(want))
+	}
+	for i := range want {
+		if KeyOf(got[i]) != KeyOf(want[i]) {
+			t.Fatalf("got blocks %v, want %v", ids(got), ids(want))
+		}
+	}
+}
+
+func ids(blocks []*ton.BlockIDExt) []string {
+	res := make([]string, len(blocks))
+	for i, b := range blocks {
+		res[i] = fmt.Sprintf("%d:%x:%d", b.Workchain, uint64(b.Shard), b.SeqNo)
+	}
+	return res
+}
@@ -0,0 +1,308 @@
+// Package verify checks indexed blocks against the chain.
+//
+// For a masterchain seqno range it rebuilds, from the liteserver, which shard
+// blocks each masterchain block made known: the blocks reachable through
+// parent links from its shard hashes and not from those of the previous one.
+// Every block is fetched as a raw BoC and checked against its root and file
+// hash, and the result is compared with what the scanner recorded.
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/FishDontExist/TONindexer/events"
+	"github.com/FishDontExist/TONindexer/index"
+	"github.com/FishDontExist/TONindexer/traverse"
+	"github.com/xssnick/tonutils-go/tl"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+// Issue kinds.
+const (
+	// MissingMaster is a masterchain block that was never indexed.
+	MissingMaster = "missing_master"
+	// MasterMismatch is an indexed masterchain block with other hashes.
+	MasterMismatch = "master_mismatch"
+	// MissingShard is a shard block of the masterchain block not indexed.
+	MissingShard = "missing_shard"
+	// UnreferencedShard is an indexed shard block the masterchain block
+	// doesn't lead to, e.g. from a fork served by a bad liteserver.
+	UnreferencedShard = "unreferenced_shard"
+	// DataMismatch is an indexed block with other gen_utime or
+	// transaction count than the chain.
+	DataMismatch = "data_mismatch"
+	// ProofMismatch is block data from the liteserver that doesn't hash to
+	// the requested block id.
+	ProofMismatch = "proof_mismatch"
+)
+
+type Issue struct {
+	Kind    string `json:"kind"`
+	Master  uint32 `json:"master_seqno"`
+	Block   string `json:"block,omitempty"`
+	Details string `json:"details,omitempty"`
+}
+
+type Report struct {
+	From        uint32   `json:"from"`
+	To          uint32   `json:"to"`
+	Masters     int      `json:"masters"`
+	ShardBlocks int      `json:"shard_blocks"`
+	Issues      []Issue  `json:"issues"`
+	Repaired    []uint32 `json:"repaired,omitempty"`
+	// Unrecorded are indexed blocks whose record was compacted away, they
+	// can't be compared.
+	Unrecorded []uint32 `json:"unrecorded,omitempty"`
+}
+
+type Verifier struct {
+	api    ton.APIClientWrapped
+	walker *traverse.Walker
+	idx    *index.Index
+}
+
+func New(api ton.APIClientWrapped, idx *index.Index) *Verifier {
+	return &Verifier{
+		api:    api,
+		walker: traverse.New(api),
+		idx:    idx,
+	}
+}
+
+// Run verifies masterchain blocks from..to. With repair, the corrected
+// record of blocks with issues is added to the index. Blocks the liteserver
+// served bad data for are only reported.
+func (v *Verifier) Run(ctx context.Context, from, to uint32, repair bool) (*Report, error) {
+	if from == 0 || to < from {
+		return nil, fmt.Errorf("invalid seqno range %d..%d", from, to)
+	}
+
+	top, err := v.api.GetMasterchainInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get masterchain info: %w", err)
+	}
+	if to > top.SeqNo {
+		return nil, fmt.Errorf("seqno %d is ahead of the chain at %d", to, top.SeqNo)
+	}
+	first, err := v.api.LookupBlock(ctx, top.Workchain, top.Shard, from-1)
+	if err != nil {
+		return nil, fmt.Errorf("lookup block %d: %w", from-1, err)
+	}
+	last, err := v.api.LookupBlock(ctx, top.Workchain, top.Shard, to)
+	if err != nil {
+		return nil, fmt.Errorf("lookup block %d: %w", to, err)
+	}
+	masters, err := v.walker.MasterBlocks(ctx, first, last)
+	if err != nil {
+		return nil, fmt.Errorf("collect masterchain blocks: %w", err)
+	}
+
+	indexed, err := v.idx.Blocks(from, to)
+	if err != nil {
+		return nil, err
+	}
+	prevTops, err := v.api.GetBlockShardsInfo(ctx, first)
+	if err != nil {
+		return nil, fmt.Errorf("get shards of %d: %w", first.SeqNo, err)
+	}
+
+	report := &Report{From: from, To: to}
+	for _, m := range masters {
+		tops, err := v.api.GetBlockShardsInfo(ctx, m)
+		if err != nil {
+			return nil, fmt.Errorf("get shards of %d: %w", m.SeqNo, err)
+		}
+		var basechain []*ton.BlockIDExt
+		for _, t := range tops {
+			if t.Workchain == 0 {
+				basechain = append(basechain, t)
+			}
+		}
+		shards, err := v.walker.NewShardBlocks(ctx, basechain, prevTops)
+		if err != nil {
+			return nil, fmt.Errorf("collect shard blocks of %d: %w", m.SeqNo, err)
+		}
+		prevTops = tops
+
+		actual, issues, err := v.rebuild(ctx, m, shards)
+		if err != nil {
+			return nil, err
+		}
+		report.Masters++
+		report.ShardBlocks += len(shards)
+		if len(issues) > 0 {
+			// the liteserver served bad data, there's nothing to compare to
+			report.Issues = append(report.Issues, issues...)
+			continue
+		}
+
+		rec, ok := indexed[m.SeqNo]
+		switch {
+		case ok:
+			issues = compare(rec, actual)
+		case v.idx.Has(m.SeqNo):
+			report.Unrecorded = append(report.Unrecorded, m.SeqNo)
+			continue
+		default:
+			issues = []Issue{{Kind: MissingMaster, Master: m.SeqNo}}
+		}
+		report.Issues = append(report.Issues, issues...)
+		if len(issues) > 0 && repair {
+			if err = v.idx.Add(actual); err != nil {
+				return nil, err
+			}
+			report.Repaired = append(report.Repaired, m.SeqNo)
+		}
+	}
+	return report, nil
+}
+
+// rebuild fetches master and its shard blocks and returns the record the
+// scanner should have made for them.
+func (v *Verifier) rebuild(ctx context.Context, master *ton.BlockIDExt, shards []*ton.BlockIDExt) (events.Block, []Issue, error) {
+	var issues []Issue
+	proofIssue := func(id *ton.BlockIDExt, err error) {
+		issues = append(issues, Issue{Kind: ProofMismatch, Master: master.SeqNo, Block: blockName(id), Details: err.Error()})
+	}
+
+	var genUtime uint32
+	mb, err := v.fetch(ctx, master)
+	if err != nil {
+		if !isProofError(err) {
+			return events.Block{}, nil, err
+		}
+		proofIssue(master, err)
+	} else {
+		genUtime = mb.BlockInfo.GenUtime
+	}
+
+	recs := make([]events.Shard, 0, len(shards))
+	for _, id := range shards {
+		b, err := v.fetch(ctx, id)
+		if err != nil {
+			if !isProofError(err) {
+				return events.Block{}, nil, err
+			}
+			proofIssue(id, err)
+			continue
+		}
+		txs, err := countTransactions(b)
+		if err != nil {
+			return events.Block{}, nil, fmt.Errorf("count transactions of %s: %w", blockName(id), err)
+		}
+		recs = append(recs, events.NewShard(id, b.BlockInfo.GenUtime, txs))
+	}
+	return events.NewBlock(master, genUtime, recs), issues, nil
+}
+
+type proofError struct{ error }
+
+func isProofError(err error) bool {
+	_, ok := err.(proofError)
+	return ok
+}
+
+// fetch loads the raw block and checks it hashes to id.
+func (v *Verifier) fetch(ctx context.Context, id *ton.BlockIDExt) (*tlb.Block, error) {
+	var resp tl.Serializable
+	if err := v.api.Client().QueryLiteserver(ctx, ton.GetBlockData{ID: id}, &resp); err != nil {
+		return nil, fmt.Errorf("get block data %s: %w", blockName(id), err)
+	}
+	switch t := resp.(type) {
+	case ton.BlockData:
+		if sum := sha256.Sum256(t.Payload); !bytes.Equal(sum[:], id.FileHash) {
+			return nil, proofError{fmt.Errorf("file hash is %x", sum)}
+		}
+		root, err := cell.FromBOC(t.Payload)
+		if err != nil {
+			return nil, proofError{fmt.Errorf("parse boc: %w", err)}
+		}
+		if !bytes.Equal(root.Hash(), id.RootHash) {
+			return nil, proofError{fmt.Errorf("root hash is %x", root.Hash())}
+		}
+		var b tlb.Block
+		if err = tlb.LoadFromCell(&b, root.BeginParse()); err != nil {
+			return nil, proofError{fmt.Errorf("parse block: %w", err)}
+		}
+		return &b, nil
+	case ton.LSError:
+		return nil, fmt.Errorf("get block data %s: %w", blockName(id), t)
+	}
+	return nil, fmt.Errorf("get block data %s: unexpected response %T", blockName(id), resp)
+}
+
+func countTransactions(b *tlb.Block) (int, error) {
+	dict, err := b.Extra.ShardAccountBlocks.BeginParse().LoadDict(256)
+	if err != nil {
+		return 0, fmt.Errorf("load shard account blocks: %w", err)
+	}
+	n := 0
+	for _, kv := range dict.All() {
+		slc := kv.Value.BeginParse()
+		if err = tlb.LoadFromCell(&tlb.CurrencyCollection{}, slc); err != nil {
+			return 0, fmt.Errorf("load account block currency: %w", err)
+		}
+		var ab tlb.AccountBlock
+		if err = tlb.LoadFromCell(&ab, slc); err != nil {
+			return 0, fmt.Errorf("load account block: %w", err)
+		}
+		n += len(ab.Transactions.All())
+	}
+	return n, nil
+}
+
+// compare reports the differences of the indexed record from actual.
+func compare(rec, actual events.Block) []Issue {
+	var issues []Issue
+	issue := func(kind, block, details string) {
+		issues = append(issues, Issue{Kind: kind, Master: actual.Seqno, Block: block, Details: details})
+	}
+
+	recID, err := rec.ID()
+	actualID, _ := actual.ID()
+	if err != nil {
+		issue(MasterMismatch, "", err.Error())
+	} else if traverse.KeyOf(recID) != traverse.KeyOf(actualID) {
+		issue(MasterMismatch, blockName(actualID), fmt.Sprintf("indexed root hash %s, file hash %s", rec.RootHash, rec.FileHash))
+	} else if rec.GenUtime != actual.GenUtime {
+		issue(DataMismatch, blockName(actualID), fmt.Sprintf("gen_utime %d, indexed %d", actual.GenUtime, rec.GenUtime))
+	}
+
+	want := map[traverse.Key]events.Shard{}
+	for _, s := range actual.Shards {
+		id, _ := s.ID()
+		want[traverse.KeyOf(id)] = s
+	}
+	for _, s := range rec.Shards {
+		id, err := s.ID()
+		if err != nil {
+			issue(DataMismatch, "", fmt.Sprintf("indexed shard: %v", err))
+			continue
+		}
+		key := traverse.KeyOf(id)
+		w, ok := want[key]
+		if !ok {
+			issue(UnreferencedShard, blockName(id), "")
+			continue
+		}
+		delete(want, key)
+		if w.GenUtime != s.GenUtime || w.Transactions != s.Transactions {
+			issue(DataMismatch, blockName(id), fmt.Sprintf("gen_utime %d, %d transactions, indexed %d, %d",
+				w.GenUtime, w.Transactions, s.GenUtime, s.Transactions))
+		}
+	}
+	for _, s := range want {
+		id, _ := s.ID()
+		issue(MissingShard, blockName(id), "")
+	}
+	return issues
+}
+
+func blockName(id *ton.BlockIDExt) string {
+	return fmt.Sprintf("%d:%016x:%d", id.Workchain, uint64(id.Shard), id.SeqNo)
+}
@@ -0,0 +1,173 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/chain"
+)
+
+// Signature headers. SignatureHeader is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the webhook secret.
+const (
+	IDHeader        = "X-Webhook-ID"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+type Delivery struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Deposit     Deposit   `json:"deposit"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// Sign returns the signature of body sent at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Service) deliverDue(ctx context.Context) {
+	now := time.Now()
+
+	s.mx.Lock()
+	var due []Delivery
+	for id, d := range s.st.Pending {
+		if !s.inFlight[id] && !d.NextAttempt.After(now) {
+			s.inFlight[id] = true
+			due = append(due, *d)
+		}
+	}
+	s.mx.Unlock()
+
+	for _, d := range due {
+		go func(d Delivery) {
+			err := s.send(ctx, d)
+
+			s.mx.Lock()
+			defer s.mx.Unlock()
+			delete(s.inFlight, d.ID)
+			s.finish(d.ID, err)
+		}(d)
+	}
+}
+
+// finish must be called with mx held.
+func (s *Service) finish(id string, err error) {
+	d, ok := s.st.Pending[id]
+	if !ok {
+		return
+	}
+	if err == nil {
+		delete(s.st.Pending, id)
+		s.save()
+		return
+	}
+
+	d.Attempts++
+	d.LastError = err.Error()
+	if d.Attempts >= s.cfg.MaxAttempts {
+		s.log.Warn().Err(err).Str("id", id).Int("attempts", d.Attempts).Msg("webhook moved to dead letters")
+		delete(s.st.Pending, id)
+		s.st.Dead[id] = d
+	} else {
+		d.NextAttempt = time.Now().Add(s.backoff(d.Attempts)).UTC()
+	}
+	s.save()
+}
+
+// backoff doubles the delay after every failed attempt up to MaxBackoff.
+func (s *Service) backoff(attempts int) time.Duration {
+	d := s.cfg.InitialBackoff.Duration
+	for i := 1; i < attempts && d < s.cfg.MaxBackoff.Duration; i++ {
+		d *= 2
+	}
+	return min(d, s.cfg.MaxBackoff.Duration)
+}
+
+func (s *Service) send(ctx context.Context, d Delivery) error {
+	body, err := json.Marshal(d.Deposit)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IDHeader, d.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(SignatureHeader, Sign(s.cfg.Secret, ts, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback responded %s", resp.Status)
+	}
+	return nil
+}
+
+// DeadLetters lists deliveries that ran out of attempts, oldest first.
+func (s *Service) DeadLetters() []Delivery {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	res := make([]Delivery, 0, len(s.st.Dead))
+	for _, d := range s.st.Dead {
+		res = append(res, *d)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+	return res
+}
+
+// Replay queues dead letters again with a fresh attempt budget. An empty ids
+// replays all of them.
+func (s *Service) Replay(ids []string) ([]string, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if len(ids) == 0 {
+		for id := range s.st.Dead {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range ids {
+		if _, ok := s.st.Dead[id]; !ok {
+			return nil, fmt.Errorf("%w: no dead letter %s", chain.ErrNotFound, id)
+		}
+	}
+	for _, id := range ids {
+		d := s.st.Dead[id]
+		delete(s.st.Dead, id)
+		d.Attempts = 0
+		d.NextAttempt = time.Now().UTC()
+		s.st.Pending[id] = d
+	}
+	s.save()
+	sort.Strings(ids)
+	return ids, nil
+}
@@ -0,0 +1,302 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+)
+
+const (
+	KindTON    = "ton"
+	KindJetton = "jetton"
+)
+
+// Deposit is the webhook payload. Amount is in nanotons, or jetton base units
+// for jetton deposits.
+type Deposit struct {
+	ID            string `json:"id"`
+	Kind          string `json:"kind"`
+	Address       string `json:"address"`
+	Label         string `json:"label,omitempty"`
+	Amount        string `json:"amount"`
+	JettonMaster  string `json:"jetton_master,omitempty"`
+	JettonWallet  string `json:"jetton_wallet,omitempty"`
+	Sender        string `json:"sender,omitempty"`
+	Comment       string `json:"comment,omitempty"`
+	TxHash        string `json:"tx_hash"`
+	LT            uint64 `json:"lt"`
+	Now           uint32 `json:"now"`
+	MasterSeqno   uint32 `json:"master_seqno"`
+	Confirmations uint32 `json:"confirmations"`
+}
+
+// Received is a transaction of a watched address waiting for its deposit to
+// be recorded. It is kept until that succeeds, the transaction itself only
+// in memory, it is fetched again after a restart.
+type Received struct {
+	// Address is raw.
+	Address     string    `json:"address"`
+	LT          uint64    `json:"lt"`
+	TxHash      string    `json:"tx_hash"`
+	MasterSeqno uint32    `json:"master_seqno"`
+	Attempts    int       `json:"attempts,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	NextAttempt time.Time `json:"next_attempt"`
+
+	tx *tlb.Transaction
+}
+
+// HandleTransaction keeps tx of addr when addr is watched. A deposit is
+// recorded from it by Run, which may need the liteserver, and sent once
+// enough masterchain blocks are processed.
+func (s *Service) HandleTransaction(addr *address.Address, tx *tlb.Transaction, masterSeqno uint32) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	raw := chain.RawAddr(addr)
+	if _, ok := s.st.Watches[raw]; !ok {
+		return
+	}
+	hash := hex.EncodeToString(tx.Hash)
+	if _, ok := s.st.Received[hash]; ok {
+		return
+	}
+	// saved before the scanner moves on, so a restart doesn't lose it
+	s.st.Received[hash] = &Received{
+		Address:     raw,
+		LT:          tx.LT,
+		TxHash:      hash,
+		MasterSeqno: masterSeqno,
+		NextAttempt: time.Now().UTC(),
+		tx:          tx,
+	}
+	s.save()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// recordDeposits records the deposits of received transactions until ctx
+// is done, retrying the ones that failed with a backoff.
+func (s *Service) recordDeposits(ctx context.Context) {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-tick.C:
+		}
+		s.recordDue(ctx)
+	}
+}
+
+// recordDue records the deposits of the received transactions that are
+// due.
+func (s *Service) recordDue(ctx context.Context) {
+	for _, rc := range s.dueReceived() {
+		d, tx, err := s.record(ctx, rc)
+		s.mx.Lock()
+		s.recorded(rc.TxHash, d, tx, err)
+		s.mx.Unlock()
+	}
+}
+
+// dueReceived returns the received transactions due to be recorded, oldest
+// first.
+func (s *Service) dueReceived() []Received {
+	now := time.Now()
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	var due []Received
+	for _, rc := range s.st.Received {
+		if !rc.NextAttempt.After(now) {
+			due = append(due, *rc)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].LT < due[j].LT
+	})
+	return due
+}
+
+// record returns the deposit rc made, nil for none, and the transaction of
+// rc.
+func (s *Service) record(ctx context.Context, rc Received) (*Deposit, *tlb.Transaction, error) {
+	addr, err := address.ParseRawAddr(rc.Address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w %q: %w", chain.ErrBadAddress, rc.Address, err)
+	}
+	s.mx.Lock()
+	w, ok := s.st.Watches[rc.Address]
+	var watch Watch
+	if ok {
+		watch = *w
+	}
+	s.mx.Unlock()
+	if !ok {
+		return nil, nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.decodeTimeout)
+	defer cancel()
+	tx := rc.tx
+	if tx == nil {
+		hash, err := hex.DecodeString(rc.TxHash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: tx hash %q", chain.ErrInvalidInput, rc.TxHash)
+		}
+		if tx, err = s.ln.Transaction(ctx, addr, rc.LT, hash); err != nil {
+			return nil, nil, err
+		}
+	}
+	info, err := s.ln.DecodeTransaction(ctx, addr, tx)
+	if err != nil {
+		return nil, tx, err
+	}
+	d, err := s.deposit(ctx, &watch, info)
+	if err != nil {
+		return nil, tx, err
+	}
+	if d != nil {
+		d.MasterSeqno = rc.MasterSeqno
+	}
+	return d, tx, nil
+}
+
+// recorded finishes recording the deposit of the received transaction of
+// hash, it must be called with mx held.
+func (s *Service) recorded(hash string, d *Deposit, tx *tlb.Transaction, err error) {
+	rc, ok := s.st.Received[hash]
+	if !ok {
+		return
+	}
+	if err != nil {
+		if tx != nil {
+			rc.tx = tx
+		}
+		rc.Attempts++
+		rc.LastError = err.Error()
+		rc.NextAttempt = time.Now().Add(s.backoff(rc.Attempts)).UTC()
+		s.log.Warn().Err(err).Str("tx", hash).Int("attempts", rc.Attempts).Msg("record deposit")
+		s.save()
+		return
+	}
+	delete(s.st.Received, hash)
+	if d != nil {
+		s.st.Unconfirmed[d.ID] = d
+	}
+	s.save()
+}
+
+// deposit returns nil for transactions that didn't credit the account.
+func (s *Service) deposit(ctx context.Context, w *Watch, tx *chain.TxInfo) (*Deposit, error) {
+	in := tx.In
+	if in == nil || in.Type != string(tlb.MsgTypeInternal) || in.Bounced {
+		return nil, nil
+	}
+	// value of a bounceable message to a failed transaction goes back
+	if tx.Aborted && in.Bounce {
+		return nil, nil
+	}
+
+	d := &Deposit{
+		ID:      tx.Hash,
+		Kind:    KindTON,
+		Address: w.Address,
+		Label:   w.Label,
+		Amount:  in.Amount,
+		Sender:  in.Src,
+		Comment: in.Comment,
+		TxHash:  tx.Hash,
+		LT:      tx.LT,
+		Now:     tx.Now,
+	}
+
+	// jettons arrive as a notification from our jetton wallet, which is only
+	// sent when the transfer carries a forward amount
+	if jt := tx.Jetton; jt != nil && jt.Kind == "transfer_notification" && jt.Master != "" {
+		genuine, err := s.genuineJetton(ctx, jt, tx.Account)
+		if err != nil {
+			return nil, err
+		}
+		if genuine {
+			d.Kind = KindJetton
+			d.Amount = jt.Amount
+			d.JettonMaster = jt.Master
+			d.JettonWallet = jt.Wallet
+			d.Sender = jt.Sender
+			d.Comment = ""
+		}
+	}
+	if d.Kind == KindTON && (d.Amount == "" || d.Amount == "0") {
+		return nil, nil
+	}
+	return d, nil
+}
+
+// genuineJetton reports whether the notification jt was sent by the jetton
+// wallet of owner, an error means it couldn't be told.
+func (s *Service) genuineJetton(ctx context.Context, jt *chain.JettonTransfer, owner string) (bool, error) {
+	master, err1 := address.ParseAddr(jt.Master)
+	wallet, err2 := address.ParseAddr(jt.Wallet)
+	ownerAddr, err3 := address.ParseAddr(owner)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return false, nil
+	}
+	ok, err := s.ln.IsJettonWallet(ctx, master, ownerAddr, wallet)
+	if err != nil {
+		return false, fmt.Errorf("verify jetton wallet %s: %w", jt.Wallet, err)
+	}
+	if !ok {
+		s.log.Warn().Str("wallet", jt.Wallet).Str("master", jt.Master).Msg("ignoring jetton notification, sender is not a wallet of the master")
+	}
+	return ok, nil
+}
+
+// HandleBlock queues the deposits that reached the confirmation threshold
+// with masterchain block seqno.
+func (s *Service) HandleBlock(seqno uint32) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	changed := false
+	for id, d := range s.st.Unconfirmed {
+		if seqno < d.MasterSeqno {
+			continue
+		}
+		d.Confirmations = seqno - d.MasterSeqno + 1
+		if d.Confirmations < s.cfg.Confirmations {
+			continue
+		}
+		delete(s.st.Unconfirmed, id)
+		s.st.Pending[id] = &Delivery{
+			ID:          id,
+			URL:         s.callbackURL(d.Address),
+			Deposit:     *d,
+			CreatedAt:   time.Now().UTC(),
+			NextAttempt: time.Now().UTC(),
+		}
+		changed = true
+	}
+	if changed {
+		s.save()
+	}
+}
+
+// callbackURL must be called with mx held.
+func (s *Service) callbackURL(addr string) string {
+	if a, err := address.ParseAddr(addr); err == nil {
+		if w, ok := s.st.Watches[chain.RawAddr(a)]; ok && w.CallbackURL != "" {
+			return w.CallbackURL
+		}
+	}
+	return s.cfg.URL
+}
@@ -0,0 +1,225 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/store"
+	"github.com/rs/zerolog"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+)
+
+var errLiteserver = errors.New("liteserver down")
+
+// fakeLite serves the decoded form of transactions, failing while err is
+// set.
+type fakeLite struct {
+	err     error
+	info    *chain.TxInfo
+	genuine bool
+	txs     map[uint64]*tlb.Transaction
+	fetched int
+}
+
+func (f *fakeLite) Transaction(_ context.Context, _ *address.Address, lt uint64, _ []byte) (*tlb.Transaction, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.fetched++
+	tx, ok := f.txs[lt]
+	if !ok {
+		return nil, chain.ErrNotFound
+	}
+	return tx, nil
+}
+
+func (f *fakeLite) DecodeTransaction(_ context.Context, _ *address.Address, tx *tlb.Transaction) (*chain.TxInfo, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	info := *f.info
+	info.Hash, info.LT = hex.EncodeToString(tx.Hash), tx.LT
+	return &info, nil
+}
+
+func (f *fakeLite) IsJettonWallet(context.Context, *address.Address, *address.Address, *address.Address) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	return f.genuine, nil
+}
+
+var (
+	watched = address.NewAddress(0, 0, make([]byte, 32))
+	other   = address.NewAddress(0, 0, append(make([]byte, 31), 1))
+)
+
+func newService(t *testing.T, dir string, ln liteClient) *Service {
+	t.Helper()
+	file, err := store.Open(dir, "webhooks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{
+		cfg: config.WebhookConfig{
+			Confirmations:  1,
+			InitialBackoff: config.Duration{Duration: time.Nanosecond},
+			MaxBackoff:     config.Duration{Duration: time.Nanosecond},
+		},
+		ln:   ln,
+		file: file,
+		log:  zerolog.Nop(),
+		st: state{
+			Watches:     map[string]*Watch{chain.RawAddr(watched): {Address: watched.String()}},
+			Received:    map[string]*Received{},
+			Unconfirmed: map[string]*Deposit{},
+			Pending:     map[string]*Delivery{},
+			Dead:        map[string]*Delivery{},
+		},
+		inFlight:      map[string]bool{},
+		decodeTimeout: time.Second,
+		wake:          make(chan struct{}, 1),
+	}
+	if err = file.Load(&s.st); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testTx(lt uint64) *tlb.Transaction {
+	hash := make([]byte, 32)
+	hash[31] = byte(lt)
+	return &tlb.Transaction{LT: lt, Hash: hash}
+}
+
+func tonDeposit() *chain.TxInfo {
+	return &chain.TxInfo{
+		Account: watched.String(),
+		In:      &chain.MsgInfo{Type: string(tlb.MsgTypeInternal), Src: other.String(), Amount: "1000"},
+	}
+}
+
+func jettonDeposit() *chain.TxInfo {
+	info := tonDeposit()
+	info.Jetton = &chain.JettonTransfer{
+		Kind:   "transfer_notification",
+		Master: other.String(),
+		Wallet: other.String(),
+		Amount: "5",
+		Sender: other.String(),
+	}
+	return info
+}
+
+func TestReceivedSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	tx := testTx(10)
+	lite := &fakeLite{err: errLiteserver, info: tonDeposit(), txs: map[uint64]*tlb.Transaction{10: tx}}
+	s := newService(t, dir, lite)
+	s.HandleTransaction(watched, tx, 100)
+	s.HandleTransaction(other, testTx(11), 100)
+	s.recordDue(context.Background())
+
+	// the liteserver failed, the transaction waits in the saved state
+	restarted := newService(t, dir, lite)
+	rc, ok := restarted.st.Received[hex.EncodeToString(tx.Hash)]
+	if !ok || len(restarted.st.Received) != 1 {
+		t.Fatalf("received after restart = %+v, want the transaction of the watched address", restarted.st.Received)
+	}
+	if rc.Attempts != 1 || rc.LastError == "" || rc.MasterSeqno != 100 {
+		t.Errorf("received = %+v, want one failed attempt", rc)
+	}
+
+	lite.err = nil
+	restarted.recordDue(context.Background())
+	if lite.fetched != 1 {
+		t.Errorf("transaction fetched %d times after restart, want 1", lite.fetched)
+	}
+	if len(restarted.st.Received) != 0 {
+		t.Errorf("received = %+v, want none left", restarted.st.Received)
+	}
+	d, ok := restarted.st.Unconfirmed[hex.EncodeToString(tx.Hash)]
+	if !ok || d.Kind != KindTON || d.Amount != "1000" || d.MasterSeqno != 100 {
+		t.Errorf("deposit = %+v, want 1000 nanoton at seqno 100", d)
+	}
+}
+
+func TestJettonVerificationRetried(t *testing.T) {
+	tx := testTx(10)
+	lite := &fakeLite{info: jettonDeposit(), genuine: true}
+	s := newService(t, t.TempDir(), &failingJettons{fakeLite: lite})
+	s.HandleTransaction(watched, tx, 100)
+	s.recordDue(context.Background())
+
+	// not downgraded to a TON deposit while the wallet can't be checked
+	if len(s.st.Unconfirmed) != 0 || len(s.st.Received) != 1 {
+		t.Fatalf("unconfirmed = %+v, want none before the jetton wallet is verified", s.st.Unconfirmed)
+	}
+	s.ln = lite
+	s.recordDue(context.Background())
+	d, ok := s.st.Unconfirmed[hex.EncodeToString(tx.Hash)]
+	if !ok || d.Kind != KindJetton || d.Amount != "5" {
+		t.Errorf("deposit = %+v, want 5 jetton units", d)
+	}
+}
+
+// failingJettons fails to verify jetton wallets.
+type failingJettons struct {
+	*fakeLite
+}
+
+func (f *failingJettons) IsJettonWallet(context.Context, *address.Address, *address.Address, *address.Address) (bool, error) {
+	return false, errLiteserver
+}
+
+func TestDeposit(t *testing.T) {
+	tests := []struct {
+		name     string
+		info     func() *chain.TxInfo
+		genuine  bool
+		wantKind string
+	}{
+		{name: "ton", info: tonDeposit, wantKind: KindTON},
+		{name: "jetton", info: jettonDeposit, genuine: true, wantKind: KindJetton},
+		{name: "jetton from another wallet", info: jettonDeposit, wantKind: KindTON},
+		{name: "bounced", info: func() *chain.TxInfo {
+			info := tonDeposit()
+			info.In.Bounced = true
+			return info
+		}},
+		{name: "bounceable to failed transaction", info: func() *chain.TxInfo {
+			info := tonDeposit()
+			info.Aborted, info.In.Bounce = true, true
+			return info
+		}},
+		{name: "external", info: func() *chain.TxInfo {
+			info := tonDeposit()
+			info.In.Type = string(tlb.MsgTypeExternalIn)
+			return info
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newService(t, t.TempDir(), &fakeLite{genuine: tt.genuine})
+			d, err := s.deposit(context.Background(), &Watch{Address: watched.String()}, tt.info())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantKind == "" {
+				if d != nil {
+					t.Errorf("deposit() = %+v, want none", d)
+				}
+				return
+			}
+			if d == nil || d.Kind != tt.wantKind {
+				t.Errorf("deposit() = %+v, want a %s deposit", d, tt.wantKind)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+// Package webhooks notifies clients about deposits to watched addresses.
+//
+// Errors wrap the chain package sentinels, so handlers map them to statuses
+// the same way as liteserver errors.
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/FishDontExist/TONindexer/chain"
+	"github.com/FishDontExist/TONindexer/config"
+	"github.com/FishDontExist/TONindexer/store"
+	"github.com/rs/zerolog"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+)
+
+// ErrManaged is returned for changes to watches owned by the deposits
+// registry, which can't be replaced or removed through the api.
+var ErrManaged = errors.New("watch is managed by the deposits registry")
+
+type Watch struct {
+	Address     string `json:"address"`
+	CallbackURL string `json:"callback_url,omitempty"`
+	Label       string `json:"label,omitempty"`
+	// Deposit is set on watches of deposit addresses, which always notify
+	// the default url.
+	Deposit   bool      `json:"deposit,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// state is everything persisted between restarts.
+type state struct {
+	// Watches and pending deposits are keyed by raw address and tx hash.
+	Watches     map[string]*Watch    `json:"watches"`
+	Received    map[string]*Received `json:"received"`
+	Unconfirmed map[string]*Deposit  `json:"unconfirmed"`
+	Pending     map[string]*Delivery `json:"pending"`
+	Dead        map[string]*Delivery `json:"dead"`
+}
+
+// liteClient is what of the liteserver client recording deposits needs.
+type liteClient interface {
+	Transaction(ctx context.Context, addr *address.Address, lt uint64, hash []byte) (*tlb.Transaction, error)
+	DecodeTransaction(ctx context.Context, addr *address.Address, tx *tlb.Transaction) (*chain.TxInfo, error)
+	IsJettonWallet(ctx context.Context, master, owner, wallet *address.Address) (bool, error)
+}
+
+type Service struct {
+	cfg    config.WebhookConfig
+	ln     liteClient
+	client *http.Client
+	file   *store.File
+	log    zerolog.Logger
+
+	// decodeTimeout bounds decoding a transaction of a watched address.
+	decodeTimeout time.Duration
+
+	mx       sync.Mutex
+	st       state
+	inFlight map[string]bool
+	// wake signals newly received transactions.
+	wake chan struct{}
+}
+
+func New(cfg *config.Config, ln *chain.LiteClient, lg zerolog.Logger) (*Service, error) {
+	file, err := store.Open(cfg.Storage.Dir, "webhooks.json")
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{
+		cfg:    cfg.Webhook,
+		ln:     ln,
+		client: &http.Client{Timeout: cfg.Webhook.Timeout.Duration},
+		file:   file,
+		log:    lg.With().Str("component", "webhooks").Logger(),
+		st: state{
+			Watches:     map[string]*Watch{},
+			Received:    map[string]*Received{},
+			Unconfirmed: map[string]*Deposit{},
+			Pending:     map[string]*Delivery{},
+			Dead:        map[string]*Delivery{},
+		},
+		inFlight:      map[string]bool{},
+		decodeTimeout: cfg.HTTP.RequestTimeout.Duration,
+		wake:          make(chan struct{}, 1),
+	}
+	if err = file.Load(&s.st); err != nil {
+		return nil, fmt.Errorf("load webhooks: %w", err)
+	}
+	return s, nil
+}
+
+// save must be called with mx held.
+func (s *Service) save() {
+	if err := s.file.Save(&s.st); err != nil {
+		s.log.Error().Err(err).Msg("save webhooks")
+	}
+}
+
+// Watch starts sending deposits to addr to callbackURL, or to the default
+// url when it's empty. Watching an address again replaces its settings,
+// unless it is a deposit address, which fails with ErrManaged.
+func (s *Service) Watch(addr, callbackURL, label string) (*Watch, error) {
+	return s.watch(addr, callbackURL, label, false)
+}
+
+// WatchDeposit watches a deposit address of the registry with the default
+// url, taking over any watch the address had.
+func (s *Service) WatchDeposit(addr, label string) (*Watch, error) {
+	return s.watch(addr, "", label, true)
+}
+
+func (s *Service) watch(addr, callbackURL, label string, deposit bool) (*Watch, error) {
+	if s.cfg.Secret == "" {
+		return nil, fmt.Errorf("%w: webhook.secret is not configured", chain.ErrInvalidInput)
+	}
+	a, err := address.ParseAddr(addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", chain.ErrBadAddress, addr, err)
+	}
+	target := callbackURL
+	if target == "" {
+		target = s.cfg.URL
+	}
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: callback_url must be an absolute http(s) url", chain.ErrInvalidInput)
+	}
+
+	w := &Watch{
+		Address:     addr,
+		CallbackURL: callbackURL,
+		Label:       label,
+		Deposit:     deposit,
+		CreatedAt:   time.Now().UTC(),
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	key := chain.RawAddr(a)
+	if old, ok := s.st.Watches[key]; ok && old.Deposit && !deposit {
+		return nil, fmt.Errorf("%w: %s", ErrManaged, addr)
+	}
+	s.st.Watches[key] = w
+	s.save()
+	return w, nil
+}
+
+// Unwatch removes the watch of addr, deposit addresses fail with
+// ErrManaged.
+func (s *Service) Unwatch(addr string) error {
+	a, err := address.ParseAddr(addr)
+	if err != nil {
+		return fmt.Errorf("%w %q: %w", chain.ErrBadAddress, addr, err)
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	key := chain.RawAddr(a)
+	w, ok := s.st.Watches[key]
+	if !ok {
+		return fmt.Errorf("%w: %s is not watched", chain.ErrNotFound, addr)
+	}
+	if w.Deposit {
+		return fmt.Errorf("%w: %s", ErrManaged, addr)
+	}
+	delete(s.st.Watches, key)
+	s.save()
+	return nil
+}
+
+func (s *Service) Watches() []Watch {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	res := make([]Watch, 0, len(s.st.Watches))
+	for _, w := range s.st.Watches {
+		res = append(res, *w)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+	return res
+}
+
+// WatchedDeposit reports whether the raw address is watched as a deposit
+// address.
+func (s *Service) WatchedDeposit(raw string) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	w, ok := s.st.Watches[raw]
+	return ok && w.Deposit
+}
+
+// Run records deposits of received transactions and delivers pending
+// webhooks until ctx is done.
+func (s *Service) Run(ctx context.Context) {
+	go s.recordDeposits(ctx)
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			s.deliverDue(ctx)
+		}
+	}
+}